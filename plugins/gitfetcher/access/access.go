@@ -0,0 +1,285 @@
+package access
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Ways a request can authenticate
+const (
+	MethodBasic   = "basic"   // HTTP basic auth against the users file
+	MethodToken   = "token"   // Authorization: Bearer with a static API token
+	MethodSession = "session" // cookie set by the web UI login
+)
+
+// SessionCookie holds the session id of a web UI login
+const SessionCookie = "gitfetcher_session"
+
+// Principal is the user or token a request was authenticated as
+type Principal struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Method string `json:"method"`
+}
+
+// Allows reports whether role grants the permissions of required
+func Allows(role, required string) bool {
+	return rank(required) > 0 && rank(role) >= rank(required)
+}
+
+func rank(role string) int {
+	switch role {
+	case config.RoleViewer:
+		return 1
+	case config.RoleOperator:
+		return 2
+	case config.RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+type user struct {
+	hash []byte
+	role string
+}
+
+type token struct {
+	name string
+	role string
+	sum  [sha256.Size]byte
+}
+
+type session struct {
+	principal Principal
+	expires   time.Time
+	// method and credential are what the session was started with, it is
+	// only kept across a reload while they are unchanged
+	method     string
+	credential []byte
+}
+
+// Authenticator checks passwords, API tokens and web UI sessions.
+// Sessions are kept in memory, a restart logs everybody out.
+type Authenticator struct {
+	users     map[string]user
+	tokens    []token
+	ttl       time.Duration
+	dummyHash []byte // compared for unknown users so they take as long as known ones
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// New loads the users file and reads the token secrets of cfg
+func New(cfg config.WebAuthConfig) (*Authenticator, error) {
+	ttl, err := cfg.ParseSessionTTL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid session_ttl: %w", err)
+	}
+
+	a := &Authenticator{
+		users:    make(map[string]user),
+		ttl:      ttl,
+		sessions: make(map[string]session),
+	}
+
+	if cfg.UsersFile != "" {
+		f, err := os.Open(cfg.UsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open users file: %w", err)
+		}
+		defer f.Close()
+		if a.users, err = readUsers(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.UsersFile, err)
+		}
+	}
+
+	for _, t := range cfg.Tokens {
+		secret, err := t.Secret()
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %w", t.Name, err)
+		}
+		a.tokens = append(a.tokens, token{name: t.Name, role: t.Role, sum: sha256.Sum256([]byte(secret))})
+	}
+
+	a.dummyHash, err = bcrypt.GenerateFromPassword([]byte("gitfetcher"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// readUsers parses one "username:bcrypt-hash[:role]" entry per line, the
+// output of `htpasswd -nB` with the role appended. The role defaults to viewer.
+func readUsers(r io.Reader) (map[string]user, error) {
+	users := make(map[string]user)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("line %d: expected username:hash[:role]", n)
+		}
+		name, hash, role := fields[0], []byte(fields[1]), config.RoleViewer
+		if len(fields) == 3 {
+			role = fields[2]
+		}
+		if !config.ValidRole(role) {
+			return nil, fmt.Errorf("line %d: unknown role '%s'", n, role)
+		}
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("line %d: password of %s is not a bcrypt hash", n, name)
+		}
+		if _, ok := users[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate user %s", n, name)
+		}
+		users[name] = user{hash: hash, role: role}
+	}
+	return users, scanner.Err()
+}
+
+// Password checks a username and password from the users file
+func (a *Authenticator) Password(username, password string) (Principal, bool) {
+	u, ok := a.users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return Principal{}, false
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return Principal{}, false
+	}
+	return Principal{Name: username, Role: u.role, Method: MethodBasic}, true
+}
+
+// Token checks a static API token
+func (a *Authenticator) Token(value string) (Principal, bool) {
+	sum := sha256.Sum256([]byte(value))
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.sum[:]) == 1 {
+			return Principal{Name: t.name, Role: t.role, Method: MethodToken}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// Authenticate checks the Authorization header of r, or its session cookie
+// when there is none. Invalid credentials never fall back to the cookie.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if value, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return a.Token(strings.TrimSpace(value))
+		}
+		if username, password, ok := r.BasicAuth(); ok {
+			return a.Password(username, password)
+		}
+		return Principal{}, false
+	}
+
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return Principal{}, false
+	}
+	return a.Session(cookie.Value)
+}
+
+// Login starts a session for p and returns its id and expiry
+func (a *Authenticator) Login(p Principal) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+	expires := time.Now().Add(a.ttl)
+	method, credential := p.Method, a.credential(p.Method, p.Name, p.Role)
+	p.Method = MethodSession
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for sid, s := range a.sessions {
+		if time.Now().After(s.expires) {
+			delete(a.sessions, sid)
+		}
+	}
+	a.sessions[id] = session{principal: p, expires: expires, method: method, credential: credential}
+	return id, expires, nil
+}
+
+// Session returns the principal of an unexpired session
+func (a *Authenticator) Session(id string) (Principal, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[id]
+	if !ok {
+		return Principal{}, false
+	}
+	if time.Now().After(s.expires) {
+		delete(a.sessions, id)
+		return Principal{}, false
+	}
+	return s.principal, true
+}
+
+// Logout ends a session
+func (a *Authenticator) Logout(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, id)
+}
+
+// KeepSessions takes over the unexpired sessions of old whose user or token
+// still exists with the same password hash, token and role, so reloading
+// web_auth only logs out those whose access changed
+func (a *Authenticator) KeepSessions(old *Authenticator) {
+	old.mu.Lock()
+	sessions := maps.Clone(old.sessions)
+	old.mu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, s := range sessions {
+		if time.Now().After(s.expires) || s.credential == nil {
+			continue
+		}
+		if bytes.Equal(a.credential(s.method, s.principal.Name, s.principal.Role), s.credential) {
+			a.sessions[id] = s
+		}
+	}
+}
+
+// credential returns the password hash or token digest that authenticates
+// name with role via method, nil if there is none
+func (a *Authenticator) credential(method, name, role string) []byte {
+	switch method {
+	case MethodBasic:
+		if u, ok := a.users[name]; ok && u.role == role {
+			return u.hash
+		}
+	case MethodToken:
+		for _, t := range a.tokens {
+			if t.name == name && t.role == role {
+				return t.sum[:]
+			}
+		}
+	}
+	return nil
+}
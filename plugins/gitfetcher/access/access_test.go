@@ -0,0 +1,228 @@
+package access
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	dir := t.TempDir()
+	usersFile := filepath.Join(dir, "users")
+	users := "# gitfetcher users\n" +
+		"alice:" + hash(t, "alice-pw") + ":admin\n" +
+		"\n" +
+		"bob:" + hash(t, "bob-pw") + "\n"
+	if err := os.WriteFile(usersFile, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_GITFETCHER_CI_TOKEN", "ci-secret")
+
+	a, err := New(config.WebAuthConfig{
+		UsersFile: usersFile,
+		Tokens:    []config.APIToken{{Name: "ci", Role: config.RoleOperator, TokenEnv: "TEST_GITFETCHER_CI_TOKEN"}},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	return a
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{config.RoleAdmin, config.RoleOperator, true},
+		{config.RoleOperator, config.RoleOperator, true},
+		{config.RoleViewer, config.RoleOperator, false},
+		{"", config.RoleViewer, false},
+		{config.RoleAdmin, "root", false},
+	}
+	for _, tt := range tests {
+		if got := Allows(tt.role, tt.required); got != tt.want {
+			t.Errorf("Allows(%q, %q) = %v, expected %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestReadUsers(t *testing.T) {
+	h := hash(t, "pw")
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{"valid", "alice:" + h + ":operator\n", ""},
+		{"missing hash", "alice\n", "expected username:hash[:role]"},
+		{"unknown role", "alice:" + h + ":root\n", "unknown role"},
+		{"plain password", "alice:secret\n", "not a bcrypt hash"},
+		{"duplicate", "alice:" + h + "\nalice:" + h + "\n", "duplicate user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := readUsers(strings.NewReader(tt.file))
+			if tt.wantErr == "" {
+				if err != nil || users["alice"].role != config.RoleOperator {
+					t.Errorf("Expected alice as operator, got %+v (%v)", users, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing '%s', got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPasswordAndToken(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	if p, ok := a.Password("alice", "alice-pw"); !ok || p.Role != config.RoleAdmin {
+		t.Errorf("Expected alice to be an admin, got %+v (%v)", p, ok)
+	}
+	if p, ok := a.Password("bob", "bob-pw"); !ok || p.Role != config.RoleViewer {
+		t.Errorf("Expected bob to default to viewer, got %+v (%v)", p, ok)
+	}
+	if _, ok := a.Password("alice", "bob-pw"); ok {
+		t.Error("Expected wrong password to be rejected")
+	}
+	if _, ok := a.Password("mallory", "alice-pw"); ok {
+		t.Error("Expected unknown user to be rejected")
+	}
+
+	if p, ok := a.Token("ci-secret"); !ok || p.Name != "ci" || p.Role != config.RoleOperator || p.Method != MethodToken {
+		t.Errorf("Expected ci token as operator, got %+v (%v)", p, ok)
+	}
+	if _, ok := a.Token("ci-secre"); ok {
+		t.Error("Expected wrong token to be rejected")
+	}
+}
+
+func TestMissingTokenSecret(t *testing.T) {
+	_, err := New(config.WebAuthConfig{
+		Tokens: []config.APIToken{{Name: "ci", Role: config.RoleOperator, TokenEnv: "TEST_GITFETCHER_UNSET_TOKEN"}},
+	})
+	if err == nil {
+		t.Error("Expected error when the token variable is not set")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newTestAuthenticator(t)
+	id, _, err := a.Login(Principal{Name: "alice", Role: config.RoleAdmin, Method: MethodBasic})
+	if err != nil {
+		t.Fatalf("Login() failed: %v", err)
+	}
+
+	request := func(setup func(*http.Request)) *http.Request {
+		req, _ := http.NewRequest("GET", "/api/status", nil)
+		setup(req)
+		return req
+	}
+	tests := []struct {
+		name   string
+		req    *http.Request
+		want   string // name of the principal, empty if rejected
+		method string
+	}{
+		{"bearer", request(func(r *http.Request) { r.Header.Set("Authorization", "Bearer ci-secret") }), "ci", MethodToken},
+		{"basic", request(func(r *http.Request) { r.SetBasicAuth("bob", "bob-pw") }), "bob", MethodBasic},
+		{"session", request(func(r *http.Request) { r.AddCookie(&http.Cookie{Name: SessionCookie, Value: id}) }), "alice", MethodSession},
+		{"no credentials", request(func(r *http.Request) {}), "", ""},
+		{"wrong password", request(func(r *http.Request) { r.SetBasicAuth("bob", "alice-pw") }), "", ""},
+		{"unknown session", request(func(r *http.Request) { r.AddCookie(&http.Cookie{Name: SessionCookie, Value: "forged"}) }), "", ""},
+		{"bad header with valid cookie", request(func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer wrong")
+			r.AddCookie(&http.Cookie{Name: SessionCookie, Value: id})
+		}), "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := a.Authenticate(tt.req)
+			if ok != (tt.want != "") || p.Name != tt.want || p.Method != tt.method {
+				t.Errorf("Expected %q via %q, got %+v (%v)", tt.want, tt.method, p, ok)
+			}
+		})
+	}
+}
+
+func TestSessionExpiryAndLogout(t *testing.T) {
+	a := newTestAuthenticator(t)
+	a.ttl = 50 * time.Millisecond
+
+	id, expires, err := a.Login(Principal{Name: "alice", Role: config.RoleAdmin})
+	if err != nil {
+		t.Fatalf("Login() failed: %v", err)
+	}
+	if time.Until(expires) > a.ttl {
+		t.Errorf("Expected session to expire within %s, got %s", a.ttl, expires)
+	}
+	if _, ok := a.Session(id); !ok {
+		t.Fatal("Expected session to be valid right after login")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := a.Session(id); ok {
+		t.Error("Expected expired session to be rejected")
+	}
+
+	a.ttl = time.Hour
+	id, _, _ = a.Login(Principal{Name: "alice", Role: config.RoleAdmin})
+	a.Logout(id)
+	if _, ok := a.Session(id); ok {
+		t.Error("Expected session to end on logout")
+	}
+}
+
+func TestKeepSessions(t *testing.T) {
+	old := newTestAuthenticator(t)
+	login := func(p Principal) string {
+		id, _, err := old.Login(p)
+		if err != nil {
+			t.Fatalf("Login() failed: %v", err)
+		}
+		return id
+	}
+	alice := login(Principal{Name: "alice", Role: config.RoleAdmin, Method: MethodBasic})
+	bob := login(Principal{Name: "bob", Role: config.RoleViewer, Method: MethodBasic})
+	ci := login(Principal{Name: "ci", Role: config.RoleOperator, Method: MethodToken})
+
+	// alice is unchanged, bob is promoted and the ci token is revoked
+	usersFile := filepath.Join(t.TempDir(), "users")
+	users := "alice:" + string(old.users["alice"].hash) + ":admin\n" +
+		"bob:" + hash(t, "bob-pw") + ":admin\n"
+	if err := os.WriteFile(usersFile, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(config.WebAuthConfig{UsersFile: usersFile})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	a.KeepSessions(old)
+
+	if p, ok := a.Session(alice); !ok || p.Name != "alice" {
+		t.Errorf("Expected the session of alice to be kept, got %+v (%v)", p, ok)
+	}
+	for name, id := range map[string]string{"bob": bob, "ci": ci} {
+		if _, ok := a.Session(id); ok {
+			t.Errorf("Expected the session of %s to end", name)
+		}
+	}
+}
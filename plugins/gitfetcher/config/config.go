@@ -1,25 +1,280 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-type RepoConfig struct {
+// Supported fetcher backends
+const (
+	BackendCLI   = "cli"    // shells out to the git binary
+	BackendGoGit = "go-git" // pure-Go implementation, no git binary required
+)
+
+// Supported authentication types
+const (
+	AuthSSH        = "ssh"         // private key, see ssh_key_path
+	AuthHTTPSToken = "https_token" // personal access / fine-grained token over HTTPS
+	AuthBasic      = "basic"       // username and password over HTTPS
+	AuthNone       = "none"        // anonymous access
+)
+
+// Host key trust policies
+const (
+	HostKeyTOFU   = "tofu"   // trust the first key seen for a host, reject changes
+	HostKeyStrict = "strict" // only trust pinned or approved keys
+)
+
+// Roles of the HTTP API, each includes the permissions of the previous one
+const (
+	RoleViewer   = "viewer"   // read status, history and configuration
+	RoleOperator = "operator" // trigger and cancel fetches
+	RoleAdmin    = "admin"    // edit configuration, deploy keys and host keys
+)
+
+// DefaultSessionTTL is how long a web UI login stays valid
+const DefaultSessionTTL = "12h"
+
+// DefaultFetchTimeout bounds a single clone or fetch when neither the repo nor fetch_timeout set one
+const DefaultFetchTimeout = "30m"
+
+// DefaultStaleAfter is how long a repo may go without a successful fetch before /readyz fails
+const DefaultStaleAfter = "24h"
+
+// Retry and circuit breaker defaults
+const (
+	DefaultMaxAttempts      = 3
+	DefaultInitialBackoff   = "5s"
+	DefaultMaxBackoff       = "5m"
+	DefaultFailureThreshold = 5
+	DefaultProbeInterval    = "30m"
+)
+
+// Storage drivers
+const (
+	StorageSQLite   = "sqlite"   // embedded database file, the default
+	StoragePostgres = "postgres" // the gitfetcher schema of the shared Postgres
+)
+
+// Storage defaults
+const (
+	DefaultStoragePath      = "./data/gitfetcher.db"
+	DefaultHistoryRetention = "2160h" // 90 days
+	DefaultPostgresSchema   = "gitfetcher"
+	defaultPasswordEnv      = "POSTGRES_PASSWORD"
+)
+
+// DefaultWebhookDebounce is how long a push webhook waits for further pushes before fetching
+const DefaultWebhookDebounce = "5s"
+
+// DefaultRedmineTimeout bounds a Redmine changeset import, large repositories take a while
+const DefaultRedmineTimeout = "5m"
+
+// Where the shared repositories volume is mounted, as seen by Redmine and by gitfetcher
+const (
+	DefaultRedmineRepositoriesPath = "/usr/src/redmine/repositories"
+	DefaultMirrorsPath             = "/repos"
+)
+
+// DefaultBackupRetention is how long a ref backup is kept, "0s" keeps them forever
+const DefaultBackupRetention = "2160h" // 90 days
+
+// Default locations of the files gitfetcher writes itself
+const (
+	DefaultKnownHostsPath = "./data/known_hosts"
+	DefaultSecretsPath    = "./secrets"
+)
+
+// DefaultMaxConcurrentFetches bounds how many clones / fetches run at once
+const DefaultMaxConcurrentFetches = 4
+
+// defaultTokenUsername is sent alongside a token, GitHub and Gitea ignore its value
+const defaultTokenUsername = "x-access-token"
+
+// AuthConfig describes how to authenticate against a repository remote.
+// Secrets are never stored in the config file, they are read from an
+// environment variable or a file when a fetch runs.
+type AuthConfig struct {
+	Type         string `yaml:"type" json:"type"`
+	Username     string `yaml:"username,omitempty" json:"username,omitempty"`
+	TokenEnv     string `yaml:"token_env,omitempty" json:"token_env,omitempty"`
+	TokenFile    string `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+	PasswordEnv  string `yaml:"password_env,omitempty" json:"password_env,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+}
+
+// HostKeyConfig controls SSH host key verification. Keys are kept in a
+// known_hosts file owned by gitfetcher, Pinned maps a host ("github.com" or
+// "[git.example.com]:2222") to its accepted SHA256 fingerprints.
+type HostKeyConfig struct {
+	KnownHostsPath string              `yaml:"known_hosts_path,omitempty" json:"known_hosts_path,omitempty"`
+	Policy         string              `yaml:"policy,omitempty" json:"policy,omitempty"`
+	Pinned         map[string][]string `yaml:"pinned,omitempty" json:"pinned,omitempty"`
+}
+
+// WebAuthConfig protects the HTTP API. People log in with a username and
+// password from UsersFile (basic auth or the web UI), scripts use Tokens.
+// The API is open when neither is set.
+type WebAuthConfig struct {
+	UsersFile  string     `yaml:"users_file,omitempty" json:"users_file,omitempty"`
+	Tokens     []APIToken `yaml:"tokens,omitempty" json:"tokens,omitempty"`
+	SessionTTL string     `yaml:"session_ttl,omitempty" json:"session_ttl,omitempty"`
+}
+
+// APIToken is a static bearer token, its value is read from TokenEnv or TokenFile
+type APIToken struct {
 	Name      string `yaml:"name" json:"name"`
-	URL       string `yaml:"url" json:"url"`
-	LocalPath string `yaml:"local_path" json:"local_path"`
-	Interval  string `yaml:"interval" json:"interval"`
+	Role      string `yaml:"role" json:"role"`
+	TokenEnv  string `yaml:"token_env,omitempty" json:"token_env,omitempty"`
+	TokenFile string `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+}
+
+// WebhookConfig accepts push notifications from GitHub, GitLab and Gitea on
+// /hooks/<provider>. The shared secret is read from SecretEnv or SecretFile,
+// pushes to the same repo within Debounce result in a single fetch.
+type WebhookConfig struct {
+	SecretEnv  string `yaml:"secret_env,omitempty" json:"secret_env,omitempty"`
+	SecretFile string `yaml:"secret_file,omitempty" json:"secret_file,omitempty"`
+	Debounce   string `yaml:"debounce,omitempty" json:"debounce,omitempty"`
+}
+
+// RedmineConfig lets gitfetcher ask Redmine to import new changesets right
+// after a fetch, through the repository management web service
+// (/sys/fetch_changesets). The WS key is read from KeyEnv or KeyFile.
+//
+// Database, RepositoriesPath and MirrorsPath are used to import repositories
+// from Redmine's repositories table. Database defaults to the storage
+// Postgres, which Redmine shares.
+type RedmineConfig struct {
+	URL     string `yaml:"url,omitempty" json:"url,omitempty"`
+	KeyEnv  string `yaml:"key_env,omitempty" json:"key_env,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	Database         *PostgresConfig `yaml:"database,omitempty" json:"database,omitempty"`
+	RepositoriesPath string          `yaml:"repositories_path,omitempty" json:"repositories_path,omitempty"`
+	MirrorsPath      string          `yaml:"mirrors_path,omitempty" json:"mirrors_path,omitempty"`
+}
+
+// HealthConfig tunes the /readyz check
+type HealthConfig struct {
+	// StaleAfter is how long a repo may go without a successful fetch
+	StaleAfter string `yaml:"stale_after,omitempty" json:"stale_after,omitempty"`
+}
+
+// StorageConfig selects where fetch history and schedule state are kept
+type StorageConfig struct {
+	Driver    string         `yaml:"driver,omitempty" json:"driver,omitempty"`
+	Path      string         `yaml:"path,omitempty" json:"path,omitempty"`           // sqlite database file
+	Retention string         `yaml:"retention,omitempty" json:"retention,omitempty"` // how long history is kept
+	Postgres  PostgresConfig `yaml:"postgres,omitempty" json:"postgres,omitempty"`
+}
+
+// PostgresConfig uses the same settings as github-sync. The password is
+// read from PasswordEnv, POSTGRES_PASSWORD by default.
+type PostgresConfig struct {
+	Host        string `yaml:"host,omitempty" json:"host,omitempty"`
+	Port        int    `yaml:"port,omitempty" json:"port,omitempty"`
+	Name        string `yaml:"name,omitempty" json:"name,omitempty"`
+	User        string `yaml:"user,omitempty" json:"user,omitempty"`
+	PasswordEnv string `yaml:"password_env,omitempty" json:"password_env,omitempty"`
+	Schema      string `yaml:"schema,omitempty" json:"schema,omitempty"`
+	SSLMode     string `yaml:"sslmode,omitempty" json:"sslmode,omitempty"`
+}
+
+// RetryConfig controls how often a failed fetch is retried within one run.
+// The delay doubles from InitialBackoff up to MaxBackoff, with jitter.
+type RetryConfig struct {
+	MaxAttempts    int    `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"` // including the first try
+	InitialBackoff string `yaml:"initial_backoff,omitempty" json:"initial_backoff,omitempty"`
+	MaxBackoff     string `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+}
+
+// CircuitBreakerConfig suspends a repo after FailureThreshold failed runs in a
+// row, it is then only probed every ProbeInterval until a fetch succeeds.
+type CircuitBreakerConfig struct {
+	FailureThreshold int    `yaml:"failure_threshold,omitempty" json:"failure_threshold,omitempty"`
+	ProbeInterval    string `yaml:"probe_interval,omitempty" json:"probe_interval,omitempty"`
+	Disabled         bool   `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+}
+
+// PushMirror is a secondary remote that receives every ref of the mirror,
+// except the backups of RefBackup, after a successful fetch. Without Auth it
+// is pushed over ssh with SSHKeyPath, or the key of the repo, the credentials
+// of the upstream are never sent to a push mirror.
+type PushMirror struct {
+	Name       string      `yaml:"name" json:"name"`
+	URL        string      `yaml:"url" json:"url"`
+	Auth       *AuthConfig `yaml:"auth,omitempty" json:"auth,omitempty"`
+	SSHKeyPath string      `yaml:"ssh_key_path,omitempty" json:"ssh_key_path,omitempty"`
+}
+
+// RefBackupConfig keeps every ref a fetch force-updates or prunes under
+// refs/gitfetcher/backup/<time>/, so the commits it pointed to stay in the
+// mirror. Backups older than Retention are deleted, and only the MaxPerRef
+// newest backups of a ref are kept when it is set.
+type RefBackupConfig struct {
+	Retention string `yaml:"retention,omitempty" json:"retention,omitempty"`
+	MaxPerRef int    `yaml:"max_per_ref,omitempty" json:"max_per_ref,omitempty"`
+}
+
+type RepoConfig struct {
+	Name           string                `yaml:"name" json:"name"`
+	URL            string                `yaml:"url" json:"url"`
+	LocalPath      string                `yaml:"local_path" json:"local_path"`
+	Interval       string                `yaml:"interval" json:"interval"`
+	Backend        string                `yaml:"backend,omitempty" json:"backend,omitempty"`
+	Auth           *AuthConfig           `yaml:"auth,omitempty" json:"auth,omitempty"`
+	SSHKeyPath     string                `yaml:"ssh_key_path,omitempty" json:"ssh_key_path,omitempty"`
+	Timeout        string                `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Retry          *RetryConfig          `yaml:"retry,omitempty" json:"retry,omitempty"`
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// BlackoutWindows are added to the global ones
+	BlackoutWindows []BlackoutWindow `yaml:"blackout_windows,omitempty" json:"blackout_windows,omitempty"`
+	// Enabled false keeps the repo and its status but never fetches it
+	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// RedmineProject is the identifier of the Redmine project whose
+	// changesets are imported after a fetch that changed refs
+	RedmineProject string `yaml:"redmine_project,omitempty" json:"redmine_project,omitempty"`
+	// PushMirrors receive the refs after every fetch, with the cli backend only
+	PushMirrors []PushMirror `yaml:"push_mirrors,omitempty" json:"push_mirrors,omitempty"`
+	// RefBackup enables backups of rewritten and pruned refs, with the cli backend only
+	RefBackup *RefBackupConfig `yaml:"ref_backup,omitempty" json:"ref_backup,omitempty"`
+	// Source is the name of the source that discovered the repo, it is
+	// empty for repos of the config file
+	Source string `yaml:"-" json:"source,omitempty"`
 }
 
 type Config struct {
-	Repos      []RepoConfig `yaml:"repos" json:"repos"`
-	SSHKeyPath string       `yaml:"ssh_key_path" json:"ssh_key_path"`
-	HTTPPort   int          `yaml:"http_port" json:"http_port"`
-	LogPath    string       `yaml:"log_path" json:"log_path"`
+	Repos                []RepoConfig          `yaml:"repos" json:"repos"`
+	SSHKeyPath           string                `yaml:"ssh_key_path" json:"ssh_key_path"`
+	HTTPPort             int                   `yaml:"http_port" json:"http_port"`
+	LogPath              string                `yaml:"log_path" json:"log_path"`
+	Backend              string                `yaml:"backend,omitempty" json:"backend,omitempty"`
+	HostKeys             HostKeyConfig         `yaml:"host_keys,omitempty" json:"host_keys,omitempty"`
+	SecretsPath          string                `yaml:"secrets_path,omitempty" json:"secrets_path,omitempty"`
+	FetchTimeout         string                `yaml:"fetch_timeout,omitempty" json:"fetch_timeout,omitempty"`
+	Retry                *RetryConfig          `yaml:"retry,omitempty" json:"retry,omitempty"`
+	CircuitBreaker       *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	MaxConcurrentFetches int                   `yaml:"max_concurrent_fetches,omitempty" json:"max_concurrent_fetches,omitempty"`
+	BlackoutWindows      []BlackoutWindow      `yaml:"blackout_windows,omitempty" json:"blackout_windows,omitempty"`
+	Storage              StorageConfig         `yaml:"storage,omitempty" json:"storage,omitempty"`
+	Health               HealthConfig          `yaml:"health,omitempty" json:"health,omitempty"`
+	WebAuth              WebAuthConfig         `yaml:"web_auth,omitempty" json:"web_auth,omitempty"`
+	Webhooks             WebhookConfig         `yaml:"webhooks,omitempty" json:"webhooks,omitempty"`
+	Redmine              *RedmineConfig        `yaml:"redmine,omitempty" json:"redmine,omitempty"`
+	Sources              []SourceConfig        `yaml:"sources,omitempty" json:"sources,omitempty"`
 }
 
 // ParseInterval converts interval string (e.g., "5s", "10m", "1h") to time.Duration
@@ -27,27 +282,689 @@ func (r *RepoConfig) ParseInterval() (time.Duration, error) {
 	return time.ParseDuration(r.Interval)
 }
 
+// IsEnabled reports whether the repo is fetched, repos are enabled unless set to false
+func (r *RepoConfig) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// ParseTimeout converts the timeout string to time.Duration
+func (r *RepoConfig) ParseTimeout() (time.Duration, error) {
+	return time.ParseDuration(r.Timeout)
+}
+
+// validTimeout reports whether s is empty or a positive duration
+func validTimeout(s string) bool {
+	if s == "" {
+		return true
+	}
+	d, err := time.ParseDuration(s)
+	return err == nil && d > 0
+}
+
+// EffectiveBackend returns the backend for a repo, falling back to the global default
+func (c *Config) EffectiveBackend(repo RepoConfig) string {
+	if repo.Backend != "" {
+		return repo.Backend
+	}
+	if c.Backend != "" {
+		return c.Backend
+	}
+	return BackendCLI
+}
+
+// EffectiveMaxConcurrentFetches returns the worker pool size
+func (c *Config) EffectiveMaxConcurrentFetches() int {
+	if c.MaxConcurrentFetches > 0 {
+		return c.MaxConcurrentFetches
+	}
+	return DefaultMaxConcurrentFetches
+}
+
+// EffectiveSecretsPath returns where generated deploy keys are stored
+func (c *Config) EffectiveSecretsPath() string {
+	if c.SecretsPath != "" {
+		return c.SecretsPath
+	}
+	return DefaultSecretsPath
+}
+
+// ResolveRepo returns a copy of repo with global defaults applied
+func (c *Config) ResolveRepo(repo RepoConfig) RepoConfig {
+	repo.Backend = c.EffectiveBackend(repo)
+	if repo.SSHKeyPath == "" {
+		repo.SSHKeyPath = c.SSHKeyPath
+	}
+	if repo.Timeout == "" {
+		repo.Timeout = c.FetchTimeout
+	}
+	if repo.Timeout == "" {
+		repo.Timeout = DefaultFetchTimeout
+	}
+	repo.Retry = resolveRetry(repo.Retry, c.Retry)
+	repo.CircuitBreaker = resolveCircuitBreaker(repo.CircuitBreaker, c.CircuitBreaker)
+	if len(c.BlackoutWindows) > 0 {
+		repo.BlackoutWindows = append(append([]BlackoutWindow(nil), c.BlackoutWindows...), repo.BlackoutWindows...)
+	}
+	return repo
+}
+
+// resolveRetry returns a copy of the repo block (or the global one) with defaults filled in
+func resolveRetry(repo, global *RetryConfig) *RetryConfig {
+	var r RetryConfig
+	if repo != nil {
+		r = *repo
+	} else if global != nil {
+		r = *global
+	}
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = DefaultMaxAttempts
+	}
+	if r.InitialBackoff == "" {
+		r.InitialBackoff = DefaultInitialBackoff
+	}
+	if r.MaxBackoff == "" {
+		r.MaxBackoff = DefaultMaxBackoff
+	}
+	return &r
+}
+
+// resolveCircuitBreaker returns a copy of the repo block (or the global one) with defaults filled in
+func resolveCircuitBreaker(repo, global *CircuitBreakerConfig) *CircuitBreakerConfig {
+	var cb CircuitBreakerConfig
+	if repo != nil {
+		cb = *repo
+	} else if global != nil {
+		cb = *global
+	}
+	if cb.FailureThreshold == 0 {
+		cb.FailureThreshold = DefaultFailureThreshold
+	}
+	if cb.ProbeInterval == "" {
+		cb.ProbeInterval = DefaultProbeInterval
+	}
+	return &cb
+}
+
+// Backoff returns the parsed initial and maximum retry delays
+func (r *RetryConfig) Backoff() (initial, max time.Duration) {
+	initial, _ = time.ParseDuration(r.InitialBackoff)
+	max, _ = time.ParseDuration(r.MaxBackoff)
+	return initial, max
+}
+
+// Validate checks attempts and backoff durations
+func (r *RetryConfig) Validate() error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("retry: max_attempts must not be negative")
+	}
+	if !validTimeout(r.InitialBackoff) || !validTimeout(r.MaxBackoff) {
+		return fmt.Errorf("retry: invalid backoff '%s' / '%s'", r.InitialBackoff, r.MaxBackoff)
+	}
+	return nil
+}
+
+// ParseProbeInterval converts the probe interval to time.Duration
+func (cb *CircuitBreakerConfig) ParseProbeInterval() (time.Duration, error) {
+	return time.ParseDuration(cb.ProbeInterval)
+}
+
+// Validate checks the threshold and probe interval
+func (cb *CircuitBreakerConfig) Validate() error {
+	if cb.FailureThreshold < 0 {
+		return fmt.Errorf("circuit_breaker: failure_threshold must not be negative")
+	}
+	if !validTimeout(cb.ProbeInterval) {
+		return fmt.Errorf("circuit_breaker: invalid probe_interval '%s'", cb.ProbeInterval)
+	}
+	return nil
+}
+
+// FindRepo returns the index of the repo called name, or -1
+func (c *Config) FindRepo(name string) int {
+	for i, repo := range c.Repos {
+		if repo.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Validate checks that the auth block is complete
+func (a *AuthConfig) Validate() error {
+	switch a.Type {
+	case AuthSSH, AuthNone:
+		return nil
+	case AuthHTTPSToken:
+		if a.TokenEnv == "" && a.TokenFile == "" {
+			return fmt.Errorf("https_token auth requires token_env or token_file")
+		}
+	case AuthBasic:
+		if a.Username == "" {
+			return fmt.Errorf("basic auth requires username")
+		}
+		if a.PasswordEnv == "" && a.PasswordFile == "" {
+			return fmt.Errorf("basic auth requires password_env or password_file")
+		}
+	default:
+		return fmt.Errorf("unknown auth type '%s'", a.Type)
+	}
+	return nil
+}
+
+// UsesSSHKey reports whether fetches of a resolved repo authenticate with SSHKeyPath
+func (r *RepoConfig) UsesSSHKey() bool {
+	return r.SSHKeyPath != "" && (r.Auth == nil || r.Auth.Type == AuthSSH)
+}
+
+// IsHTTP reports whether the auth type sends credentials over HTTP(S)
+func (a *AuthConfig) IsHTTP() bool {
+	return a.Type == AuthHTTPSToken || a.Type == AuthBasic
+}
+
+// Credentials resolves the username and secret for HTTP based auth types
+func (a *AuthConfig) Credentials() (username, secret string, err error) {
+	switch a.Type {
+	case AuthHTTPSToken:
+		username = a.Username
+		if username == "" {
+			username = defaultTokenUsername
+		}
+		secret, err = readSecret(a.TokenEnv, a.TokenFile)
+	case AuthBasic:
+		username = a.Username
+		secret, err = readSecret(a.PasswordEnv, a.PasswordFile)
+	default:
+		return "", "", fmt.Errorf("auth type '%s' has no credentials", a.Type)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return username, secret, nil
+}
+
+// readSecret reads a secret from an environment variable, or from a file if env is empty
+func readSecret(env, file string) (string, error) {
+	if env != "" {
+		value, ok := os.LookupEnv(env)
+		if !ok || value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", env)
+		}
+		return value, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", file)
+	}
+	return value, nil
+}
+
+// EffectiveKnownHostsPath returns the known_hosts file, DefaultKnownHostsPath if unset
+func (h *HostKeyConfig) EffectiveKnownHostsPath() string {
+	if h.KnownHostsPath != "" {
+		return h.KnownHostsPath
+	}
+	return DefaultKnownHostsPath
+}
+
+// TOFU reports whether unknown hosts are trusted on first use, the default policy
+func (h *HostKeyConfig) TOFU() bool {
+	return h.Policy != HostKeyStrict
+}
+
+// Validate checks the policy and the format of pinned fingerprints
+func (h *HostKeyConfig) Validate() error {
+	if h.Policy != "" && h.Policy != HostKeyTOFU && h.Policy != HostKeyStrict {
+		return fmt.Errorf("unknown host key policy '%s'", h.Policy)
+	}
+	for host, fingerprints := range h.Pinned {
+		if len(fingerprints) == 0 {
+			return fmt.Errorf("host_keys: no fingerprints pinned for %s", host)
+		}
+		for _, fp := range fingerprints {
+			if !strings.HasPrefix(fp, "SHA256:") {
+				return fmt.Errorf("host_keys: fingerprint '%s' for %s must start with SHA256:", fp, host)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseRetention returns how long history is kept, DefaultHistoryRetention if
+// unset and zero for forever
+func (s *StorageConfig) ParseRetention() (time.Duration, error) {
+	if s.Retention == "" {
+		return time.ParseDuration(DefaultHistoryRetention)
+	}
+	return time.ParseDuration(s.Retention)
+}
+
+// Validate checks the driver, retention and Postgres settings
+func (s *StorageConfig) Validate() error {
+	switch s.Driver {
+	case "", StorageSQLite:
+	case StoragePostgres:
+		p := s.Postgres
+		if p.Host == "" || p.Name == "" || p.User == "" {
+			return fmt.Errorf("storage: postgres requires host, name and user")
+		}
+		if p.Schema != "" && !validIdentifier(p.Schema) {
+			return fmt.Errorf("storage: invalid postgres schema '%s'", p.Schema)
+		}
+	default:
+		return fmt.Errorf("storage: unknown driver '%s'", s.Driver)
+	}
+	if d, err := s.ParseRetention(); err != nil || d < 0 {
+		return fmt.Errorf("storage: invalid retention '%s'", s.Retention)
+	}
+	return nil
+}
+
+// ParseStaleAfter returns the staleness window of /readyz, DefaultStaleAfter if unset
+func (h *HealthConfig) ParseStaleAfter() (time.Duration, error) {
+	if h.StaleAfter == "" {
+		return time.ParseDuration(DefaultStaleAfter)
+	}
+	return time.ParseDuration(h.StaleAfter)
+}
+
+// ValidRole reports whether role is one of the API roles
+func ValidRole(role string) bool {
+	return role == RoleViewer || role == RoleOperator || role == RoleAdmin
+}
+
+// Enabled reports whether the API requires authentication
+func (w *WebAuthConfig) Enabled() bool {
+	return w.UsersFile != "" || len(w.Tokens) > 0
+}
+
+// ParseSessionTTL returns the login lifetime, DefaultSessionTTL if unset
+func (w *WebAuthConfig) ParseSessionTTL() (time.Duration, error) {
+	if w.SessionTTL == "" {
+		return time.ParseDuration(DefaultSessionTTL)
+	}
+	return time.ParseDuration(w.SessionTTL)
+}
+
+// Validate checks the session lifetime and that every token is complete
+func (w *WebAuthConfig) Validate() error {
+	if !validTimeout(w.SessionTTL) {
+		return fmt.Errorf("web_auth: invalid session_ttl '%s'", w.SessionTTL)
+	}
+	names := make(map[string]bool)
+	for i, t := range w.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("web_auth: token[%d]: name is required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("web_auth: duplicate token name '%s'", t.Name)
+		}
+		names[t.Name] = true
+		if !ValidRole(t.Role) {
+			return fmt.Errorf("web_auth: token %s: unknown role '%s'", t.Name, t.Role)
+		}
+		if t.TokenEnv == "" && t.TokenFile == "" {
+			return fmt.Errorf("web_auth: token %s requires token_env or token_file", t.Name)
+		}
+	}
+	return nil
+}
+
+// Secret reads the token value
+func (t *APIToken) Secret() (string, error) {
+	return readSecret(t.TokenEnv, t.TokenFile)
+}
+
+// Enabled reports whether the /hooks endpoints accept pushes
+func (w *WebhookConfig) Enabled() bool {
+	return w.SecretEnv != "" || w.SecretFile != ""
+}
+
+// Secret reads the shared webhook secret
+func (w *WebhookConfig) Secret() (string, error) {
+	return readSecret(w.SecretEnv, w.SecretFile)
+}
+
+// ParseDebounce returns the debounce delay, DefaultWebhookDebounce if unset
+func (w *WebhookConfig) ParseDebounce() (time.Duration, error) {
+	if w.Debounce == "" {
+		return time.ParseDuration(DefaultWebhookDebounce)
+	}
+	return time.ParseDuration(w.Debounce)
+}
+
+// Validate checks the debounce delay
+func (w *WebhookConfig) Validate() error {
+	if !validTimeout(w.Debounce) {
+		return fmt.Errorf("webhooks: invalid debounce '%s'", w.Debounce)
+	}
+	return nil
+}
+
+// Key reads the Redmine WS key
+func (r *RedmineConfig) Key() (string, error) {
+	return readSecret(r.KeyEnv, r.KeyFile)
+}
+
+// ParseTimeout returns the import timeout, DefaultRedmineTimeout if unset
+func (r *RedmineConfig) ParseTimeout() (time.Duration, error) {
+	if r.Timeout == "" {
+		return time.ParseDuration(DefaultRedmineTimeout)
+	}
+	return time.ParseDuration(r.Timeout)
+}
+
+// ImportsChangesets reports whether Redmine is asked to import changesets after a fetch
+func (r *RedmineConfig) ImportsChangesets() bool {
+	return r != nil && r.URL != ""
+}
+
+// Paths returns where Redmine and gitfetcher see the repositories volume
+func (r *RedmineConfig) Paths() (repositories, mirrors string) {
+	repositories, mirrors = DefaultRedmineRepositoriesPath, DefaultMirrorsPath
+	if r != nil && r.RepositoriesPath != "" {
+		repositories = r.RepositoriesPath
+	}
+	if r != nil && r.MirrorsPath != "" {
+		mirrors = r.MirrorsPath
+	}
+	return repositories, mirrors
+}
+
+// Validate checks the URL, key, timeout and database. The URL is optional, a
+// block without one only configures imports from the repositories table.
+func (r *RedmineConfig) Validate() error {
+	if r.URL != "" {
+		u, err := url.Parse(r.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("redmine: url must be an http(s) URL, got '%s'", r.URL)
+		}
+		if r.KeyEnv == "" && r.KeyFile == "" {
+			return fmt.Errorf("redmine: key_env or key_file is required")
+		}
+	}
+	if !validTimeout(r.Timeout) {
+		return fmt.Errorf("redmine: invalid timeout '%s'", r.Timeout)
+	}
+	if d := r.Database; d != nil {
+		if d.Host == "" || d.Name == "" || d.User == "" {
+			return fmt.Errorf("redmine: database requires host, name and user")
+		}
+		if d.Schema != "" && !validIdentifier(d.Schema) {
+			return fmt.Errorf("redmine: invalid database schema '%s'", d.Schema)
+		}
+	}
+	for _, p := range []string{r.RepositoriesPath, r.MirrorsPath} {
+		if p != "" && !filepath.IsAbs(p) {
+			return fmt.Errorf("redmine: '%s' must be an absolute path", p)
+		}
+	}
+	return nil
+}
+
+// RedmineDatabase returns the connection settings of Redmine's database,
+// ok is false when neither redmine.database nor a Postgres storage is set.
+// Schema is Redmine's, empty for the default public schema.
+func (c *Config) RedmineDatabase() (db PostgresConfig, ok bool) {
+	if c.Redmine != nil && c.Redmine.Database != nil {
+		return *c.Redmine.Database, true
+	}
+	if c.Storage.Driver == StoragePostgres {
+		db = c.Storage.Postgres
+		db.Schema = ""
+		return db, true
+	}
+	return PostgresConfig{}, false
+}
+
+// SchemaName returns the schema holding gitfetcher's tables
+func (p *PostgresConfig) SchemaName() string {
+	if p.Schema != "" {
+		return p.Schema
+	}
+	return DefaultPostgresSchema
+}
+
+// DSN builds the lib/pq connection string, reading the password from the environment
+func (p *PostgresConfig) DSN() (string, error) {
+	env := p.PasswordEnv
+	if env == "" {
+		env = defaultPasswordEnv
+	}
+	password, err := readSecret(env, "")
+	if err != nil {
+		return "", fmt.Errorf("postgres password: %w", err)
+	}
+
+	port := p.Port
+	if port == 0 {
+		port = 5432
+	}
+	sslmode := p.SSLMode
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quoteDSN(p.Host), port, quoteDSN(p.User), quoteDSN(password), quoteDSN(p.Name), quoteDSN(sslmode)), nil
+}
+
+// quoteDSN quotes a key/value connection string value
+func quoteDSN(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(v) + "'"
+}
+
+// validIdentifier reports whether s can be used as an unquoted SQL identifier
+func validIdentifier(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ParseRetention returns how long backups are kept, DefaultBackupRetention if
+// unset and zero for forever
+func (b *RefBackupConfig) ParseRetention() (time.Duration, error) {
+	if b.Retention == "" {
+		return time.ParseDuration(DefaultBackupRetention)
+	}
+	return time.ParseDuration(b.Retention)
+}
+
+// Validate checks the retention and the number of backups per ref
+func (b *RefBackupConfig) Validate() error {
+	if d, err := b.ParseRetention(); err != nil || d < 0 {
+		return fmt.Errorf("ref_backup: invalid retention '%s'", b.Retention)
+	}
+	if b.MaxPerRef < 0 {
+		return fmt.Errorf("ref_backup: max_per_ref must not be negative")
+	}
+	return nil
+}
+
+// validatePushMirrors checks the push mirrors of repo, they need the git binary
+func (c *Config) validatePushMirrors(repo RepoConfig) error {
+	if len(repo.PushMirrors) > 0 && c.EffectiveBackend(repo) != BackendCLI {
+		return fmt.Errorf("push_mirrors require the %s backend", BackendCLI)
+	}
+	names := make(map[string]bool, len(repo.PushMirrors))
+	for j, m := range repo.PushMirrors {
+		if m.Name == "" {
+			return fmt.Errorf("push_mirrors[%d]: name is required", j)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("push_mirrors[%d]: duplicate name '%s'", j, m.Name)
+		}
+		names[m.Name] = true
+		if m.URL == "" {
+			return fmt.Errorf("push_mirrors[%d]: url is required", j)
+		}
+		if m.URL == repo.URL {
+			return fmt.Errorf("push_mirrors[%d]: url must not be the upstream url", j)
+		}
+		if m.Auth != nil {
+			if err := m.Auth.Validate(); err != nil {
+				return fmt.Errorf("push_mirrors[%d]: %w", j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validBackend reports whether name is empty or a known backend
+func validBackend(name string) bool {
+	return name == "" || name == BackendCLI || name == BackendGoGit
+}
+
+// ValidateRepo checks a single repo against the rest of c, repos of the
+// config file and discovered ones alike
+func (c *Config) ValidateRepo(repo RepoConfig) error {
+	if repo.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if repo.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if repo.LocalPath == "" {
+		return fmt.Errorf("local_path is required")
+	}
+	for _, w := range repo.BlackoutWindows {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := repo.ParseSchedule(); err != nil {
+		return fmt.Errorf("invalid interval '%s': %w", repo.Interval, err)
+	}
+	if !validBackend(repo.Backend) {
+		return fmt.Errorf("unknown backend '%s'", repo.Backend)
+	}
+	if !validTimeout(repo.Timeout) {
+		return fmt.Errorf("invalid timeout '%s'", repo.Timeout)
+	}
+	if repo.Retry != nil {
+		if err := repo.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if repo.CircuitBreaker != nil {
+		if err := repo.CircuitBreaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if repo.Auth != nil {
+		if err := repo.Auth.Validate(); err != nil {
+			return err
+		}
+	}
+	if repo.RedmineProject != "" && !c.Redmine.ImportsChangesets() {
+		return fmt.Errorf("redmine_project requires redmine.url")
+	}
+	if err := c.validatePushMirrors(repo); err != nil {
+		return err
+	}
+	if repo.RefBackup != nil {
+		if c.EffectiveBackend(repo) != BackendCLI {
+			return fmt.Errorf("ref_backup requires the %s backend", BackendCLI)
+		}
+		if err := repo.RefBackup.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Validate checks if the config is valid
 func (c *Config) Validate() error {
-	if len(c.Repos) == 0 {
+	if len(c.Repos) == 0 && len(c.Sources) == 0 {
 		return fmt.Errorf("no repositories configured")
 	}
 
+	names := make(map[string]bool, len(c.Repos))
 	for i, repo := range c.Repos {
-		if repo.Name == "" {
-			return fmt.Errorf("repo[%d]: name is required", i)
+		if err := c.ValidateRepo(repo); err != nil {
+			return fmt.Errorf("repo[%d]: %w", i, err)
 		}
-		if repo.URL == "" {
-			return fmt.Errorf("repo[%d]: url is required", i)
+		if names[repo.Name] {
+			return fmt.Errorf("repo[%d]: duplicate name '%s'", i, repo.Name)
 		}
-		if repo.LocalPath == "" {
-			return fmt.Errorf("repo[%d]: local_path is required", i)
+		names[repo.Name] = true
+	}
+
+	if !validBackend(c.Backend) {
+		return fmt.Errorf("unknown backend '%s'", c.Backend)
+	}
+
+	if !validTimeout(c.FetchTimeout) {
+		return fmt.Errorf("invalid fetch_timeout '%s'", c.FetchTimeout)
+	}
+
+	for _, w := range c.BlackoutWindows {
+		if err := w.Validate(); err != nil {
+			return err
 		}
-		if _, err := repo.ParseInterval(); err != nil {
-			return fmt.Errorf("repo[%d]: invalid interval '%s': %w", i, repo.Interval, err)
+	}
+
+	if c.MaxConcurrentFetches < 0 {
+		return fmt.Errorf("max_concurrent_fetches must not be negative")
+	}
+
+	if c.Retry != nil {
+		if err := c.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.CircuitBreaker != nil {
+		if err := c.CircuitBreaker.Validate(); err != nil {
+			return err
 		}
 	}
 
+	if err := c.Storage.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.HostKeys.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.WebAuth.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Webhooks.Validate(); err != nil {
+		return err
+	}
+
+	if c.Redmine != nil {
+		if err := c.Redmine.Validate(); err != nil {
+			return err
+		}
+	}
+
+	sources := make(map[string]bool, len(c.Sources))
+	for i, src := range c.Sources {
+		if err := src.Validate(); err != nil {
+			return fmt.Errorf("sources[%d]: %w", i, err)
+		}
+		if sources[src.Name] {
+			return fmt.Errorf("sources[%d]: duplicate name '%s'", i, src.Name)
+		}
+		sources[src.Name] = true
+	}
+
+	if !validTimeout(c.Health.StaleAfter) {
+		return fmt.Errorf("health: invalid stale_after '%s'", c.Health.StaleAfter)
+	}
+
 	if c.HTTPPort <= 0 || c.HTTPPort > 65535 {
 		return fmt.Errorf("invalid http_port: %d", c.HTTPPort)
 	}
@@ -61,7 +978,11 @@ func LoadConfig(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return ParseConfig(data)
+}
 
+// ParseConfig parses YAML config, applies defaults and validates it
+func ParseConfig(data []byte) (*Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
@@ -84,16 +1005,72 @@ func LoadConfig(path string) (*Config, error) {
 
 // SaveConfig writes the config back to file
 func SaveConfig(path string, cfg *Config) error {
-	if err := cfg.Validate(); err != nil {
+	data, err := MarshalConfig(cfg)
+	if err != nil {
 		return err
 	}
+	return WriteConfig(path, data)
+}
+
+// MarshalConfig validates cfg and returns it as YAML
+func MarshalConfig(cfg *Config) ([]byte, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return data, nil
+}
+
+// ETag identifies a version of the config file by its content, as a quoted
+// HTTP entity tag
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WriteConfig replaces the config file with data. It is written to a
+// temporary file in the same directory and renamed over the old one, so a
+// reload never sees a half-written file. A file bind mounted on its own
+// cannot be renamed over and is rewritten in place with a warning.
+func WriteConfig(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		// A file bind mounted into a container cannot be replaced, only rewritten
+		if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+			log.Printf("Warning: cannot replace %s (%v), rewriting it in place, a reload may see it half-written. Mount its directory instead of the file.", path, err)
+			if err := os.WriteFile(path, data, mode); err != nil {
+				return fmt.Errorf("failed to write config file: %w", err)
+			}
+			return nil
+		}
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
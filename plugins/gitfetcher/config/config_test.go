@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -129,6 +130,72 @@ func TestConfigValidation(t *testing.T) {
 			wantErr: true,
 			errMsg:  "invalid interval",
 		},
+		{
+			name: "cron interval",
+			config: Config{
+				Repos: []RepoConfig{
+					{
+						Name:      "test",
+						URL:       "git@github.com:user/repo.git",
+						LocalPath: "/repos/test.git",
+						Interval:  "*/10 8-20 * * 1-5",
+					},
+				},
+				HTTPPort: 8080,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid blackout window",
+			config: Config{
+				Repos: []RepoConfig{
+					{
+						Name:            "test",
+						URL:             "git@github.com:user/repo.git",
+						LocalPath:       "/repos/test.git",
+						Interval:        "5m",
+						BlackoutWindows: []BlackoutWindow{{Start: "0 2 * * *", Duration: "forever"}},
+					},
+				},
+				HTTPPort: 8080,
+			},
+			wantErr: true,
+			errMsg:  "blackout window: invalid duration",
+		},
+		{
+			name: "unknown repo backend",
+			config: Config{
+				Repos: []RepoConfig{
+					{
+						Name:      "test",
+						URL:       "git@github.com:user/repo.git",
+						LocalPath: "/repos/test.git",
+						Interval:  "5m",
+						Backend:   "libgit2",
+					},
+				},
+				HTTPPort: 8080,
+			},
+			wantErr: true,
+			errMsg:  "unknown backend",
+		},
+		{
+			name: "unknown global backend",
+			config: Config{
+				Repos: []RepoConfig{
+					{
+						Name:      "test",
+						URL:       "git@github.com:user/repo.git",
+						LocalPath: "/repos/test.git",
+						Interval:  "5m",
+					},
+				},
+				HTTPPort: 8080,
+				Backend:  "libgit2",
+			},
+			wantErr: true,
+			errMsg:  "unknown backend",
+		},
 		{
 			name: "invalid port - too high",
 			config: Config{
@@ -161,6 +228,23 @@ func TestConfigValidation(t *testing.T) {
 			wantErr: true,
 			errMsg:  "invalid http_port",
 		},
+		{
+			name: "invalid stale_after",
+			config: Config{
+				Repos: []RepoConfig{
+					{
+						Name:      "test",
+						URL:       "git@github.com:user/repo.git",
+						LocalPath: "/repos/test.git",
+						Interval:  "5m",
+					},
+				},
+				HTTPPort: 8080,
+				Health:   HealthConfig{StaleAfter: "a while"},
+			},
+			wantErr: true,
+			errMsg:  "invalid stale_after",
+		},
 	}
 
 	for _, tt := range tests {
@@ -257,6 +341,29 @@ repos:
 	if cfg.LogPath != "./logs" {
 		t.Errorf("Expected default LogPath './logs', got '%s'", cfg.LogPath)
 	}
+
+	if path := cfg.HostKeys.EffectiveKnownHostsPath(); path != "./data/known_hosts" {
+		t.Errorf("Expected default KnownHostsPath './data/known_hosts', got '%s'", path)
+	}
+
+	if path := cfg.EffectiveSecretsPath(); path != "./secrets" {
+		t.Errorf("Expected default SecretsPath './secrets', got '%s'", path)
+	}
+
+	if retention, err := cfg.Storage.ParseRetention(); err != nil || retention != 2160*time.Hour {
+		t.Errorf("Expected the default history retention of 90 days, got %v (%v)", retention, err)
+	}
+
+	// Defaults applied at their point of use are not written back
+	data, err := MarshalConfig(cfg)
+	if err != nil {
+		t.Fatalf("MarshalConfig() failed: %v", err)
+	}
+	for _, key := range []string{"storage:", "secrets_path:", "known_hosts_path:"} {
+		if strings.Contains(string(data), key) {
+			t.Errorf("Expected %s to be left unset, got:\n%s", key, data)
+		}
+	}
 }
 
 func TestLoadConfigFileNotFound(t *testing.T) {
@@ -344,3 +451,687 @@ func TestSaveConfigInvalid(t *testing.T) {
 		t.Error("Expected error when saving invalid config, got nil")
 	}
 }
+
+func TestWriteConfigAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteConfig(configPath, []byte("new")); err != nil {
+		t.Fatalf("WriteConfig() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil || string(data) != "new" {
+		t.Errorf("Expected 'new', got '%s' (%v)", data, err)
+	}
+	info, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 to be kept, got %o", info.Mode().Perm())
+	}
+
+	// No temporary file is left behind
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the config file, got %d entries", len(entries))
+	}
+}
+
+func TestETag(t *testing.T) {
+	a, b := ETag([]byte("a")), ETag([]byte("b"))
+	if a == b {
+		t.Error("Expected different content to have different ETags")
+	}
+	if a != ETag([]byte("a")) {
+		t.Error("Expected the same content to have the same ETag")
+	}
+	if !strings.HasPrefix(a, `"`) || !strings.HasSuffix(a, `"`) {
+		t.Errorf("Expected a quoted ETag, got %s", a)
+	}
+}
+
+func TestEffectiveBackend(t *testing.T) {
+	cfg := &Config{}
+	if got := cfg.EffectiveBackend(RepoConfig{}); got != BackendCLI {
+		t.Errorf("Expected default backend '%s', got '%s'", BackendCLI, got)
+	}
+
+	cfg.Backend = BackendGoGit
+	if got := cfg.EffectiveBackend(RepoConfig{}); got != BackendGoGit {
+		t.Errorf("Expected global backend '%s', got '%s'", BackendGoGit, got)
+	}
+
+	if got := cfg.EffectiveBackend(RepoConfig{Backend: BackendCLI}); got != BackendCLI {
+		t.Errorf("Expected repo backend '%s' to override global, got '%s'", BackendCLI, got)
+	}
+}
+
+func TestEffectiveMaxConcurrentFetches(t *testing.T) {
+	cfg := &Config{}
+	if got := cfg.EffectiveMaxConcurrentFetches(); got != DefaultMaxConcurrentFetches {
+		t.Errorf("Expected default %d, got %d", DefaultMaxConcurrentFetches, got)
+	}
+
+	cfg.MaxConcurrentFetches = 10
+	if got := cfg.EffectiveMaxConcurrentFetches(); got != 10 {
+		t.Errorf("Expected 10, got %d", got)
+	}
+
+	cfg.Repos = []RepoConfig{{Name: "test", URL: "git@github.com:user/test.git", LocalPath: "/repos/test.git", Interval: "5m"}}
+	cfg.HTTPPort = 8080
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+	cfg.MaxConcurrentFetches = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for negative max_concurrent_fetches")
+	}
+}
+
+func TestResolveRepoSSHKey(t *testing.T) {
+	cfg := &Config{SSHKeyPath: "/keys/global"}
+
+	if got := cfg.ResolveRepo(RepoConfig{}).SSHKeyPath; got != "/keys/global" {
+		t.Errorf("Expected global key '/keys/global', got '%s'", got)
+	}
+	if got := cfg.ResolveRepo(RepoConfig{SSHKeyPath: "/keys/repo"}).SSHKeyPath; got != "/keys/repo" {
+		t.Errorf("Expected repo key '/keys/repo' to override global, got '%s'", got)
+	}
+}
+
+func TestResolveRepoTimeout(t *testing.T) {
+	cfg := &Config{}
+	if got := cfg.ResolveRepo(RepoConfig{}).Timeout; got != DefaultFetchTimeout {
+		t.Errorf("Expected default timeout '%s', got '%s'", DefaultFetchTimeout, got)
+	}
+
+	cfg.FetchTimeout = "5m"
+	if got := cfg.ResolveRepo(RepoConfig{}).Timeout; got != "5m" {
+		t.Errorf("Expected global timeout '5m', got '%s'", got)
+	}
+	if got := cfg.ResolveRepo(RepoConfig{Timeout: "90s"}).Timeout; got != "90s" {
+		t.Errorf("Expected repo timeout '90s' to override global, got '%s'", got)
+	}
+}
+
+func TestValidateDuplicateName(t *testing.T) {
+	repo := RepoConfig{Name: "test", URL: "git@github.com:user/test.git", LocalPath: "/repos/test.git", Interval: "5m"}
+	cfg := Config{Repos: []RepoConfig{repo, repo}, HTTPPort: 8080}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for two repos with the same name")
+	}
+}
+
+func TestValidateTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		repoTimeout  string
+		fetchTimeout string
+		wantErr      bool
+	}{
+		{"unset", "", "", false},
+		{"valid", "10m", "1h", false},
+		{"invalid repo timeout", "soon", "", true},
+		{"zero repo timeout", "0s", "", true},
+		{"negative global timeout", "", "-1m", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Repos: []RepoConfig{
+					{
+						Name:      "test",
+						URL:       "git@github.com:user/test.git",
+						LocalPath: "/repos/test.git",
+						Interval:  "5m",
+						Timeout:   tt.repoTimeout,
+					},
+				},
+				HTTPPort:     8080,
+				FetchTimeout: tt.fetchTimeout,
+			}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveRepoRetry(t *testing.T) {
+	cfg := &Config{}
+	repo := cfg.ResolveRepo(RepoConfig{})
+	if repo.Retry.MaxAttempts != DefaultMaxAttempts || repo.Retry.InitialBackoff != DefaultInitialBackoff {
+		t.Errorf("Expected default retry settings, got %+v", *repo.Retry)
+	}
+	if repo.CircuitBreaker.FailureThreshold != DefaultFailureThreshold || repo.CircuitBreaker.ProbeInterval != DefaultProbeInterval {
+		t.Errorf("Expected default circuit breaker settings, got %+v", *repo.CircuitBreaker)
+	}
+
+	cfg.Retry = &RetryConfig{MaxAttempts: 5, MaxBackoff: "1m"}
+	cfg.CircuitBreaker = &CircuitBreakerConfig{ProbeInterval: "2h"}
+	repo = cfg.ResolveRepo(RepoConfig{})
+	if repo.Retry.MaxAttempts != 5 || repo.Retry.MaxBackoff != "1m" || repo.Retry.InitialBackoff != DefaultInitialBackoff {
+		t.Errorf("Expected global retry settings with defaults, got %+v", *repo.Retry)
+	}
+	if repo.CircuitBreaker.ProbeInterval != "2h" {
+		t.Errorf("Expected global probe interval '2h', got '%s'", repo.CircuitBreaker.ProbeInterval)
+	}
+	if repo.Retry == cfg.Retry {
+		t.Error("Expected resolved retry settings to be a copy")
+	}
+
+	repo = cfg.ResolveRepo(RepoConfig{Retry: &RetryConfig{MaxAttempts: 1}})
+	if repo.Retry.MaxAttempts != 1 || repo.Retry.MaxBackoff != DefaultMaxBackoff {
+		t.Errorf("Expected repo retry settings to override global, got %+v", *repo.Retry)
+	}
+}
+
+func TestResolveRepoBlackoutWindows(t *testing.T) {
+	global := BlackoutWindow{Start: "0 2 * * *", Duration: "2h"}
+	own := BlackoutWindow{Start: "0 12 * * *", Duration: "30m"}
+	cfg := &Config{BlackoutWindows: []BlackoutWindow{global}}
+
+	repo := cfg.ResolveRepo(RepoConfig{BlackoutWindows: []BlackoutWindow{own}})
+	if len(repo.BlackoutWindows) != 2 || repo.BlackoutWindows[0] != global || repo.BlackoutWindows[1] != own {
+		t.Errorf("Expected global and repo windows, got %+v", repo.BlackoutWindows)
+	}
+	if len(cfg.BlackoutWindows) != 1 {
+		t.Errorf("Expected global windows to be left alone, got %+v", cfg.BlackoutWindows)
+	}
+
+	cfg.BlackoutWindows = append(cfg.BlackoutWindows, BlackoutWindow{Start: "never", Duration: "1h"})
+	cfg.Repos = []RepoConfig{{Name: "test", URL: "git@github.com:user/test.git", LocalPath: "/repos/test.git", Interval: "5m"}}
+	cfg.HTTPPort = 8080
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for invalid global blackout window")
+	}
+}
+
+func TestValidateRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   *RetryConfig
+		breaker *CircuitBreakerConfig
+		wantErr bool
+	}{
+		{"unset", nil, nil, false},
+		{"valid", &RetryConfig{MaxAttempts: 4, InitialBackoff: "1s", MaxBackoff: "1m"}, &CircuitBreakerConfig{FailureThreshold: 3, ProbeInterval: "10m"}, false},
+		{"negative attempts", &RetryConfig{MaxAttempts: -1}, nil, true},
+		{"invalid backoff", &RetryConfig{InitialBackoff: "fast"}, nil, true},
+		{"negative threshold", nil, &CircuitBreakerConfig{FailureThreshold: -2}, true},
+		{"invalid probe interval", nil, &CircuitBreakerConfig{ProbeInterval: "0s"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Repos: []RepoConfig{
+					{
+						Name:           "test",
+						URL:            "git@github.com:user/test.git",
+						LocalPath:      "/repos/test.git",
+						Interval:       "5m",
+						Retry:          tt.retry,
+						CircuitBreaker: tt.breaker,
+					},
+				},
+				HTTPPort: 8080,
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			cfg.Repos[0].Retry, cfg.Repos[0].CircuitBreaker = nil, nil
+			cfg.Retry, cfg.CircuitBreaker = tt.retry, tt.breaker
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() with global settings error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFindRepo(t *testing.T) {
+	cfg := &Config{Repos: []RepoConfig{{Name: "a"}, {Name: "b"}}}
+
+	if i := cfg.FindRepo("b"); i != 1 {
+		t.Errorf("Expected index 1, got %d", i)
+	}
+	if i := cfg.FindRepo("missing"); i != -1 {
+		t.Errorf("Expected -1 for missing repo, got %d", i)
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    AuthConfig
+		wantErr bool
+	}{
+		{"ssh", AuthConfig{Type: AuthSSH}, false},
+		{"none", AuthConfig{Type: AuthNone}, false},
+		{"token from env", AuthConfig{Type: AuthHTTPSToken, TokenEnv: "GITEA_TOKEN"}, false},
+		{"token from file", AuthConfig{Type: AuthHTTPSToken, TokenFile: "/run/secrets/token"}, false},
+		{"token without source", AuthConfig{Type: AuthHTTPSToken}, true},
+		{"basic", AuthConfig{Type: AuthBasic, Username: "bot", PasswordEnv: "BOT_PASSWORD"}, false},
+		{"basic without username", AuthConfig{Type: AuthBasic, PasswordEnv: "BOT_PASSWORD"}, true},
+		{"basic without password", AuthConfig{Type: AuthBasic, Username: "bot"}, true},
+		{"unknown type", AuthConfig{Type: "kerberos"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.auth.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthConfigCredentials(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "env-token")
+
+	tmpDir := t.TempDir()
+	passwordFile := filepath.Join(tmpDir, "password")
+	if err := os.WriteFile(passwordFile, []byte("file-password\n"), 0600); err != nil {
+		t.Fatalf("Failed to write password file: %v", err)
+	}
+
+	token := &AuthConfig{Type: AuthHTTPSToken, TokenEnv: "TEST_TOKEN"}
+	username, secret, err := token.Credentials()
+	if err != nil {
+		t.Fatalf("Credentials() failed: %v", err)
+	}
+	if username != defaultTokenUsername || secret != "env-token" {
+		t.Errorf("Expected (%s, env-token), got (%s, %s)", defaultTokenUsername, username, secret)
+	}
+
+	basic := &AuthConfig{Type: AuthBasic, Username: "bot", PasswordFile: passwordFile}
+	username, secret, err = basic.Credentials()
+	if err != nil {
+		t.Fatalf("Credentials() failed: %v", err)
+	}
+	if username != "bot" || secret != "file-password" {
+		t.Errorf("Expected (bot, file-password), got (%s, %s)", username, secret)
+	}
+
+	missing := &AuthConfig{Type: AuthHTTPSToken, TokenEnv: "TEST_TOKEN_UNSET"}
+	if _, _, err := missing.Credentials(); err == nil {
+		t.Error("Expected error for unset env var")
+	}
+}
+
+func TestValidateRepoAuth(t *testing.T) {
+	cfg := Config{
+		Repos: []RepoConfig{
+			{
+				Name:      "test",
+				URL:       "https://git.example.com/repo.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "5m",
+				Auth:      &AuthConfig{Type: AuthHTTPSToken},
+			},
+		},
+		HTTPPort: 8080,
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for incomplete auth block")
+	}
+}
+
+func TestHostKeyConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     HostKeyConfig
+		wantErr bool
+	}{
+		{"defaults", HostKeyConfig{}, false},
+		{"strict", HostKeyConfig{Policy: HostKeyStrict}, false},
+		{"unknown policy", HostKeyConfig{Policy: "yolo"}, true},
+		{"pinned", HostKeyConfig{Pinned: map[string][]string{"github.com": {"SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU"}}}, false},
+		{"pinned without fingerprints", HostKeyConfig{Pinned: map[string][]string{"github.com": nil}}, true},
+		{"pinned md5 fingerprint", HostKeyConfig{Pinned: map[string][]string{"github.com": {"16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if !(&HostKeyConfig{}).TOFU() {
+		t.Error("Expected trust-on-first-use to be the default policy")
+	}
+	if (&HostKeyConfig{Policy: HostKeyStrict}).TOFU() {
+		t.Error("Expected strict policy to disable trust-on-first-use")
+	}
+}
+
+func TestStorageConfigValidate(t *testing.T) {
+	pg := PostgresConfig{Host: "db", Name: "redmine", User: "redmine"}
+	tests := []struct {
+		name    string
+		storage StorageConfig
+		wantErr bool
+	}{
+		{"default", StorageConfig{}, false},
+		{"sqlite", StorageConfig{Driver: StorageSQLite, Path: "/data/gitfetcher.db", Retention: "720h"}, false},
+		{"postgres", StorageConfig{Driver: StoragePostgres, Postgres: pg}, false},
+		{"postgres without host", StorageConfig{Driver: StoragePostgres, Postgres: PostgresConfig{Name: "redmine", User: "redmine"}}, true},
+		{"invalid schema", StorageConfig{Driver: StoragePostgres, Postgres: PostgresConfig{Host: "db", Name: "redmine", User: "redmine", Schema: "git; DROP"}}, true},
+		{"unknown driver", StorageConfig{Driver: "mysql"}, true},
+		{"invalid retention", StorageConfig{Retention: "forever"}, true},
+		{"keep forever", StorageConfig{Retention: "0s"}, false},
+		{"negative retention", StorageConfig{Retention: "-1h"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.storage.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	pg := PostgresConfig{Host: "db", Name: "redmine", User: "redmine", PasswordEnv: "TEST_GITFETCHER_PG_PASSWORD"}
+
+	if _, err := pg.DSN(); err == nil {
+		t.Error("Expected error when the password variable is not set")
+	}
+
+	t.Setenv("TEST_GITFETCHER_PG_PASSWORD", "it's secret")
+	dsn, err := pg.DSN()
+	if err != nil {
+		t.Fatalf("DSN() failed: %v", err)
+	}
+	want := `host='db' port=5432 user='redmine' password='it\'s secret' dbname='redmine' sslmode='disable'`
+	if dsn != want {
+		t.Errorf("Expected %s, got %s", want, dsn)
+	}
+	if pg.SchemaName() != DefaultPostgresSchema {
+		t.Errorf("Expected default schema %s, got %s", DefaultPostgresSchema, pg.SchemaName())
+	}
+}
+
+func TestParseStaleAfter(t *testing.T) {
+	var h HealthConfig
+	if d, err := h.ParseStaleAfter(); err != nil || d != 24*time.Hour {
+		t.Errorf("Expected default of 24h, got %s (%v)", d, err)
+	}
+	h.StaleAfter = "2h"
+	if d, _ := h.ParseStaleAfter(); d != 2*time.Hour {
+		t.Errorf("Expected 2h, got %s", d)
+	}
+}
+
+func TestUsesSSHKey(t *testing.T) {
+	tests := []struct {
+		repo RepoConfig
+		want bool
+	}{
+		{RepoConfig{SSHKeyPath: "/keys/id"}, true},
+		{RepoConfig{SSHKeyPath: "/keys/id", Auth: &AuthConfig{Type: AuthSSH}}, true},
+		{RepoConfig{SSHKeyPath: "/keys/id", Auth: &AuthConfig{Type: AuthHTTPSToken}}, false},
+		{RepoConfig{}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.repo.UsesSSHKey(); got != tt.want {
+			t.Errorf("UsesSSHKey(%+v) = %v, expected %v", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestWebAuthConfigValidate(t *testing.T) {
+	token := APIToken{Name: "ci", Role: RoleOperator, TokenEnv: "GITFETCHER_CI_TOKEN"}
+	tests := []struct {
+		name    string
+		auth    WebAuthConfig
+		enabled bool
+		wantErr bool
+	}{
+		{"open", WebAuthConfig{}, false, false},
+		{"users file", WebAuthConfig{UsersFile: "/data/users", SessionTTL: "8h"}, true, false},
+		{"token", WebAuthConfig{Tokens: []APIToken{token}}, true, false},
+		{"invalid session_ttl", WebAuthConfig{UsersFile: "/data/users", SessionTTL: "forever"}, true, true},
+		{"unknown role", WebAuthConfig{Tokens: []APIToken{{Name: "ci", Role: "root", TokenEnv: "X"}}}, true, true},
+		{"token without secret", WebAuthConfig{Tokens: []APIToken{{Name: "ci", Role: RoleViewer}}}, true, true},
+		{"token without name", WebAuthConfig{Tokens: []APIToken{{Role: RoleViewer, TokenEnv: "X"}}}, true, true},
+		{"duplicate token", WebAuthConfig{Tokens: []APIToken{token, token}}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.auth.Enabled() != tt.enabled {
+				t.Errorf("Expected Enabled() = %v", tt.enabled)
+			}
+			if err := tt.auth.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSessionTTL(t *testing.T) {
+	var w WebAuthConfig
+	if d, err := w.ParseSessionTTL(); err != nil || d != 12*time.Hour {
+		t.Errorf("Expected default of 12h, got %s (%v)", d, err)
+	}
+	w.SessionTTL = "30m"
+	if d, _ := w.ParseSessionTTL(); d != 30*time.Minute {
+		t.Errorf("Expected 30m, got %s", d)
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	var w WebhookConfig
+	if w.Enabled() {
+		t.Error("Expected webhooks to be disabled without a secret")
+	}
+	if d, err := w.ParseDebounce(); err != nil || d != 5*time.Second {
+		t.Errorf("Expected default debounce of 5s, got %s (%v)", d, err)
+	}
+
+	w = WebhookConfig{SecretEnv: "GITFETCHER_HOOK_SECRET", Debounce: "soon"}
+	if !w.Enabled() {
+		t.Error("Expected webhooks to be enabled with a secret")
+	}
+	if err := w.Validate(); err == nil {
+		t.Error("Expected an error for an invalid debounce")
+	}
+	t.Setenv("GITFETCHER_HOOK_SECRET", "s3cret")
+	if secret, err := w.Secret(); err != nil || secret != "s3cret" {
+		t.Errorf("Expected the secret from the environment, got %q (%v)", secret, err)
+	}
+}
+
+func TestRedmineConfigValidate(t *testing.T) {
+	repo := RepoConfig{Name: "r", URL: "git@github.com:u/r.git", LocalPath: "/repos/r.git", Interval: "5m", RedmineProject: "r"}
+	tests := []struct {
+		name    string
+		redmine *RedmineConfig
+		wantErr bool
+	}{
+		{"valid", &RedmineConfig{URL: "http://redmine:3000", KeyEnv: "REDMINE_WS_KEY"}, false},
+		{"project without redmine", nil, true},
+		{"relative url", &RedmineConfig{URL: "redmine:3000", KeyEnv: "REDMINE_WS_KEY"}, true},
+		{"missing key", &RedmineConfig{URL: "http://redmine:3000"}, true},
+		{"invalid timeout", &RedmineConfig{URL: "http://redmine:3000", KeyFile: "/run/secrets/ws", Timeout: "later"}, true},
+		{"project without url", &RedmineConfig{Database: &PostgresConfig{Host: "db", Name: "redmine", User: "redmine"}}, true},
+		{"relative mirrors_path", &RedmineConfig{URL: "http://redmine:3000", KeyEnv: "K", MirrorsPath: "repos"}, true},
+		{"incomplete database", &RedmineConfig{URL: "http://redmine:3000", KeyEnv: "K", Database: &PostgresConfig{Host: "db"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Repos: []RepoConfig{repo}, HTTPPort: 8080, Redmine: tt.redmine}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedmineDatabase(t *testing.T) {
+	cfg := Config{}
+	if _, ok := cfg.RedmineDatabase(); ok {
+		t.Error("Expected no Redmine database with SQLite storage")
+	}
+
+	cfg.Storage = StorageConfig{Driver: StoragePostgres, Postgres: PostgresConfig{Host: "db", Name: "redmine", User: "redmine", Schema: "gitfetcher"}}
+	if db, ok := cfg.RedmineDatabase(); !ok || db.Host != "db" || db.Schema != "" {
+		t.Errorf("Expected the storage database in the default schema, got %+v %v", db, ok)
+	}
+
+	cfg.Redmine = &RedmineConfig{Database: &PostgresConfig{Host: "redmine-db", Name: "redmine", User: "ro"}}
+	if db, _ := cfg.RedmineDatabase(); db.Host != "redmine-db" {
+		t.Errorf("Expected redmine.database to take precedence, got %+v", db)
+	}
+	if repositories, mirrors := cfg.Redmine.Paths(); repositories != DefaultRedmineRepositoriesPath || mirrors != DefaultMirrorsPath {
+		t.Errorf("Unexpected default paths %s %s", repositories, mirrors)
+	}
+}
+
+func TestSourceConfigValidate(t *testing.T) {
+	valid := SourceConfig{Name: "acme", Provider: SourceGitHub, Org: "acme", Interval: "1h"}
+	tests := []struct {
+		name    string
+		modify  func(*SourceConfig)
+		wantErr bool
+	}{
+		{"valid", func(s *SourceConfig) {}, false},
+		{"user", func(s *SourceConfig) { s.Org, s.User = "", "bob" }, false},
+		{"org and user", func(s *SourceConfig) { s.User = "bob" }, true},
+		{"no owner", func(s *SourceConfig) { s.Org = "" }, true},
+		{"unknown provider", func(s *SourceConfig) { s.Provider = "bitbucket" }, true},
+		{"gitea without url", func(s *SourceConfig) { s.Provider = SourceGitea }, true},
+		{"gitea", func(s *SourceConfig) { s.Provider, s.URL = SourceGitea, "https://gitea.example.com" }, false},
+		{"bad glob", func(s *SourceConfig) { s.Exclude = []string{"[abc"} }, true},
+		{"bad interval", func(s *SourceConfig) { s.Interval = "" }, true},
+		{"bad refresh", func(s *SourceConfig) { s.Refresh = "hourly" }, true},
+		{"bad archived policy", func(s *SourceConfig) { s.Archived = "delete" }, true},
+		{"bad template", func(s *SourceConfig) { s.LocalPath = "/repos/{{.Nmae}}.git" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := valid
+			tt.modify(&src)
+			if err := src.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A config may consist of sources only, their names must be unique
+	cfg := Config{Sources: []SourceConfig{valid}, HTTPPort: 8080}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a config with only sources to be valid, got %v", err)
+	}
+	cfg.Sources = append(cfg.Sources, valid)
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for duplicate source names")
+	}
+}
+
+func TestSourceConfig(t *testing.T) {
+	src := SourceConfig{Name: "acme", Provider: SourceGitHub, Org: "acme", Include: []string{"api-*", "web"}, Exclude: []string{"*-legacy"}}
+	for name, want := range map[string]bool{"api-core": true, "web": true, "api-legacy": false, "docs": false} {
+		if got := src.Matches(name); got != want {
+			t.Errorf("Matches(%q) = %t, want %t", name, got, want)
+		}
+	}
+	if src.APIURL() != DefaultGitHubAPI || src.ArchivedPolicy() != ArchivedDisable {
+		t.Errorf("Unexpected defaults %s %s", src.APIURL(), src.ArchivedPolicy())
+	}
+	if name, path, err := src.Render(SourceRepo{Source: "acme", Owner: "acme", Name: "api"}); err != nil || name != "api" || path != "/repos/acme/api.git" {
+		t.Errorf("Render() = %q, %q, %v", name, path, err)
+	}
+
+	// Names from the provider API cannot move the local path out of the mirrors
+	for _, repo := range []SourceRepo{{Owner: "acme", Name: ".."}, {Owner: "..", Name: "x"}, {Owner: "acme", Name: "../../etc"}, {Owner: "acme", Name: "a\nb"}} {
+		if _, path, err := src.Render(repo); err == nil {
+			t.Errorf("Expected Render(%+v) to fail, got %q", repo, path)
+		}
+	}
+	escaping := SourceConfig{LocalPath: "/repos/{{.Name}}/../../{{.Owner}}"}
+	if _, path, err := escaping.Render(SourceRepo{Owner: "etc", Name: "x"}); err == nil {
+		t.Errorf("Expected a local path outside /repos to be refused, got %q", path)
+	}
+	prefixed := SourceConfig{LocalPath: "/repos/acme-{{.Name}}.git"}
+	if _, path, err := prefixed.Render(SourceRepo{Name: "api"}); err != nil || path != "/repos/acme-api.git" {
+		t.Errorf("Render() = %q, %v", path, err)
+	}
+
+	gitea := SourceConfig{Provider: SourceGitea, URL: "https://gitea.example.com/"}
+	if got := gitea.APIURL(); got != "https://gitea.example.com/api/v1" {
+		t.Errorf("APIURL() = %q", got)
+	}
+}
+
+func TestValidatePushMirrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		mirrors []PushMirror
+		wantErr bool
+	}{
+		{"valid", "", []PushMirror{{Name: "gitea", URL: "git@gitea:m/r.git"}, {Name: "nas", URL: "/mnt/nas/r.git"}}, false},
+		{"go-git backend", BackendGoGit, []PushMirror{{Name: "nas", URL: "/mnt/nas/r.git"}}, true},
+		{"missing name", "", []PushMirror{{URL: "/mnt/nas/r.git"}}, true},
+		{"duplicate name", "", []PushMirror{{Name: "nas", URL: "/mnt/a.git"}, {Name: "nas", URL: "/mnt/b.git"}}, true},
+		{"missing url", "", []PushMirror{{Name: "nas"}}, true},
+		{"upstream url", "", []PushMirror{{Name: "loop", URL: "git@github.com:u/r.git"}}, true},
+		{"invalid auth", "", []PushMirror{{Name: "gitea", URL: "https://gitea/m/r.git", Auth: &AuthConfig{Type: AuthHTTPSToken}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := RepoConfig{Name: "r", URL: "git@github.com:u/r.git", LocalPath: "/repos/r.git", Interval: "5m", Backend: tt.backend, PushMirrors: tt.mirrors}
+			cfg := Config{Repos: []RepoConfig{repo}, HTTPPort: 8080}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRefBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		backup  *RefBackupConfig
+		wantErr bool
+	}{
+		{"defaults", "", &RefBackupConfig{}, false},
+		{"keep forever", "", &RefBackupConfig{Retention: "0s", MaxPerRef: 10}, false},
+		{"go-git backend", BackendGoGit, &RefBackupConfig{}, true},
+		{"invalid retention", "", &RefBackupConfig{Retention: "forever"}, true},
+		{"negative retention", "", &RefBackupConfig{Retention: "-1h"}, true},
+		{"negative max_per_ref", "", &RefBackupConfig{MaxPerRef: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := RepoConfig{Name: "r", URL: "git@github.com:u/r.git", LocalPath: "/repos/r.git", Interval: "5m", Backend: tt.backend, RefBackup: tt.backup}
+			cfg := Config{Repos: []RepoConfig{repo}, HTTPPort: 8080}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	retention, err := (&RefBackupConfig{}).ParseRetention()
+	if err != nil || retention != 2160*time.Hour {
+		t.Errorf("Expected the default retention of 90 days, got %v (%v)", retention, err)
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+
+	want := "--- rev 1\n+++ rev 2\n" +
+		"@@ -1,5 +1,5 @@\n" +
+		" a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -11,3 +11,4 @@\n" +
+		" k\n l\n m\n+n\n"
+	if got := Diff([]byte(from), []byte(to), "rev 1", "rev 2"); got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestDiffMergesCloseChanges(t *testing.T) {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n"
+	to := "1\nx\n3\n4\n5\n6\ny\n8\n"
+
+	got := Diff([]byte(from), []byte(to), "a", "b")
+	if n := strings.Count(got, "@@ -"); n != 1 {
+		t.Errorf("Expected a single hunk, got %d:\n%s", n, got)
+	}
+	if !strings.Contains(got, "@@ -1,8 +1,8 @@\n") {
+		t.Errorf("Expected hunk over all 8 lines, got\n%s", got)
+	}
+}
+
+func TestDiffEdgeCases(t *testing.T) {
+	if got := Diff([]byte("a\nb\n"), []byte("a\nb\n"), "a", "b"); got != "" {
+		t.Errorf("Expected no diff for identical files, got\n%s", got)
+	}
+	if got := Diff([]byte("a\r\nb\r\n"), []byte("a\nb"), "a", "b"); got != "" {
+		t.Errorf("Expected line endings to be ignored, got\n%s", got)
+	}
+
+	want := "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if got := Diff(nil, []byte("x\ny\n"), "a", "b"); got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+	want = "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-x\n-y\n"
+	if got := Diff([]byte("x\ny\n"), nil, "a", "b"); got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxBlackoutSkips bounds the search for a fetch time outside of blackout windows
+const maxBlackoutSkips = 1000
+
+// BlackoutWindow is a recurring period without scheduled fetches. It begins
+// at every match of the cron expression Start and lasts Duration, e.g.
+// start "0 2 * * *" with duration "2h" blocks 02:00-04:00 every night.
+type BlackoutWindow struct {
+	Start    string `yaml:"start" json:"start"`
+	Duration string `yaml:"duration" json:"duration"`
+}
+
+// Validate checks the start expression and duration
+func (w BlackoutWindow) Validate() error {
+	_, err := w.parse()
+	return err
+}
+
+// blackout is a parsed BlackoutWindow
+type blackout struct {
+	start    cron.Schedule
+	duration time.Duration
+}
+
+func (w BlackoutWindow) parse() (blackout, error) {
+	start, err := cron.ParseStandard(w.Start)
+	if err != nil {
+		return blackout{}, fmt.Errorf("blackout window: invalid start '%s': %w", w.Start, err)
+	}
+	d, err := time.ParseDuration(w.Duration)
+	if err != nil || d <= 0 {
+		return blackout{}, fmt.Errorf("blackout window: invalid duration '%s'", w.Duration)
+	}
+	return blackout{start: start, duration: d}, nil
+}
+
+// end returns when the window covering t closes, ok is false if t is outside it
+func (b blackout) end(t time.Time) (end time.Time, ok bool) {
+	// The latest start that still covers t is the first one after t-duration
+	start := b.start.Next(t.Add(-b.duration))
+	if start.IsZero() || start.After(t) {
+		return time.Time{}, false
+	}
+	return start.Add(b.duration), true
+}
+
+// Schedule decides when a repository is fetched. It is either a fixed
+// interval or a cron expression, minus the blackout windows.
+type Schedule struct {
+	every     time.Duration
+	cron      cron.Schedule
+	blackouts []blackout
+}
+
+// ParseSchedule parses Interval, a duration ("5m") or a standard cron
+// expression ("*/10 8-20 * * 1-5"), together with the repo blackout windows
+func (r *RepoConfig) ParseSchedule() (*Schedule, error) {
+	s := &Schedule{}
+	if d, err := time.ParseDuration(r.Interval); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+		s.every = d
+	} else {
+		c, cronErr := cron.ParseStandard(r.Interval)
+		if cronErr != nil {
+			return nil, fmt.Errorf("neither a duration nor a cron expression: %w", cronErr)
+		}
+		s.cron = c
+	}
+
+	for _, w := range r.BlackoutWindows {
+		b, err := w.parse()
+		if err != nil {
+			return nil, err
+		}
+		s.blackouts = append(s.blackouts, b)
+	}
+	return s, nil
+}
+
+// IsCron reports whether the schedule is a cron expression
+func (s *Schedule) IsCron() bool {
+	return s.cron != nil
+}
+
+// First returns the first fetch time after start. Interval schedules fetch
+// right away, cron schedules wait for their first match.
+func (s *Schedule) First(now time.Time) time.Time {
+	if s.IsCron() {
+		return s.Next(now)
+	}
+	return s.skipBlackouts(now)
+}
+
+// Next returns the next fetch time after t that is outside every blackout window
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.IsCron() {
+		return s.skipBlackouts(s.cron.Next(t))
+	}
+	return s.skipBlackouts(t.Add(s.every))
+}
+
+// InBlackout reports whether t falls in a blackout window and when it ends
+func (s *Schedule) InBlackout(t time.Time) (end time.Time, ok bool) {
+	for _, b := range s.blackouts {
+		if e, in := b.end(t); in && e.After(end) {
+			end, ok = e, true
+		}
+	}
+	return end, ok
+}
+
+// skipBlackouts moves t past blackout windows. Cron schedules skip to their
+// first match after the window, interval schedules resume when it closes.
+func (s *Schedule) skipBlackouts(t time.Time) time.Time {
+	for i := 0; i < maxBlackoutSkips; i++ {
+		end, ok := s.InBlackout(t)
+		if !ok {
+			return t
+		}
+		if s.IsCron() {
+			// cron.Next is exclusive, step back so a match right at end counts
+			t = s.cron.Next(end.Add(-time.Second))
+		} else {
+			t = end
+		}
+	}
+	return t
+}
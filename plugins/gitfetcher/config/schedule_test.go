@@ -0,0 +1,126 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		interval string
+		cron     bool
+		wantErr  bool
+	}{
+		{"5m", false, false},
+		{"*/10 8-20 * * 1-5", true, false},
+		{"@hourly", true, false},
+		{"CRON_TZ=Asia/Taipei 0 3 * * *", true, false},
+		{"0s", false, true},
+		{"-5m", false, true},
+		{"every minute", false, true},
+		{"", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			repo := RepoConfig{Interval: tt.interval}
+			schedule, err := repo.ParseSchedule()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && schedule.IsCron() != tt.cron {
+				t.Errorf("Expected IsCron() = %v", tt.cron)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Friday
+	now := time.Date(2024, 3, 1, 20, 55, 0, 0, time.UTC)
+
+	repo := RepoConfig{Interval: "15m"}
+	schedule, _ := repo.ParseSchedule()
+	if got := schedule.First(now); !got.Equal(now) {
+		t.Errorf("Expected interval schedule to start immediately, got %s", got)
+	}
+	if got := schedule.Next(now); !got.Equal(now.Add(15 * time.Minute)) {
+		t.Errorf("Expected next fetch at %s, got %s", now.Add(15*time.Minute), got)
+	}
+
+	repo = RepoConfig{Interval: "*/10 8-20 * * 1-5"}
+	schedule, _ = repo.ParseSchedule()
+	want := time.Date(2024, 3, 4, 8, 0, 0, 0, time.UTC) // Monday morning
+	if got := schedule.First(now); !got.Equal(want) {
+		t.Errorf("Expected first cron fetch at %s, got %s", want, got)
+	}
+}
+
+func TestBlackoutWindow(t *testing.T) {
+	repo := RepoConfig{
+		Interval:        "1h",
+		BlackoutWindows: []BlackoutWindow{{Start: "0 2 * * *", Duration: "2h"}},
+	}
+	schedule, err := repo.ParseSchedule()
+	if err != nil {
+		t.Fatalf("ParseSchedule() failed: %v", err)
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		at      time.Duration
+		blocked bool
+	}{
+		{1*time.Hour + 59*time.Minute, false},
+		{2 * time.Hour, true},
+		{3*time.Hour + 30*time.Minute, true},
+		{4 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		end, blocked := schedule.InBlackout(day.Add(tt.at))
+		if blocked != tt.blocked {
+			t.Errorf("InBlackout(%s) = %v, expected %v", day.Add(tt.at), blocked, tt.blocked)
+		}
+		if blocked && !end.Equal(day.Add(4*time.Hour)) {
+			t.Errorf("Expected window to end at 04:00, got %s", end)
+		}
+	}
+
+	// Interval schedules resume when the window closes
+	if got := schedule.Next(day.Add(90 * time.Minute)); !got.Equal(day.Add(4 * time.Hour)) {
+		t.Errorf("Expected next fetch at 04:00, got %s", got)
+	}
+	if got := schedule.First(day.Add(3 * time.Hour)); !got.Equal(day.Add(4 * time.Hour)) {
+		t.Errorf("Expected first fetch at 04:00, got %s", got)
+	}
+
+	// Cron schedules skip to their first match after the window
+	repo.Interval = "30 * * * *"
+	schedule, _ = repo.ParseSchedule()
+	if got := schedule.Next(day.Add(90 * time.Minute)); !got.Equal(day.Add(4*time.Hour + 30*time.Minute)) {
+		t.Errorf("Expected next fetch at 04:30, got %s", got)
+	}
+	repo.Interval = "0 * * * *"
+	schedule, _ = repo.ParseSchedule()
+	if got := schedule.Next(day.Add(90 * time.Minute)); !got.Equal(day.Add(4 * time.Hour)) {
+		t.Errorf("Expected a match right at the end of the window to count, got %s", got)
+	}
+}
+
+func TestBlackoutWindowValidate(t *testing.T) {
+	tests := []struct {
+		window  BlackoutWindow
+		wantErr bool
+	}{
+		{BlackoutWindow{Start: "0 2 * * *", Duration: "2h"}, false},
+		{BlackoutWindow{Start: "0 2 * * *", Duration: "0s"}, true},
+		{BlackoutWindow{Start: "at night", Duration: "2h"}, true},
+		{BlackoutWindow{Start: "0 2 * * *"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.window.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, wantErr %v", tt.window, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// Source providers
+const (
+	SourceGitHub = "github" // github.com or GitHub Enterprise
+	SourceGitea  = "gitea"
+)
+
+// What happens to the archived repositories of a source
+const (
+	ArchivedDisable = "disable" // keep them with enabled: false, the default
+	ArchivedFetch   = "fetch"   // mirror them like any other repo
+	ArchivedRetire  = "retire"  // drop them like a deleted repository
+)
+
+// Clone URL of discovered repos
+const (
+	ProtocolSSH   = "ssh"
+	ProtocolHTTPS = "https"
+)
+
+// Source defaults
+const (
+	DefaultGitHubAPI       = "https://api.github.com"
+	DefaultSourceRefresh   = "1h"
+	DefaultSourceRepoName  = "{{.Name}}"
+	DefaultSourceLocalPath = "/repos/{{.Owner}}/{{.Name}}.git"
+)
+
+// SourceConfig discovers the repositories of a GitHub, GitHub Enterprise or
+// Gitea organization (Org) or user (User) every Refresh. Repos whose name
+// matches one of Include (all by default) and none of Exclude are mirrored
+// with Interval, Auth and the RepoName and LocalPath templates, which see
+// .Source, .Owner and .Name. A repo that is deleted or no longer matches is
+// retired, its mirror is left on disk.
+type SourceConfig struct {
+	Name      string      `yaml:"name" json:"name"`
+	Provider  string      `yaml:"provider" json:"provider"`
+	URL       string      `yaml:"url,omitempty" json:"url,omitempty"` // API of GitHub Enterprise (https://ghe/api/v3) or base URL of Gitea
+	Org       string      `yaml:"org,omitempty" json:"org,omitempty"`
+	User      string      `yaml:"user,omitempty" json:"user,omitempty"`
+	TokenEnv  string      `yaml:"token_env,omitempty" json:"token_env,omitempty"`
+	TokenFile string      `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+	Include   []string    `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude   []string    `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	Archived  string      `yaml:"archived,omitempty" json:"archived,omitempty"`
+	Refresh   string      `yaml:"refresh,omitempty" json:"refresh,omitempty"`
+	Interval  string      `yaml:"interval" json:"interval"`
+	RepoName  string      `yaml:"repo_name,omitempty" json:"repo_name,omitempty"`
+	LocalPath string      `yaml:"local_path,omitempty" json:"local_path,omitempty"`
+	Protocol  string      `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Auth      *AuthConfig `yaml:"auth,omitempty" json:"auth,omitempty"`
+}
+
+// SourceRepo is what the RepoName and LocalPath templates of a source see
+type SourceRepo struct {
+	Source string
+	Owner  string
+	Name   string
+}
+
+// Token reads the API token, it is empty when neither TokenEnv nor TokenFile is set
+func (s *SourceConfig) Token() (string, error) {
+	if s.TokenEnv == "" && s.TokenFile == "" {
+		return "", nil
+	}
+	return readSecret(s.TokenEnv, s.TokenFile)
+}
+
+// APIURL returns the base URL of the provider API
+func (s *SourceConfig) APIURL() string {
+	u := strings.TrimRight(s.URL, "/")
+	switch {
+	case s.Provider == SourceGitHub && u == "":
+		return DefaultGitHubAPI
+	case s.Provider == SourceGitea:
+		return u + "/api/v1"
+	}
+	return u
+}
+
+// ParseRefresh returns how often the source is listed, DefaultSourceRefresh if unset
+func (s *SourceConfig) ParseRefresh() (time.Duration, error) {
+	if s.Refresh == "" {
+		return time.ParseDuration(DefaultSourceRefresh)
+	}
+	return time.ParseDuration(s.Refresh)
+}
+
+// ArchivedPolicy returns Archived, ArchivedDisable if unset
+func (s *SourceConfig) ArchivedPolicy() string {
+	if s.Archived == "" {
+		return ArchivedDisable
+	}
+	return s.Archived
+}
+
+// Matches reports whether a repository name passes the include and exclude globs
+func (s *SourceConfig) Matches(name string) bool {
+	included := len(s.Include) == 0
+	for _, pattern := range s.Include {
+		if ok, _ := path.Match(pattern, name); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range s.Exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Render returns the repo name and local path of a discovered repository.
+// Owner and Name come from the provider API, each must be a single path
+// segment, and the local path must stay below the directory the LocalPath
+// template starts with.
+func (s *SourceConfig) Render(repo SourceRepo) (name, localPath string, err error) {
+	for _, v := range []string{repo.Owner, repo.Name} {
+		if !pathSegment(v) {
+			return "", "", fmt.Errorf("invalid owner or repository name '%s'", v)
+		}
+	}
+	nameTmpl, pathTmpl, err := s.templates()
+	if err != nil {
+		return "", "", err
+	}
+	var b strings.Builder
+	if err := nameTmpl.Execute(&b, repo); err != nil {
+		return "", "", fmt.Errorf("repo_name: %w", err)
+	}
+	name = b.String()
+	b.Reset()
+	if err := pathTmpl.Execute(&b, repo); err != nil {
+		return "", "", fmt.Errorf("local_path: %w", err)
+	}
+	localPath = filepath.Clean(b.String())
+	if root := s.localPathRoot(); !isWithin(localPath, root) {
+		return "", "", fmt.Errorf("local_path '%s' is outside %s", localPath, root)
+	}
+	return name, localPath, nil
+}
+
+// localPathRoot returns the directory of the static start of the LocalPath
+// template, every rendered local path must be below it
+func (s *SourceConfig) localPathRoot() string {
+	text := s.LocalPath
+	if text == "" {
+		text = DefaultSourceLocalPath
+	}
+	static, _, _ := strings.Cut(text, "{{")
+	return filepath.Dir(static)
+}
+
+// pathSegment reports whether v is empty or a single file name
+func pathSegment(v string) bool {
+	if v == "." || v == ".." || strings.ContainsAny(v, `/\`) {
+		return false
+	}
+	return !strings.ContainsFunc(v, unicode.IsControl)
+}
+
+// isWithin reports whether path lies inside dir
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// templates parses RepoName and LocalPath, or their defaults
+func (s *SourceConfig) templates() (name, localPath *template.Template, err error) {
+	nameText, pathText := s.RepoName, s.LocalPath
+	if nameText == "" {
+		nameText = DefaultSourceRepoName
+	}
+	if pathText == "" {
+		pathText = DefaultSourceLocalPath
+	}
+	name, err = template.New("repo_name").Option("missingkey=error").Parse(nameText)
+	if err != nil {
+		return nil, nil, err
+	}
+	localPath, err = template.New("local_path").Option("missingkey=error").Parse(pathText)
+	if err != nil {
+		return nil, nil, err
+	}
+	return name, localPath, nil
+}
+
+// Validate checks the provider, owner, filters, templates and durations
+func (s *SourceConfig) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch s.Provider {
+	case SourceGitHub:
+	case SourceGitea:
+		if s.URL == "" {
+			return fmt.Errorf("url is required for gitea")
+		}
+	default:
+		return fmt.Errorf("unknown provider '%s'", s.Provider)
+	}
+	if s.URL != "" {
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an http(s) URL, got '%s'", s.URL)
+		}
+	}
+	if (s.Org == "") == (s.User == "") {
+		return fmt.Errorf("exactly one of org and user is required")
+	}
+	for _, pattern := range append(append([]string(nil), s.Include...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob '%s'", pattern)
+		}
+	}
+	switch s.Archived {
+	case "", ArchivedDisable, ArchivedFetch, ArchivedRetire:
+	default:
+		return fmt.Errorf("unknown archived policy '%s'", s.Archived)
+	}
+	switch s.Protocol {
+	case "", ProtocolSSH, ProtocolHTTPS:
+	default:
+		return fmt.Errorf("unknown protocol '%s'", s.Protocol)
+	}
+	if !validTimeout(s.Refresh) {
+		return fmt.Errorf("invalid refresh '%s'", s.Refresh)
+	}
+	repo := RepoConfig{Interval: s.Interval}
+	if _, err := repo.ParseSchedule(); err != nil {
+		return fmt.Errorf("invalid interval '%s': %w", s.Interval, err)
+	}
+	if _, _, err := s.Render(SourceRepo{Source: s.Name, Owner: "owner", Name: "repo"}); err != nil {
+		return err
+	}
+	if s.Auth != nil {
+		if err := s.Auth.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package deploykey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrExists is returned when a key is already stored for a repo and overwrite is false
+var ErrExists = errors.New("deploy key already exists")
+
+// unsafeChars matches everything that is not allowed in a key file name
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// stagedSuffix marks the files of a generated key until it is committed
+const stagedSuffix = ".new"
+
+// Key is a generated deploy key, only the public half ever leaves the secrets directory
+type Key struct {
+	Path        string `json:"path"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Path returns where the private key of repo is stored under secretsDir.
+// Names that had to be sanitized get a hash of the original name appended,
+// so "a/b" and "a b" do not share the key file of "a_b".
+func Path(secretsDir, repo string) (string, error) {
+	name := unsafeChars.ReplaceAllString(repo, "_")
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		return "", fmt.Errorf("invalid repository name '%s'", repo)
+	}
+	if name != repo {
+		sum := sha256.Sum256([]byte(repo))
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(secretsDir, "deploy_keys", name), nil
+}
+
+// Generate creates an ed25519 keypair for repo. The private key is written in
+// OpenSSH format with mode 0600, the public key next to it with a .pub suffix.
+// Both are staged next to Path until Commit, an existing key is only replaced
+// then, or left alone by Discard.
+func Generate(secretsDir, repo, comment string, overwrite bool) (*Key, error) {
+	path, err := Path(secretsDir, repo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return nil, ErrExists
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	if comment != "" {
+		authorized += " " + comment
+	}
+
+	if err := writeFile(path+stagedSuffix, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(path+".pub"+stagedSuffix, []byte(authorized+"\n"), 0644); err != nil {
+		os.Remove(path + stagedSuffix)
+		return nil, err
+	}
+
+	return &Key{
+		Path:        path,
+		PublicKey:   authorized,
+		Fingerprint: ssh.FingerprintSHA256(sshPub),
+	}, nil
+}
+
+// Commit moves a key staged by Generate to Path, replacing the previous key
+func (k *Key) Commit() error {
+	for _, path := range []string{k.Path, k.Path + ".pub"} {
+		if err := os.Rename(path+stagedSuffix, path); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// Discard removes a key staged by Generate
+func (k *Key) Discard() {
+	os.Remove(k.Path + stagedSuffix)
+	os.Remove(k.Path + ".pub" + stagedSuffix)
+}
+
+// Load reads the public key previously generated for repo
+func Load(secretsDir, repo string) (*Key, error) {
+	path, err := Path(secretsDir, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, err
+	}
+	sshPub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	return &Key{
+		Path:        path,
+		PublicKey:   strings.TrimSpace(string(data)),
+		Fingerprint: ssh.FingerprintSHA256(sshPub),
+	}, nil
+}
+
+// writeFile replaces path atomically so a crash never leaves half a key behind
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package deploykey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		repo    string
+		want    string
+		wantErr bool
+	}{
+		{"my-project", filepath.Join("/secrets", "deploy_keys", "my-project"), false},
+		{"org/project", filepath.Join("/secrets", "deploy_keys", "org_project-f64f1547"), false},
+		{"../../etc/passwd", filepath.Join("/secrets", "deploy_keys", "_.._etc_passwd-3754d6cb"), false},
+		// Names that sanitize to the same file get different keys
+		{"a_b", filepath.Join("/secrets", "deploy_keys", "a_b"), false},
+		{"a/b", filepath.Join("/secrets", "deploy_keys", "a_b-c14cddc0"), false},
+		{"a b", filepath.Join("/secrets", "deploy_keys", "a_b-c8687a08"), false},
+		{"..", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := Path("/secrets", tt.repo)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Path(%q) error = %v, wantErr %v", tt.repo, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Path(%q) = %q, want %q", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := Generate(dir, "my-project", "gitfetcher@my-project", false)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if _, err := os.Stat(key.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the key to be staged until Commit, got %v", err)
+	}
+	if err := key.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	if !strings.HasPrefix(key.PublicKey, "ssh-ed25519 ") || !strings.HasSuffix(key.PublicKey, " gitfetcher@my-project") {
+		t.Errorf("Unexpected public key: %s", key.PublicKey)
+	}
+
+	info, err := os.Stat(key.Path)
+	if err != nil {
+		t.Fatalf("Expected private key file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected private key mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// The private key must be usable by ssh and match the public key
+	data, _ := os.ReadFile(key.Path)
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	if ssh.FingerprintSHA256(signer.PublicKey()) != key.Fingerprint {
+		t.Error("Private key does not match the returned fingerprint")
+	}
+
+	loaded, err := Load(dir, "my-project")
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if loaded.PublicKey != key.PublicKey || loaded.Fingerprint != key.Fingerprint {
+		t.Errorf("Expected Load() to return the generated key, got %+v", loaded)
+	}
+}
+
+func TestGenerateExisting(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := Generate(dir, "my-project", "", false)
+	if err != nil || first.Commit() != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	if _, err := Generate(dir, "my-project", "", false); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists, got %v", err)
+	}
+
+	// A discarded key leaves the previous one in place
+	discarded, err := Generate(dir, "my-project", "", true)
+	if err != nil {
+		t.Fatalf("Generate() with overwrite failed: %v", err)
+	}
+	discarded.Discard()
+	if loaded, err := Load(dir, "my-project"); err != nil || loaded.Fingerprint != first.Fingerprint {
+		t.Errorf("Expected the first key to be kept after Discard, got %+v (%v)", loaded, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(first.Path)); len(entries) != 2 {
+		t.Errorf("Expected no staged files after Discard, got %d files", len(entries))
+	}
+
+	second, err := Generate(dir, "my-project", "", true)
+	if err != nil || second.Commit() != nil {
+		t.Fatalf("Generate() with overwrite failed: %v", err)
+	}
+	if second.Fingerprint == first.Fingerprint {
+		t.Error("Expected overwrite to rotate the key")
+	}
+	if loaded, _ := Load(dir, "my-project"); loaded.Fingerprint != second.Fingerprint {
+		t.Error("Expected the committed key to replace the first one")
+	}
+}
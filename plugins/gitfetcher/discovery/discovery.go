@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// pageSize is asked for on every page, providers may return fewer
+const pageSize = 50
+
+// maxPages stops a listing that keeps announcing a next page
+const maxPages = 200
+
+// Repository is a repository as listed by the provider API. GitHub and Gitea
+// use the same field names.
+type Repository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	CloneURL string `json:"clone_url"`
+	SSHURL   string `json:"ssh_url"`
+	Archived bool   `json:"archived"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+// Discover lists the repositories of src and returns the repos to mirror
+func Discover(ctx context.Context, src config.SourceConfig) ([]config.RepoConfig, error) {
+	listed, err := List(ctx, http.DefaultClient, src)
+	if err != nil {
+		return nil, err
+	}
+	return Repos(src, listed)
+}
+
+// List returns every repository of the org or user of src, following the
+// pagination links of the API
+func List(ctx context.Context, client *http.Client, src config.SourceConfig) ([]Repository, error) {
+	token, err := src.Token()
+	if err != nil {
+		return nil, fmt.Errorf("source token: %w", err)
+	}
+
+	owner := "users/" + url.PathEscape(src.User)
+	if src.Org != "" {
+		owner = "orgs/" + url.PathEscape(src.Org)
+	}
+	query := url.Values{"page": {"1"}}
+	if src.Provider == config.SourceGitHub {
+		query.Set("per_page", fmt.Sprint(pageSize))
+	} else {
+		query.Set("limit", fmt.Sprint(pageSize))
+	}
+	next := src.APIURL() + "/" + owner + "/repos?" + query.Encode()
+
+	var repos []Repository
+	for page := 0; next != ""; page++ {
+		if page == maxPages {
+			return nil, fmt.Errorf("%s: more than %d pages of repositories", src.Name, maxPages)
+		}
+		var batch []Repository
+		next, err = get(ctx, client, src.Provider, next, token, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", src.Name, err)
+		}
+		repos = append(repos, batch...)
+		if next != "" && !sameHost(next, src.APIURL()) {
+			// The token must not be sent anywhere else
+			return nil, fmt.Errorf("%s: next page on another host: %s", src.Name, next)
+		}
+	}
+	return repos, nil
+}
+
+// sameHost reports whether two URLs have the same scheme and host
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Scheme == ub.Scheme && ua.Host == ub.Host
+}
+
+// get decodes one page into v and returns the URL of the next one, if any
+func get(ctx context.Context, client *http.Client, provider, endpoint, token string, v any) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		if provider == config.SourceGitea {
+			req.Header.Set("Authorization", "token "+token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("listing repositories: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("listing repositories: %w", err)
+	}
+	return nextLink(resp.Header.Get("Link")), nil
+}
+
+// nextLink returns the rel="next" URL of a Link header
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.ReplaceAll(strings.TrimSpace(param), " ", "") == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// Repos turns listed repositories into repo configs: repositories filtered out
+// by include and exclude are dropped, archived ones follow the archived policy
+func Repos(src config.SourceConfig, listed []Repository) ([]config.RepoConfig, error) {
+	var repos []config.RepoConfig
+	for _, r := range listed {
+		if !src.Matches(r.Name) {
+			continue
+		}
+		if r.Archived && src.ArchivedPolicy() == config.ArchivedRetire {
+			continue
+		}
+
+		owner := r.Owner.Login
+		if owner == "" {
+			owner, _, _ = strings.Cut(r.FullName, "/")
+		}
+		name, localPath, err := src.Render(config.SourceRepo{Source: src.Name, Owner: owner, Name: r.Name})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", src.Name, r.FullName, err)
+		}
+		remote := r.SSHURL
+		if src.Protocol == config.ProtocolHTTPS {
+			remote = r.CloneURL
+		}
+		if name == "" || localPath == "" || remote == "" {
+			return nil, fmt.Errorf("%s: %s: empty name, local path or URL", src.Name, r.FullName)
+		}
+
+		repo := config.RepoConfig{
+			Name:      name,
+			URL:       remote,
+			LocalPath: localPath,
+			Interval:  src.Interval,
+			Auth:      src.Auth,
+			Source:    src.Name,
+		}
+		if r.Archived && src.ArchivedPolicy() == config.ArchivedDisable {
+			disabled := false
+			repo.Enabled = &disabled
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// fakeAPI serves repos of owner on GitHub's and Gitea's list endpoints, two per page
+func fakeAPI(t *testing.T, owner string, names []string, archived map[string]bool) *httptest.Server {
+	var srv *httptest.Server
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer t0ken" && got != "token t0ken" {
+			http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		start, end := (page-1)*2, min(page*2, len(names))
+		if end < len(names) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next", <%s%s?page=99>; rel="last"`, srv.URL, r.URL.Path, page+1, srv.URL, r.URL.Path))
+		}
+		fmt.Fprint(w, "[")
+		for i := start; i < end; i++ {
+			if i > start {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"name": %q, "full_name": "%s/%s", "clone_url": "https://git.example.com/%s/%s.git", "ssh_url": "git@git.example.com:%s/%s.git", "archived": %t, "owner": {"login": %q}}`,
+				names[i], owner, names[i], owner, names[i], owner, names[i], archived[names[i]], owner)
+		}
+		fmt.Fprint(w, "]")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/"+owner+"/repos", handler)         // GitHub
+	mux.HandleFunc("/api/v1/users/"+owner+"/repos", handler) // Gitea
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestList(t *testing.T) {
+	t.Setenv("SOURCE_TOKEN", "t0ken")
+	names := []string{"api", "web", "docs", "infra", "tools"}
+	srv := fakeAPI(t, "acme", names, nil)
+
+	src := config.SourceConfig{Name: "acme", Provider: config.SourceGitHub, URL: srv.URL, Org: "acme", TokenEnv: "SOURCE_TOKEN"}
+	repos, err := List(context.Background(), srv.Client(), src)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(repos) != len(names) || repos[4].Name != "tools" || repos[0].Owner.Login != "acme" {
+		t.Errorf("Expected every page to be listed, got %+v", repos)
+	}
+
+	gitea := fakeAPI(t, "bob", names[:1], nil)
+	src = config.SourceConfig{Name: "bob", Provider: config.SourceGitea, URL: gitea.URL + "/", User: "bob", TokenEnv: "SOURCE_TOKEN"}
+	if repos, err := List(context.Background(), gitea.Client(), src); err != nil || len(repos) != 1 {
+		t.Errorf("Expected the Gitea user repos, got %+v %v", repos, err)
+	}
+
+	t.Setenv("SOURCE_TOKEN", "wrong")
+	if _, err := List(context.Background(), srv.Client(), src); err == nil {
+		t.Error("Expected an error for a rejected token")
+	}
+}
+
+func TestListRejectsForeignNextPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://elsewhere.example.com/orgs/acme/repos?page=2>; rel="next"`)
+		fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	src := config.SourceConfig{Name: "acme", Provider: config.SourceGitHub, URL: srv.URL, Org: "acme"}
+	if _, err := List(context.Background(), srv.Client(), src); err == nil {
+		t.Error("Expected a next page on another host to be refused")
+	}
+}
+
+func TestRepos(t *testing.T) {
+	listed := func(name string, archived bool) Repository {
+		r := Repository{Name: name, FullName: "acme/" + name, CloneURL: "https://github.com/acme/" + name + ".git", SSHURL: "git@github.com:acme/" + name + ".git", Archived: archived}
+		r.Owner.Login = "acme"
+		return r
+	}
+	all := []Repository{listed("api", false), listed("api-legacy", true), listed("web", false), listed("sandbox-1", false)}
+
+	tests := []struct {
+		name     string
+		src      config.SourceConfig
+		want     []string
+		disabled []string
+	}{
+		{"defaults", config.SourceConfig{}, []string{"api", "api-legacy", "web", "sandbox-1"}, []string{"api-legacy"}},
+		{"include", config.SourceConfig{Include: []string{"api*"}}, []string{"api", "api-legacy"}, []string{"api-legacy"}},
+		{"exclude", config.SourceConfig{Exclude: []string{"sandbox-*"}}, []string{"api", "api-legacy", "web"}, []string{"api-legacy"}},
+		{"retire archived", config.SourceConfig{Archived: config.ArchivedRetire}, []string{"api", "web", "sandbox-1"}, nil},
+		{"fetch archived", config.SourceConfig{Archived: config.ArchivedFetch}, []string{"api", "api-legacy", "web", "sandbox-1"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			src.Name, src.Provider, src.Org, src.Interval = "acme", config.SourceGitHub, "acme", "1h"
+			repos, err := Repos(src, all)
+			if err != nil {
+				t.Fatalf("Repos() failed: %v", err)
+			}
+			var names, disabled []string
+			for _, repo := range repos {
+				names = append(names, repo.Name)
+				if !repo.IsEnabled() {
+					disabled = append(disabled, repo.Name)
+				}
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) || fmt.Sprint(disabled) != fmt.Sprint(tt.disabled) {
+				t.Errorf("Got repos %v (disabled %v), want %v (disabled %v)", names, disabled, tt.want, tt.disabled)
+			}
+		})
+	}
+
+	src := config.SourceConfig{
+		Name: "acme", Provider: config.SourceGitHub, Org: "acme", Interval: "30m",
+		RepoName: "{{.Owner}}-{{.Name}}", LocalPath: "/srv/{{.Source}}/{{.Name}}.git", Protocol: config.ProtocolHTTPS,
+	}
+	repos, err := Repos(src, all[:1])
+	if err != nil || len(repos) != 1 {
+		t.Fatalf("Repos() = %v, %v", repos, err)
+	}
+	want := config.RepoConfig{Name: "acme-api", URL: "https://github.com/acme/api.git", LocalPath: "/srv/acme/api.git", Interval: "30m", Source: "acme"}
+	if repos[0].Name != want.Name || repos[0].URL != want.URL || repos[0].LocalPath != want.LocalPath || repos[0].Interval != want.Interval || repos[0].Source != want.Source {
+		t.Errorf("Got %+v, want %+v", repos[0], want)
+	}
+	// A name that would leave the mirrors directory fails the listing
+	if repos, err := Repos(src, []Repository{listed("..", false)}); err == nil {
+		t.Errorf("Expected a repository named .. to be refused, got %+v", repos)
+	}
+}
@@ -0,0 +1,266 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// ReservedNamespace holds the refs gitfetcher writes itself. Fetches never
+// prune it and push mirrors never receive it.
+const ReservedNamespace = "refs/gitfetcher/"
+
+// BackupPrefix is followed by the time of the backup and the backed up ref
+// without "refs/", e.g. refs/gitfetcher/backup/20261016T120000Z/heads/main
+const BackupPrefix = ReservedNamespace + "backup/"
+
+// backupTimeFormat is the UTC time in backup ref names, refs cannot contain colons
+const backupTimeFormat = "20060102T150405Z"
+
+// Errors returned by RestoreBackup
+var (
+	ErrUnknownBackup = errors.New("backup not found")
+	ErrInvalidTarget = errors.New("invalid target ref")
+)
+
+// Backup is a ref saved before a fetch force-updated or pruned it
+type Backup struct {
+	Ref      string // the backup ref
+	Original string // the ref that was backed up
+	SHA      string
+	Time     time.Time
+}
+
+// excludeReserved returns the git options that keep fetch --prune away from
+// ReservedNamespace in the repository at path. Mirrors fetch +refs/*:refs/*
+// and would delete every ref their remotes do not have.
+func excludeReserved(ctx context.Context, path string) ([]string, error) {
+	output, err := gitCommand(ctx, "-C", path, "remote").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	var args []string
+	for _, remote := range strings.Fields(string(output)) {
+		args = append(args, "-c", "remote."+remote+".fetch=^"+ReservedNamespace+"*")
+	}
+	return args, nil
+}
+
+// settleTimeout bounds settling the backups of a fetch, which also runs
+// after the fetch was cancelled
+const settleTimeout = time.Minute
+
+// settleBackups deletes the backups stageBackups took of the refs the fetch
+// did not force-update or prune, expires old backups and returns the backups
+// that were kept. It runs after failed fetches too, a fetch may rewrite refs
+// before it fails. Nothing is lost when it fails, the staged backups stay
+// until they expire.
+func (gf *GitFetcher) settleBackups(ctx context.Context, repo config.RepoConfig, before map[string]string, staged []Backup) []Backup {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settleTimeout)
+	defer cancel()
+
+	kept, err := keepBackups(ctx, repo.LocalPath, before, staged)
+	if err != nil {
+		log.Printf("Failed to drop unneeded ref backups of %s, they stay until they expire: %v", repo.Name, err)
+		return nil
+	}
+	if expired, err := expireBackups(ctx, repo.LocalPath, repo.RefBackup, time.Now()); err != nil {
+		log.Printf("Failed to expire ref backups of %s: %v", repo.Name, err)
+	} else if expired > 0 {
+		log.Printf("Expired %d ref backups of %s", expired, repo.Name)
+	}
+	return kept
+}
+
+// backupRef returns the name of the backup of original taken at t
+func backupRef(original string, t time.Time) string {
+	return BackupPrefix + t.UTC().Format(backupTimeFormat) + "/" + strings.TrimPrefix(original, "refs/")
+}
+
+// parseBackup is the inverse of backupRef
+func parseBackup(ref, sha string) (Backup, bool) {
+	stamp, rest, ok := strings.Cut(strings.TrimPrefix(ref, BackupPrefix), "/")
+	if !ok || !strings.HasPrefix(ref, BackupPrefix) || rest == "" {
+		return Backup{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, stamp)
+	if err != nil {
+		return Backup{}, false
+	}
+	return Backup{Ref: ref, Original: "refs/" + rest, SHA: sha, Time: t}, true
+}
+
+// backupsOf returns the backups among refs, newest first
+func backupsOf(refs map[string]string) []Backup {
+	var backups []Backup
+	for ref, sha := range refs {
+		if b, ok := parseBackup(ref, sha); ok {
+			backups = append(backups, b)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].Time.After(backups[j].Time)
+		}
+		return backups[i].Original < backups[j].Original
+	})
+	return backups
+}
+
+// ListBackups returns the backups of the mirror at path, newest first
+func ListBackups(ctx context.Context, path string) ([]Backup, error) {
+	refs, err := listRefs(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return backupsOf(refs), nil
+}
+
+// stageBackups backs up every ref of before ahead of a fetch, so the fetch
+// cannot drop a commit that was not saved. before is the ref snapshot taken
+// before the fetch, a ref whose commit already has a backup is not backed up
+// again.
+func stageBackups(ctx context.Context, path string, before map[string]string, now time.Time) ([]Backup, error) {
+	saved := make(map[string]bool)
+	for _, b := range backupsOf(before) {
+		saved[b.Original+" "+b.SHA] = true
+	}
+
+	var backups []Backup
+	for ref, sha := range before {
+		if strings.HasPrefix(ref, ReservedNamespace) || saved[ref+" "+sha] {
+			continue
+		}
+		// A fetch in the same second may have kept a backup of the ref already
+		at := now.UTC().Truncate(time.Second)
+		for before[backupRef(ref, at)] != "" {
+			at = at.Add(time.Second)
+		}
+		backups = append(backups, Backup{Ref: backupRef(ref, at), Original: ref, SHA: sha, Time: at})
+	}
+	if len(backups) == 0 {
+		return nil, nil
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Original < backups[j].Original })
+
+	var stdin strings.Builder
+	for _, b := range backups {
+		fmt.Fprintf(&stdin, "create %s %s\n", b.Ref, b.SHA)
+	}
+	if err := updateRefs(ctx, path, stdin.String()); err != nil {
+		return nil, fmt.Errorf("backing up refs: %w", err)
+	}
+	return backups, nil
+}
+
+// keepBackups compares the refs of the mirror at path with before and deletes
+// the staged backups of every ref that was not force-updated or deleted since.
+// It returns the backups that were kept.
+func keepBackups(ctx context.Context, path string, before map[string]string, staged []Backup) ([]Backup, error) {
+	if len(staged) == 0 {
+		return nil, nil
+	}
+	after, err := listRefs(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	rewritten := make(map[string]bool)
+	for _, change := range diffRefs(before, after, cliIsAncestor(ctx, path)) {
+		if change.Forced || change.Type == RefDeleted {
+			rewritten[change.Ref] = true
+		}
+	}
+
+	var kept []Backup
+	var stdin strings.Builder
+	for _, b := range staged {
+		if rewritten[b.Original] {
+			kept = append(kept, b)
+			continue
+		}
+		fmt.Fprintf(&stdin, "delete %s %s\n", b.Ref, b.SHA)
+	}
+	if stdin.Len() > 0 {
+		if err := updateRefs(ctx, path, stdin.String()); err != nil {
+			return nil, fmt.Errorf("dropping unneeded backups: %w", err)
+		}
+	}
+	return kept, nil
+}
+
+// expireBackups deletes the backups that are older than the retention of
+// cfg, or beyond its MaxPerRef newest backups of a ref
+func expireBackups(ctx context.Context, path string, cfg *config.RefBackupConfig, now time.Time) (int, error) {
+	retention, err := cfg.ParseRetention()
+	if err != nil {
+		return 0, err
+	}
+	backups, err := ListBackups(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+
+	var stdin strings.Builder
+	expired := 0
+	kept := make(map[string]int)
+	for _, b := range backups {
+		old := retention > 0 && now.Sub(b.Time) > retention
+		if old || (cfg.MaxPerRef > 0 && kept[b.Original] >= cfg.MaxPerRef) {
+			fmt.Fprintf(&stdin, "delete %s %s\n", b.Ref, b.SHA)
+			expired++
+			continue
+		}
+		kept[b.Original]++
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	if err := updateRefs(ctx, path, stdin.String()); err != nil {
+		return 0, fmt.Errorf("expiring backups: %w", err)
+	}
+	return expired, nil
+}
+
+// RestoreBackup points target at the commit of the backup ref in the mirror
+// at path, target defaults to the Original of the backup. The next fetch
+// rewrites target again as long as the upstream does not have it, the caller
+// must keep the repo from being fetched.
+func RestoreBackup(ctx context.Context, path, ref, target string) (Backup, error) {
+	backups, err := ListBackups(ctx, path)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, b := range backups {
+		if b.Ref != ref {
+			continue
+		}
+		if target == "" {
+			target = b.Original
+		}
+		if !strings.HasPrefix(target, "refs/") || strings.HasPrefix(target, ReservedNamespace) ||
+			gitCommand(ctx, "check-ref-format", target).Run() != nil {
+			return Backup{}, fmt.Errorf("%w '%s'", ErrInvalidTarget, target)
+		}
+		if err := updateRefs(ctx, path, fmt.Sprintf("update %s %s\n", target, b.SHA)); err != nil {
+			return Backup{}, fmt.Errorf("restoring %s: %w", ref, err)
+		}
+		return b, nil
+	}
+	return Backup{}, ErrUnknownBackup
+}
+
+// updateRefs runs git update-ref --stdin, all updates are applied or none
+func updateRefs(ctx context.Context, path, stdin string) error {
+	cmd := gitCommand(ctx, "-C", path, "update-ref", "--stdin")
+	cmd.Stdin = strings.NewReader(stdin)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", ctxCause(ctx, err), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
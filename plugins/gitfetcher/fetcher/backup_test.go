@@ -0,0 +1,235 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+func TestParseBackup(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 0, 5, 0, time.UTC)
+	ref := backupRef("refs/heads/feature/x", at.In(time.FixedZone("CEST", 2*3600)))
+	if ref != "refs/gitfetcher/backup/20261016T120005Z/heads/feature/x" {
+		t.Fatalf("Unexpected backup ref %s", ref)
+	}
+	b, ok := parseBackup(ref, "abc")
+	if !ok || b.Original != "refs/heads/feature/x" || !b.Time.Equal(at) || b.SHA != "abc" {
+		t.Errorf("Expected the backup of feature/x at %s, got %+v", at, b)
+	}
+
+	for _, ref := range []string{"refs/heads/main", "refs/gitfetcher/backup/yesterday/heads/main", "refs/gitfetcher/backup/20261016T120005Z"} {
+		if _, ok := parseBackup(ref, "abc"); ok {
+			t.Errorf("Expected %s not to be a backup", ref)
+		}
+	}
+}
+
+func TestRefBackup(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sourceRepo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	workRepo := filepath.Join(filepath.Dir(sourceRepo), "work")
+	gitRun(t, workRepo, "branch", "feature")
+	gitRun(t, workRepo, "branch", "doomed")
+	gitRun(t, workRepo, "push", "origin", "feature", "doomed")
+
+	tmp := t.TempDir()
+	repo := config.RepoConfig{
+		Name:      "test-repo",
+		URL:       sourceRepo,
+		LocalPath: filepath.Join(tmp, "mirror.git"),
+		RefBackup: &config.RefBackupConfig{},
+	}
+	gf := NewGitFetcher("", "")
+	if result := gf.Fetch(context.Background(), repo); !result.Success {
+		t.Fatalf("Clone failed: %s", result.Message)
+	}
+	before, _ := listRefs(context.Background(), repo.LocalPath)
+
+	// Rewrite feature, delete doomed and fast-forward HEAD
+	gitRun(t, workRepo, "checkout", "-q", "feature")
+	gitRun(t, workRepo, "commit", "--amend", "-m", "rewritten")
+	gitRun(t, workRepo, "push", "--force", "origin", "feature")
+	gitRun(t, workRepo, "push", "origin", "--delete", "doomed")
+	gitRun(t, workRepo, "checkout", "-q", "-")
+	commitFile(t, workRepo, "next.txt", "next")
+	gitRun(t, workRepo, "push", "origin", "HEAD")
+
+	result := gf.Fetch(context.Background(), repo)
+	if !result.Success {
+		t.Fatalf("Fetch failed: %s", result.Message)
+	}
+	if len(result.Backups) != 2 {
+		t.Fatalf("Expected feature and doomed to be backed up, got %+v", result.Backups)
+	}
+	backups, err := ListBackups(context.Background(), repo.LocalPath)
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("Expected 2 backups in the mirror, got %+v (%v)", backups, err)
+	}
+	for _, b := range backups {
+		if b.SHA != before[b.Original] {
+			t.Errorf("Expected the backup of %s to point at %s, got %s", b.Original, before[b.Original], b.SHA)
+		}
+	}
+
+	// Backups survive the prune of later fetches and are not pushed
+	if result := gf.Fetch(context.Background(), repo); len(result.Changes) != 0 || len(result.Backups) != 0 {
+		t.Errorf("Expected nothing to change on a second fetch, got %+v", result)
+	}
+	if backups, _ := ListBackups(context.Background(), repo.LocalPath); len(backups) != 2 {
+		t.Errorf("Expected the backups to survive the next fetch, got %+v", backups)
+	}
+	pushMirror := filepath.Join(tmp, "push.git")
+	if err := exec.Command("git", "init", "--bare", pushMirror).Run(); err != nil {
+		t.Fatalf("Failed to create push mirror: %v", err)
+	}
+	if result := gf.Push(context.Background(), repo, config.PushMirror{Name: "nas", URL: pushMirror}); !result.Success {
+		t.Fatalf("Push failed: %s", result.Message)
+	}
+	pushed, _ := listRefs(context.Background(), pushMirror)
+	for ref := range pushed {
+		if strings.HasPrefix(ref, ReservedNamespace) {
+			t.Errorf("Expected backups to stay local, %s was pushed", ref)
+		}
+	}
+	if _, ok := pushed["refs/heads/feature"]; !ok {
+		t.Errorf("Expected the branches to be pushed, got %v", pushed)
+	}
+
+	// Restoring brings the deleted branch back, and is not backed up twice
+	var doomed Backup
+	for _, b := range backups {
+		if b.Original == "refs/heads/doomed" {
+			doomed = b
+		}
+	}
+	if _, err := RestoreBackup(context.Background(), repo.LocalPath, doomed.Ref, ""); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if refs, _ := listRefs(context.Background(), repo.LocalPath); refs["refs/heads/doomed"] != doomed.SHA {
+		t.Errorf("Expected doomed to be restored at %s, got %v", doomed.SHA, refs)
+	}
+	if result := gf.Fetch(context.Background(), repo); len(result.Changes) != 1 || len(result.Backups) != 0 {
+		t.Errorf("Expected the restored branch to be pruned again without a new backup, got %+v", result)
+	}
+
+	if _, err := RestoreBackup(context.Background(), repo.LocalPath, "refs/heads/feature", ""); !errors.Is(err, ErrUnknownBackup) {
+		t.Errorf("Expected ErrUnknownBackup, got %v", err)
+	}
+	for _, target := range []string{"heads/x", "refs/gitfetcher/x", "refs/heads/a..b"} {
+		if _, err := RestoreBackup(context.Background(), repo.LocalPath, doomed.Ref, target); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("Expected ErrInvalidTarget for %s, got %v", target, err)
+		}
+	}
+}
+
+func TestRefBackupFailures(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sourceRepo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo := config.RepoConfig{
+		Name:      "test-repo",
+		URL:       sourceRepo,
+		LocalPath: filepath.Join(t.TempDir(), "mirror.git"),
+		RefBackup: &config.RefBackupConfig{},
+	}
+	gf := NewGitFetcher("", "")
+	if result := gf.Fetch(context.Background(), repo); !result.Success {
+		t.Fatalf("Clone failed: %s", result.Message)
+	}
+	before, _ := listRefs(context.Background(), repo.LocalPath)
+
+	// The backups staged for a fetch that fails are dropped again
+	moved := sourceRepo + ".moved"
+	if err := os.Rename(sourceRepo, moved); err != nil {
+		t.Fatal(err)
+	}
+	if result := gf.Fetch(context.Background(), repo); result.Success {
+		t.Fatal("Expected the fetch of a missing upstream to fail")
+	}
+	if backups, _ := ListBackups(context.Background(), repo.LocalPath); len(backups) != 0 {
+		t.Errorf("Expected no backups after a failed fetch, got %+v", backups)
+	}
+	if err := os.Rename(moved, sourceRepo); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fetch is not run when its refs cannot be backed up
+	for _, sha := range before {
+		gitRun(t, repo.LocalPath, "update-ref", strings.TrimSuffix(BackupPrefix, "/"), sha)
+		break
+	}
+	workRepo := filepath.Join(filepath.Dir(sourceRepo), "work")
+	gitRun(t, workRepo, "commit", "--amend", "-m", "rewritten")
+	gitRun(t, workRepo, "push", "--force", "origin", "HEAD")
+	result := gf.Fetch(context.Background(), repo)
+	if result.Success || result.Status != StatusFailed || !strings.Contains(result.Message, "backing up refs") {
+		t.Errorf("Expected the fetch to fail backing up refs, got %+v", result)
+	}
+	after, _ := listRefs(context.Background(), repo.LocalPath)
+	for ref, sha := range before {
+		if after[ref] != sha {
+			t.Errorf("Expected %s to be left at %s, got %s", ref, sha, after[ref])
+		}
+	}
+}
+
+func TestExpireBackups(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sourceRepo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	refs, _ := listRefs(context.Background(), sourceRepo)
+	var sha string
+	for _, s := range refs {
+		sha = s
+	}
+
+	now := time.Now()
+	for _, b := range []struct {
+		ref string
+		age time.Duration
+	}{
+		{"refs/heads/main", time.Hour},
+		{"refs/heads/main", 2 * time.Hour},
+		{"refs/heads/main", 3 * time.Hour},
+		{"refs/heads/old", 100 * 24 * time.Hour},
+		{"refs/tags/v1", 24 * time.Hour},
+	} {
+		gitRun(t, sourceRepo, "update-ref", backupRef(b.ref, now.Add(-b.age)), sha)
+	}
+
+	expired, err := expireBackups(context.Background(), sourceRepo, &config.RefBackupConfig{Retention: "720h", MaxPerRef: 2}, now)
+	if err != nil || expired != 2 {
+		t.Fatalf("Expected 2 expired backups, got %d (%v)", expired, err)
+	}
+	backups, _ := ListBackups(context.Background(), sourceRepo)
+	var left []string
+	for _, b := range backups {
+		left = append(left, b.Original)
+	}
+	if strings.Join(left, " ") != "refs/heads/main refs/heads/main refs/tags/v1" {
+		t.Errorf("Expected the 2 newest backups of main and the one of v1, got %v", left)
+	}
+
+	// Zero retention keeps backups forever
+	gitRun(t, sourceRepo, "update-ref", backupRef("refs/heads/old", now.Add(-1000*24*time.Hour)), sha)
+	if expired, _ := expireBackups(context.Background(), sourceRepo, &config.RefBackupConfig{Retention: "0s"}, now); expired != 0 {
+		t.Errorf("Expected nothing to expire without retention, got %d", expired)
+	}
+}
@@ -0,0 +1,41 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// waitDelay bounds how long Wait blocks on output pipes still held open by
+// children of a killed git process
+const waitDelay = 5 * time.Second
+
+// gitCommand builds a git invocation bound to ctx. When ctx is done the whole
+// process group is killed, including ssh and git-remote-* helpers.
+func gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// failStatus returns the status for a failed operation, a done ctx wins over the error itself
+func failStatus(ctx context.Context) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return StatusTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		return StatusCanceled
+	}
+	return StatusFailed
+}
+
+// ctxCause explains a failure, once ctx is done its cause replaces err
+// (e.g. "timed out after 10m0s" instead of "signal: killed")
+func ctxCause(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// newTarpit accepts TCP connections and never answers, like a stalled SSH server
+func newTarpit(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		<-done
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	return ln.Addr().String()
+}
+
+func TestFailStatus(t *testing.T) {
+	if got := failStatus(context.Background()); got != StatusFailed {
+		t.Errorf("Expected %s for a live context, got %s", StatusFailed, got)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("cancelled by user"))
+	if got := failStatus(ctx); got != StatusCanceled {
+		t.Errorf("Expected %s, got %s", StatusCanceled, got)
+	}
+	if err := ctxCause(ctx, errors.New("signal: killed")); err.Error() != "cancelled by user" {
+		t.Errorf("Expected cancel cause, got %v", err)
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), 0)
+	defer stop()
+	if got := failStatus(ctx); got != StatusTimeout {
+		t.Errorf("Expected %s, got %s", StatusTimeout, got)
+	}
+}
+
+func TestFetchCanceledContext(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sourceRepo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, f := range map[string]Fetcher{
+		"cli":    NewGitFetcher("", ""),
+		"go-git": NewGoGitFetcher("", ""),
+	} {
+		t.Run(name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "mirror.git")
+			result := f.Fetch(ctx, config.RepoConfig{Name: "test-repo", URL: sourceRepo, LocalPath: target})
+
+			if result.Success {
+				t.Fatal("Expected fetch with a cancelled context to fail")
+			}
+			if result.Status != StatusCanceled {
+				t.Errorf("Expected status %s, got %s (%s)", StatusCanceled, result.Status, result.Message)
+			}
+			if _, err := os.Stat(target); !os.IsNotExist(err) {
+				t.Error("Expected the partial clone to be removed")
+			}
+		})
+	}
+}
+
+func TestFetchTimeoutKillsSSH(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh not found in PATH")
+	}
+
+	addr := newTarpit(t)
+	gf := NewGitFetcher("", "")
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 500*time.Millisecond, errors.New("timed out after 500ms"))
+	defer cancel()
+
+	start := time.Now()
+	result := gf.Fetch(ctx, config.RepoConfig{
+		Name:      "test-repo",
+		URL:       "ssh://git@" + addr + "/repo.git",
+		LocalPath: filepath.Join(t.TempDir(), "mirror.git"),
+	})
+	elapsed := time.Since(start)
+
+	if result.Status != StatusTimeout {
+		t.Errorf("Expected status %s, got %s (%s)", StatusTimeout, result.Status, result.Message)
+	}
+	// If only git was killed, ssh would keep the output pipe open until waitDelay
+	if elapsed >= waitDelay {
+		t.Errorf("Expected the process group to be killed promptly, took %v", elapsed)
+	}
+}
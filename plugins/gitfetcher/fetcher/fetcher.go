@@ -1,25 +1,53 @@
 package fetcher
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/hostkeys"
+)
+
+// FetchResult statuses, host key failures are reported separately so they
+// are not mistaken for an ordinary network error
+const (
+	StatusSuccess        = "success"
+	StatusFailed         = "failed"
+	StatusHostKeyChanged = "host_key_changed"
+	StatusHostKeyUnknown = "host_key_unknown"
+	StatusTimeout        = "timeout"
+	StatusCanceled       = "canceled"
 )
 
 type FetchResult struct {
 	RepoName  string
 	Success   bool
+	Status    string
 	Message   string
+	Changes   []RefChange
+	Backups   []Backup // refs saved before the fetch rewrote or pruned them
 	Timestamp time.Time
 }
 
+// Fetcher is implemented by every git backend the scheduler can drive
+// Every git operation is bound to ctx and aborted once it is done.
+type Fetcher interface {
+	// Clone creates a mirror of repo.URL at repo.LocalPath
+	Clone(ctx context.Context, repo config.RepoConfig) *FetchResult
+	// Fetch updates the mirror at repo.LocalPath, cloning it first if it does not exist
+	Fetch(ctx context.Context, repo config.RepoConfig) *FetchResult
+}
+
+// GitFetcher is the CLI backend, it shells out to the git binary
 type GitFetcher struct {
 	sshKeyPath string
 	logPath    string
+	hostKeys   *hostkeys.Store
 }
 
 func NewGitFetcher(sshKeyPath, logPath string) *GitFetcher {
@@ -29,72 +57,158 @@ func NewGitFetcher(sshKeyPath, logPath string) *GitFetcher {
 	}
 }
 
+// SetHostKeyStore enables host key verification against store
+func (gf *GitFetcher) SetHostKeyStore(store *hostkeys.Store) {
+	gf.hostKeys = store
+}
+
 // Clone executes git clone --mirror for a repository
-func (gf *GitFetcher) Clone(name, url, localPath string) *FetchResult {
+func (gf *GitFetcher) Clone(ctx context.Context, repo config.RepoConfig) *FetchResult {
 	result := &FetchResult{
-		RepoName:  name,
+		RepoName:  repo.Name,
 		Timestamp: time.Now(),
 	}
 
-	log.Printf("Cloning %s from %s to %s...", name, url, localPath)
+	log.Printf("Cloning %s from %s to %s...", repo.Name, redactURL(repo.URL), repo.LocalPath)
 
-	// Prepare git clone --mirror command
-	cmd := exec.Command("git", "clone", "--mirror", url, localPath)
+	if err := verifyHostKey(ctx, gf.hostKeys, repo); err != nil {
+		result.Success = false
+		result.Status = hostKeyStatus(err)
+		result.Message = fmt.Sprintf("clone failed: %v", err)
+		gf.logResult(result)
+		return result
+	}
 
-	// Set SSH key if provided
-	if gf.sshKeyPath != "" {
-		sshCmd := fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=no", gf.sshKeyPath)
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=%s", sshCmd))
+	// Credentials are passed through the environment, never as arguments
+	env, secrets, err := gf.gitEnv(repo)
+	if err != nil {
+		result.Success = false
+		result.Status = StatusFailed
+		result.Message = fmt.Sprintf("clone failed: %v", err)
+		gf.logResult(result)
+		return result
 	}
 
+	_, statErr := os.Stat(repo.LocalPath)
+
+	// Prepare git clone --mirror command
+	args := append([]string{"clone", "--mirror"}, progressFlag(ctx)...)
+	cmd := gitCommand(ctx, append(args, repo.URL, repo.LocalPath)...)
+	cmd.Env = env
+
 	// Execute command
-	output, err := cmd.CombinedOutput()
+	output, err := combinedOutput(ctx, cmd, secrets)
 	if err != nil {
+		// A killed clone cannot clean up after itself, the next run would try to fetch into it
+		if os.IsNotExist(statErr) {
+			os.RemoveAll(repo.LocalPath)
+		}
 		result.Success = false
-		result.Message = fmt.Sprintf("clone failed: %v\nOutput: %s", err, string(output))
+		result.Status = failStatus(ctx)
+		result.Message = redact(fmt.Sprintf("clone failed: %v\nOutput: %s", ctxCause(ctx, err), string(output)), secrets...)
 		gf.logResult(result)
 		return result
 	}
 
+	// A fresh mirror reports every ref as created
+	if after, err := listRefs(ctx, repo.LocalPath); err == nil {
+		result.Changes = diffRefs(nil, after, nil)
+	}
+
 	result.Success = true
+	result.Status = StatusSuccess
 	result.Message = fmt.Sprintf("Successfully cloned as mirror repository")
 	gf.logResult(result)
 	return result
 }
 
 // Fetch executes git fetch for a repository, clones if not exists
-func (gf *GitFetcher) Fetch(name, url, localPath string) *FetchResult {
+func (gf *GitFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *FetchResult {
 	result := &FetchResult{
-		RepoName:  name,
+		RepoName:  repo.Name,
 		Timestamp: time.Now(),
 	}
 
 	// Check if repository exists, clone if not
-	if _, err := os.Stat(localPath); os.IsNotExist(err) {
-		log.Printf("Repository %s does not exist, cloning...", name)
-		return gf.Clone(name, url, localPath)
+	if _, err := os.Stat(repo.LocalPath); os.IsNotExist(err) {
+		log.Printf("Repository %s does not exist, cloning...", repo.Name)
+		return gf.Clone(ctx, repo)
 	}
 
-	// Prepare git command
-	cmd := exec.Command("git", "-C", localPath, "fetch", "--all", "--prune")
+	if err := verifyHostKey(ctx, gf.hostKeys, repo); err != nil {
+		result.Success = false
+		result.Status = hostKeyStatus(err)
+		result.Message = fmt.Sprintf("fetch failed: %v", err)
+		gf.logResult(result)
+		return result
+	}
 
-	// Set SSH key if provided
-	if gf.sshKeyPath != "" {
-		sshCmd := fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=no", gf.sshKeyPath)
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=%s", sshCmd))
+	env, secrets, err := gf.gitEnv(repo)
+	if err != nil {
+		result.Success = false
+		result.Status = StatusFailed
+		result.Message = fmt.Sprintf("fetch failed: %v", err)
+		gf.logResult(result)
+		return result
 	}
 
+	before, err := listRefs(ctx, repo.LocalPath)
+	if err != nil {
+		result.Success = false
+		result.Status = failStatus(ctx)
+		result.Message = fmt.Sprintf("fetch failed: %v", ctxCause(ctx, err))
+		gf.logResult(result)
+		return result
+	}
+
+	exclude, err := excludeReserved(ctx, repo.LocalPath)
+	if err != nil {
+		result.Success = false
+		result.Status = failStatus(ctx)
+		result.Message = fmt.Sprintf("fetch failed: %v", ctxCause(ctx, err))
+		gf.logResult(result)
+		return result
+	}
+
+	// Every ref is backed up ahead of the fetch, the backups of the refs it
+	// does not rewrite or prune are dropped again once it is done
+	var staged []Backup
+	if repo.RefBackup != nil {
+		staged, err = stageBackups(ctx, repo.LocalPath, before, time.Now())
+		if err != nil {
+			result.Success = false
+			result.Status = failStatus(ctx)
+			result.Message = fmt.Sprintf("fetch failed: %v", ctxCause(ctx, err))
+			gf.logResult(result)
+			return result
+		}
+	}
+
+	// Prepare git command
+	args := append(exclude, "-C", repo.LocalPath, "fetch", "--all", "--prune")
+	cmd := gitCommand(ctx, append(args, progressFlag(ctx)...)...)
+	cmd.Env = env
+
 	// Execute command
-	output, err := cmd.CombinedOutput()
+	output, err := combinedOutput(ctx, cmd, secrets)
+	if repo.RefBackup != nil {
+		result.Backups = gf.settleBackups(ctx, repo, before, staged)
+	}
 	if err != nil {
 		result.Success = false
-		result.Message = fmt.Sprintf("fetch failed: %v\nOutput: %s", err, string(output))
+		result.Status = failStatus(ctx)
+		result.Message = redact(fmt.Sprintf("fetch failed: %v\nOutput: %s", ctxCause(ctx, err), string(output)), secrets...)
 		gf.logResult(result)
 		return result
 	}
 
+	if after, err := listRefs(ctx, repo.LocalPath); err == nil {
+		result.Changes = diffRefs(before, after, cliIsAncestor(ctx, repo.LocalPath))
+	}
+
 	result.Success = true
-	result.Message = strings.TrimSpace(string(output))
+	result.Status = StatusSuccess
+	result.Message = redact(strings.TrimSpace(string(output)), secrets...)
 	if result.Message == "" {
 		result.Message = "Already up to date"
 	}
@@ -104,18 +218,23 @@ func (gf *GitFetcher) Fetch(name, url, localPath string) *FetchResult {
 
 // logResult writes fetch result to log file
 func (gf *GitFetcher) logResult(result *FetchResult) {
-	if gf.logPath == "" {
+	writeLog(gf.logPath, result)
+}
+
+// writeLog appends a fetch result to the daily log file under logPath
+func writeLog(logPath string, result *FetchResult) {
+	if logPath == "" {
 		return
 	}
 
 	// Ensure log directory exists
-	if err := os.MkdirAll(gf.logPath, 0755); err != nil {
+	if err := os.MkdirAll(logPath, 0755); err != nil {
 		log.Printf("Failed to create log directory: %v", err)
 		return
 	}
 
 	// Create/append to daily log file
-	logFile := filepath.Join(gf.logPath, fmt.Sprintf("fetch-%s.log", time.Now().Format("2006-01-02")))
+	logFile := filepath.Join(logPath, fmt.Sprintf("fetch-%s.log", time.Now().Format("2006-01-02")))
 	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Printf("Failed to open log file: %v", err)
@@ -132,7 +251,7 @@ func (gf *GitFetcher) logResult(result *FetchResult) {
 		result.Timestamp.Format("2006-01-02 15:04:05"),
 		status,
 		result.RepoName,
-		result.Message,
+		redactURL(result.Message),
 	)
 
 	if _, err := f.WriteString(logEntry); err != nil {
@@ -1,10 +1,13 @@
 package fetcher
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
+
+	"colosscious.com/gitfetcher/config"
 )
 
 // setupTestRepo creates a test git bare repository
@@ -101,7 +104,7 @@ func TestFetchSuccess(t *testing.T) {
 	tmpDir := t.TempDir()
 	gf := NewGitFetcher("", tmpDir)
 
-	result := gf.Fetch("test-repo", bareRepo, bareRepo)
+	result := gf.Fetch(context.Background(), config.RepoConfig{Name: "test-repo", URL: bareRepo, LocalPath: bareRepo})
 
 	if result == nil {
 		t.Fatal("Fetch returned nil result")
@@ -125,7 +128,7 @@ func TestFetchNonexistentRepo(t *testing.T) {
 	gf := NewGitFetcher("", tmpDir)
 
 	// Invalid URL will cause clone to fail
-	result := gf.Fetch("nonexistent", "https://invalid-url-that-does-not-exist.example.com/repo.git", "/nonexistent/repo")
+	result := gf.Fetch(context.Background(), config.RepoConfig{Name: "nonexistent", URL: "https://invalid-url-that-does-not-exist.example.com/repo.git", LocalPath: "/nonexistent/repo"})
 
 	if result == nil {
 		t.Fatal("Fetch returned nil result")
@@ -161,7 +164,7 @@ func TestFetchInvalidRepo(t *testing.T) {
 	logDir := filepath.Join(tmpDir, "logs")
 	gf := NewGitFetcher("", logDir)
 
-	result := gf.Fetch("invalid-repo", "https://fake.url", notARepo)
+	result := gf.Fetch(context.Background(), config.RepoConfig{Name: "invalid-repo", URL: "https://fake.url", LocalPath: notARepo})
 
 	if result == nil {
 		t.Fatal("Fetch returned nil result")
@@ -193,7 +196,7 @@ func TestFetchWithSSHKey(t *testing.T) {
 	gf := NewGitFetcher(sshKeyPath, logDir)
 
 	// Note: This will still work because we're using local path, not SSH
-	result := gf.Fetch("test-repo", bareRepo, bareRepo)
+	result := gf.Fetch(context.Background(), config.RepoConfig{Name: "test-repo", URL: bareRepo, LocalPath: bareRepo})
 
 	if result == nil {
 		t.Fatal("Fetch returned nil result")
@@ -222,7 +225,7 @@ func TestLogResult(t *testing.T) {
 	bareRepo, cleanup := setupTestRepo(t)
 	defer cleanup()
 
-	gf.Fetch("test-repo", bareRepo, bareRepo)
+	gf.Fetch(context.Background(), config.RepoConfig{Name: "test-repo", URL: bareRepo, LocalPath: bareRepo})
 
 	// Check if log directory was created
 	if _, err := os.Stat(logDir); os.IsNotExist(err) {
@@ -287,7 +290,7 @@ func TestCloneMirror(t *testing.T) {
 
 	// Clone to a new location
 	targetRepo := filepath.Join(tmpDir, "cloned.git")
-	result := gf.Clone("test-repo", sourceRepo, targetRepo)
+	result := gf.Clone(context.Background(), config.RepoConfig{Name: "test-repo", URL: sourceRepo, LocalPath: targetRepo})
 
 	if result == nil {
 		t.Fatal("Clone returned nil result")
@@ -334,7 +337,7 @@ func TestFetchAutoClone(t *testing.T) {
 
 	// Fetch on non-existent repo should auto-clone
 	targetRepo := filepath.Join(tmpDir, "auto-cloned.git")
-	result := gf.Fetch("test-repo", sourceRepo, targetRepo)
+	result := gf.Fetch(context.Background(), config.RepoConfig{Name: "test-repo", URL: sourceRepo, LocalPath: targetRepo})
 
 	if result == nil {
 		t.Fatal("Fetch returned nil result")
@@ -350,7 +353,7 @@ func TestFetchAutoClone(t *testing.T) {
 	}
 
 	// Verify subsequent fetch works on the cloned repo
-	result2 := gf.Fetch("test-repo", sourceRepo, targetRepo)
+	result2 := gf.Fetch(context.Background(), config.RepoConfig{Name: "test-repo", URL: sourceRepo, LocalPath: targetRepo})
 	if result2 == nil {
 		t.Fatal("Second fetch returned nil result")
 	}
@@ -0,0 +1,326 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/hostkeys"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// mirrorRefSpec mirrors every ref of the remote, the same as `git clone --mirror`
+const mirrorRefSpec = "+refs/*:refs/*"
+
+// GoGitFetcher is the pure-Go backend, it works without a git binary
+type GoGitFetcher struct {
+	sshKeyPath string
+	logPath    string
+	hostKeys   *hostkeys.Store
+}
+
+func NewGoGitFetcher(sshKeyPath, logPath string) *GoGitFetcher {
+	return &GoGitFetcher{
+		sshKeyPath: sshKeyPath,
+		logPath:    logPath,
+	}
+}
+
+// SetHostKeyStore enables host key verification against store
+func (gf *GoGitFetcher) SetHostKeyStore(store *hostkeys.Store) {
+	gf.hostKeys = store
+}
+
+// Clone creates a bare mirror repository
+func (gf *GoGitFetcher) Clone(ctx context.Context, repo config.RepoConfig) *FetchResult {
+	result := &FetchResult{
+		RepoName:  repo.Name,
+		Timestamp: time.Now(),
+	}
+
+	log.Printf("Cloning %s from %s to %s (go-git)...", repo.Name, redactURL(repo.URL), repo.LocalPath)
+
+	if err := verifyHostKey(ctx, gf.hostKeys, repo); err != nil {
+		result.Success = false
+		result.Status = hostKeyStatus(err)
+		result.Message = fmt.Sprintf("clone failed: %v", err)
+		writeLog(gf.logPath, result)
+		return result
+	}
+
+	auth, err := gf.auth(repo)
+	if err != nil {
+		result.Success = false
+		result.Status = StatusFailed
+		result.Message = redact(fmt.Sprintf("clone failed: %v", err), goGitSecrets(repo)...)
+		writeLog(gf.logPath, result)
+		return result
+	}
+
+	_, statErr := os.Stat(repo.LocalPath)
+	r, err := git.PlainCloneContext(ctx, repo.LocalPath, true, &git.CloneOptions{
+		URL:      repo.URL,
+		Auth:     auth,
+		Mirror:   true,
+		Progress: progressOutput(ctx),
+	})
+	if err != nil {
+		// Do not leave a half-written mirror behind, the next run would try to fetch into it
+		if os.IsNotExist(statErr) {
+			os.RemoveAll(repo.LocalPath)
+		}
+		result.Success = false
+		result.Status = failStatus(ctx)
+		result.Message = redact(fmt.Sprintf("clone failed: %v", ctxCause(ctx, err)), goGitSecrets(repo)...)
+		writeLog(gf.logPath, result)
+		return result
+	}
+
+	// A fresh mirror reports every ref as created
+	if after, err := refSnapshot(r); err == nil {
+		result.Changes = diffRefs(nil, after, nil)
+	}
+
+	result.Success = true
+	result.Status = StatusSuccess
+	result.Message = "Successfully cloned as mirror repository"
+	writeLog(gf.logPath, result)
+	return result
+}
+
+// Fetch updates all remotes of a mirror repository, clones if not exists
+func (gf *GoGitFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *FetchResult {
+	result := &FetchResult{
+		RepoName:  repo.Name,
+		Timestamp: time.Now(),
+	}
+
+	// Check if repository exists, clone if not
+	if _, err := os.Stat(repo.LocalPath); os.IsNotExist(err) {
+		log.Printf("Repository %s does not exist, cloning...", repo.Name)
+		return gf.Clone(ctx, repo)
+	}
+
+	if err := verifyHostKey(ctx, gf.hostKeys, repo); err != nil {
+		result.Success = false
+		result.Status = hostKeyStatus(err)
+		result.Message = fmt.Sprintf("fetch failed: %v", err)
+		writeLog(gf.logPath, result)
+		return result
+	}
+
+	changes, err := gf.fetchAll(ctx, repo)
+	if err != nil {
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			result.Success = true
+			result.Status = StatusSuccess
+			result.Message = "Already up to date"
+			writeLog(gf.logPath, result)
+			return result
+		}
+		result.Success = false
+		result.Status = failStatus(ctx)
+		result.Message = redact(fmt.Sprintf("fetch failed: %v", ctxCause(ctx, err)), goGitSecrets(repo)...)
+		writeLog(gf.logPath, result)
+		return result
+	}
+
+	result.Success = true
+	result.Status = StatusSuccess
+	result.Changes = changes
+	result.Message = "Fetched new objects: " + SummarizeChanges(changes)
+	writeLog(gf.logPath, result)
+	return result
+}
+
+// fetchAll is the go-git equivalent of `git fetch --all --prune` and returns
+// the refs it changed. It returns git.NoErrAlreadyUpToDate when no ref was
+// created, moved or pruned.
+func (gf *GoGitFetcher) fetchAll(ctx context.Context, repo config.RepoConfig) ([]RefChange, error) {
+	r, err := git.PlainOpen(repo.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	remotes, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+	if len(remotes) == 0 {
+		return nil, fmt.Errorf("no remotes configured in %s", repo.LocalPath)
+	}
+
+	auth, err := gf.auth(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// go-git reports an update whenever Force is set, so compare refs ourselves
+	before, err := refSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, remote := range remotes {
+		refSpecs := remote.Config().Fetch
+		if len(refSpecs) == 0 {
+			refSpecs = []gitconfig.RefSpec{mirrorRefSpec}
+		}
+
+		err := remote.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: refSpecs,
+			Auth:     auth,
+			Force:    true,
+			Prune:    true,
+			Progress: progressOutput(ctx),
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return nil, fmt.Errorf("remote %s: %w", remote.Config().Name, err)
+		}
+	}
+
+	after, err := refSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffRefs(before, after, goGitIsAncestor(r))
+	if len(changes) == 0 {
+		return nil, git.NoErrAlreadyUpToDate
+	}
+	return changes, nil
+}
+
+// refSnapshot maps every hash reference in repo to the object it points at
+func refSnapshot(repo *git.Repository) (map[string]string, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	refs := make(map[string]string)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// OriginURL returns the origin remote of the git repository at path, for
+// example a mirror cloned before gitfetcher managed it. It is empty when path
+// is not a repository or has no origin, credentials in the URL are removed.
+func OriginURL(path string) string {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return ""
+	}
+	remote, err := r.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return urlUserinfo.ReplaceAllString(remote.Config().URLs[0], "$1@")
+}
+
+// goGitIsAncestor checks ancestry with go-git, anything that is not a commit counts as forced
+func goGitIsAncestor(r *git.Repository) func(oldSHA, newSHA string) bool {
+	return func(oldSHA, newSHA string) bool {
+		oldCommit, err := r.CommitObject(plumbing.NewHash(oldSHA))
+		if err != nil {
+			return false
+		}
+		newCommit, err := r.CommitObject(plumbing.NewHash(newSHA))
+		if err != nil {
+			return false
+		}
+		ok, err := oldCommit.IsAncestor(newCommit)
+		return err == nil && ok
+	}
+}
+
+// goGitSecrets returns the credentials of repo that go-git may echo in its
+// errors, like the secrets gitEnv returns for the CLI backend
+func goGitSecrets(repo config.RepoConfig) []string {
+	if repo.Auth == nil || !repo.Auth.IsHTTP() {
+		return nil
+	}
+	if _, secret, err := repo.Auth.Credentials(); err == nil {
+		return []string{secret}
+	}
+	return nil
+}
+
+// auth builds transport credentials for repo, nil means use the transport default
+func (gf *GoGitFetcher) auth(repo config.RepoConfig) (transport.AuthMethod, error) {
+	auth := repo.Auth
+	if auth == nil {
+		auth = &config.AuthConfig{Type: config.AuthSSH}
+	}
+
+	if auth.IsHTTP() {
+		username, secret, err := auth.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		return &githttp.BasicAuth{Username: username, Password: secret}, nil
+	}
+
+	keyPath := sshKeyPath(repo, gf.sshKeyPath)
+	if auth.Type != config.AuthSSH || (keyPath == "" && gf.hostKeys == nil) {
+		return nil, nil
+	}
+
+	ep, err := transport.NewEndpoint(repo.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if ep.Protocol != "ssh" {
+		return nil, nil
+	}
+
+	user := ep.User
+	if user == "" {
+		user = "git"
+	}
+
+	// go-git's default would check ~/.ssh/known_hosts instead of the store
+	if keyPath == "" {
+		return gf.agentAuth(user), nil
+	}
+
+	keys, err := gitssh.NewPublicKeysFromFile(user, keyPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ssh key: %w", err)
+	}
+	// Without a store go-git falls back to ~/.ssh/known_hosts
+	if gf.hostKeys != nil {
+		keys.HostKeyCallback = gf.hostKeys.Check
+	}
+
+	return keys, nil
+}
+
+// agentAuth offers the keys of ssh-agent, or none when no agent runs, like
+// go-git's default but with host keys checked against the store
+func (gf *GoGitFetcher) agentAuth(user string) transport.AuthMethod {
+	agent, err := gitssh.NewSSHAgentAuth(user)
+	if err != nil {
+		agent = &gitssh.PublicKeysCallback{
+			User:     user,
+			Callback: func() ([]ssh.Signer, error) { return nil, nil },
+		}
+	}
+	agent.HostKeyCallback = gf.hostKeys.Check
+	return agent
+}
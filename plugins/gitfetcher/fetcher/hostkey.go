@@ -0,0 +1,39 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/hostkeys"
+)
+
+// hostKeyScanTimeout bounds the ssh handshake used to read a host key
+const hostKeyScanTimeout = 15 * time.Second
+
+// usesSSH reports whether repo authenticates with ssh, the default when no auth block is set
+func usesSSH(repo config.RepoConfig) bool {
+	return repo.Auth == nil || repo.Auth.Type == config.AuthSSH
+}
+
+// verifyHostKey checks the ssh host of repo against store before git connects.
+// A nil store disables the check.
+func verifyHostKey(ctx context.Context, store *hostkeys.Store, repo config.RepoConfig) error {
+	if store == nil || !usesSSH(repo) {
+		return nil
+	}
+	return store.Verify(ctx, repo.URL, hostKeyScanTimeout)
+}
+
+// hostKeyStatus maps a host key verification error to a FetchResult status
+func hostKeyStatus(err error) string {
+	var changed *hostkeys.ChangedError
+	switch {
+	case errors.As(err, &changed):
+		return StatusHostKeyChanged
+	case errors.Is(err, hostkeys.ErrUnknownHost):
+		return StatusHostKeyUnknown
+	}
+	return StatusFailed
+}
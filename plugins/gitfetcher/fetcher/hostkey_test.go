@@ -0,0 +1,140 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/hostkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSSHHost starts a listener that completes ssh handshakes with a random host key
+func newSSHHost(t *testing.T) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, cfg)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestSSHCommand(t *testing.T) {
+	gf := NewGitFetcher("", "")
+	if cmd := gf.sshCommand(config.RepoConfig{}); cmd != "" {
+		t.Errorf("Expected ssh defaults without key or store, got %q", cmd)
+	}
+
+	store, err := hostkeys.NewStore(filepath.Join(t.TempDir(), "known_hosts"), true, nil)
+	if err != nil {
+		t.Fatalf("NewStore() failed: %v", err)
+	}
+
+	gf = NewGitFetcher("/keys/id_ed25519", "")
+	gf.SetHostKeyStore(store)
+	cmd := gf.sshCommand(config.RepoConfig{})
+
+	if strings.Contains(cmd, "StrictHostKeyChecking=no") {
+		t.Error("Host key checking must not be disabled")
+	}
+	for _, want := range []string{"'-i' '/keys/id_ed25519'", "IdentitiesOnly=yes", "StrictHostKeyChecking=yes", `UserKnownHostsFile="` + store.Path() + `"`} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("Expected %q in ssh command %q", want, cmd)
+		}
+	}
+
+	// Paths with spaces or shell characters reach ssh as a single argument
+	keyPath := "/my keys/it's $HOME;id"
+	gf = NewGitFetcher(keyPath, "")
+	cmd = gf.sshCommand(config.RepoConfig{})
+	output, err := exec.Command("sh", "-c", "printf '%s\\n' "+strings.TrimPrefix(cmd, "ssh ")).Output()
+	if err != nil {
+		t.Fatalf("Failed to run %q: %v", cmd, err)
+	}
+	if args := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n"); len(args) != 4 || args[1] != keyPath {
+		t.Errorf("Expected the key path as one argument, got %q", args)
+	}
+}
+
+func TestFetchHostKeyStatus(t *testing.T) {
+	addr := newSSHHost(t)
+	host, port, _ := net.SplitHostPort(addr)
+	pinnedHost := "[" + host + "]:" + port
+
+	tests := []struct {
+		name   string
+		tofu   bool
+		pins   map[string][]string
+		status string
+	}{
+		{"pinned mismatch", true, map[string][]string{pinnedHost: {"SHA256:not-the-server-key"}}, StatusHostKeyChanged},
+		{"strict unknown host", false, nil, StatusHostKeyUnknown},
+	}
+
+	for _, tt := range tests {
+		for name, newFetcher := range map[string]func(*hostkeys.Store) Fetcher{
+			"cli": func(s *hostkeys.Store) Fetcher {
+				gf := NewGitFetcher("", "")
+				gf.SetHostKeyStore(s)
+				return gf
+			},
+			"go-git": func(s *hostkeys.Store) Fetcher {
+				gf := NewGoGitFetcher("", "")
+				gf.SetHostKeyStore(s)
+				return gf
+			},
+		} {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				tmpDir := t.TempDir()
+				store, err := hostkeys.NewStore(filepath.Join(tmpDir, "known_hosts"), tt.tofu, tt.pins)
+				if err != nil {
+					t.Fatalf("NewStore() failed: %v", err)
+				}
+
+				result := newFetcher(store).Fetch(context.Background(), config.RepoConfig{
+					Name:      "test-repo",
+					URL:       "ssh://git@" + addr + "/repo.git",
+					LocalPath: filepath.Join(tmpDir, "mirror.git"),
+				})
+				if result.Success {
+					t.Fatal("Expected fetch to fail")
+				}
+				if result.Status != tt.status {
+					t.Errorf("Expected status %s, got %s (%s)", tt.status, result.Status, result.Message)
+				}
+			})
+		}
+	}
+}
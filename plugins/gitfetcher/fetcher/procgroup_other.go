@@ -0,0 +1,8 @@
+//go:build !unix
+
+package fetcher
+
+import "os/exec"
+
+// setProcessGroup is a no-op without process groups, cancellation only kills git itself
+func setProcessGroup(cmd *exec.Cmd) {}
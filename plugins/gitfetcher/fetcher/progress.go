@@ -0,0 +1,112 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+type progressKey struct{}
+
+// WithProgress returns a context whose clones and fetches report git progress
+// output to fn, one line at a time, from the goroutine running the fetch
+func WithProgress(ctx context.Context, fn func(line string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressFunc returns the progress func of ctx, nil if none was set
+func progressFunc(ctx context.Context) func(string) {
+	fn, _ := ctx.Value(progressKey{}).(func(string))
+	return fn
+}
+
+// progressFlag asks git for progress output when ctx wants it, git only
+// reports progress to a terminal by default
+func progressFlag(ctx context.Context) []string {
+	if progressFunc(ctx) == nil {
+		return nil
+	}
+	return []string{"--progress"}
+}
+
+// progressLine matches the counters git prints while transferring objects
+var progressLine = regexp.MustCompile(`^(remote: )?(Enumerating|Counting|Compressing|Receiving|Resolving|Unpacking|Total|Checking|Finding|Updating files)\b`)
+
+// progressWriter splits git output on \r and \n and reports every line to fn.
+// Lines that were redrawn with \r or look like transfer counters are kept
+// out of out, so the result message reads the same with or without progress.
+type progressWriter struct {
+	fn      func(string)
+	out     *bytes.Buffer // nil to discard
+	line    []byte
+	redrawn bool
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch b {
+		case '\r':
+			w.report()
+			w.redrawn = true
+		case '\n':
+			if w.out != nil && !w.redrawn && !progressLine.Match(w.line) {
+				w.out.Write(w.line)
+				w.out.WriteByte('\n')
+			}
+			w.report()
+			w.redrawn = false
+		default:
+			w.line = append(w.line, b)
+		}
+	}
+	return len(p), nil
+}
+
+// report hands the current line to fn and starts a new one
+func (w *progressWriter) report() {
+	if line := strings.TrimSpace(string(w.line)); line != "" {
+		w.fn(line)
+	}
+	w.line = w.line[:0]
+}
+
+// flush reports and keeps an unterminated last line
+func (w *progressWriter) flush() {
+	if len(w.line) > 0 {
+		w.Write([]byte{'\n'})
+	}
+}
+
+// progressOutput returns the sideband progress writer for go-git, nil
+// (no progress requested from the remote) unless ctx carries a progress func
+func progressOutput(ctx context.Context) io.Writer {
+	fn := progressFunc(ctx)
+	if fn == nil {
+		return nil
+	}
+	return &progressWriter{fn: fn}
+}
+
+// combinedOutput runs cmd like CombinedOutput. When ctx carries a progress
+// func the output is streamed to it, redacted, as git produces it.
+func combinedOutput(ctx context.Context, cmd *exec.Cmd, secrets []string) ([]byte, error) {
+	fn := progressFunc(ctx)
+	if fn == nil {
+		return cmd.CombinedOutput()
+	}
+
+	var out bytes.Buffer
+	w := &progressWriter{
+		fn:  func(line string) { fn(redact(line, secrets...)) },
+		out: &out,
+	}
+	// The same writer for both streams, exec then writes from a single goroutine
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	w.flush()
+	return out.Bytes(), err
+}
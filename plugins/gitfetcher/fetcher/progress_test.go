@@ -0,0 +1,82 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var lines []string
+	var out bytes.Buffer
+	w := &progressWriter{fn: func(line string) { lines = append(lines, line) }, out: &out}
+
+	// Chunks split in the middle of lines, as they come from a pipe
+	for _, chunk := range []string{
+		"remote: Enumerating objects: 5, done.\n",
+		"Receiving objects:  50% (1/2)\rReceiving obj",
+		"ects: 100% (2/2), done.\n",
+		"From github.com:user/repo\n",
+		" * [new branch]      main -> main",
+	} {
+		w.Write([]byte(chunk))
+	}
+	w.flush()
+
+	want := []string{
+		"remote: Enumerating objects: 5, done.",
+		"Receiving objects:  50% (1/2)",
+		"Receiving objects: 100% (2/2), done.",
+		"From github.com:user/repo",
+		"* [new branch]      main -> main",
+	}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected lines %q, got %q", want, lines)
+	}
+	if got := out.String(); got != "From github.com:user/repo\n * [new branch]      main -> main\n" {
+		t.Errorf("Expected progress to be kept out of the output, got %q", got)
+	}
+}
+
+func TestFetchWithProgress(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sourceRepo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var lines []string
+	ctx := WithProgress(context.Background(), func(line string) {
+		mu.Lock()
+		lines = append(lines, line)
+		mu.Unlock()
+	})
+
+	// file:// makes git transfer objects instead of hardlinking them
+	repo := config.RepoConfig{Name: "test-repo", URL: "file://" + sourceRepo, LocalPath: filepath.Join(t.TempDir(), "mirror.git")}
+	gf := NewGitFetcher("", "")
+	if result := gf.Clone(ctx, repo); !result.Success {
+		t.Fatalf("Clone failed: %s", result.Message)
+	}
+	mu.Lock()
+	if len(lines) == 0 {
+		t.Error("Expected clone progress to be reported")
+	}
+	mu.Unlock()
+
+	result := gf.Fetch(ctx, repo)
+	if !result.Success {
+		t.Fatalf("Fetch failed: %s", result.Message)
+	}
+	if result.Message != "Already up to date" {
+		t.Errorf("Expected progress to stay out of the message, got %q", result.Message)
+	}
+}
@@ -0,0 +1,100 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// PushResult is the outcome of pushing a mirror to one of its push mirrors
+type PushResult struct {
+	Mirror    string
+	Success   bool
+	Status    string
+	Message   string
+	SHA       string // commit HEAD of the mirror pointed to when it was pushed
+	Timestamp time.Time
+}
+
+// Pusher is implemented by backends that can replicate a mirror to its push mirrors
+type Pusher interface {
+	// Push sends every ref of the mirror at repo.LocalPath to mirror
+	Push(ctx context.Context, repo config.RepoConfig, mirror config.PushMirror) *PushResult
+}
+
+// Push force-pushes every ref of the mirror of repo to a push mirror, refs
+// deleted upstream are deleted on the push mirror too. It is not git push
+// --mirror, which takes no refspecs: the refs of ReservedNamespace, such as
+// backups, stay local and are never pruned on the push mirror.
+func (gf *GitFetcher) Push(ctx context.Context, repo config.RepoConfig, mirror config.PushMirror) *PushResult {
+	result := &PushResult{
+		Mirror:    mirror.Name,
+		Timestamp: time.Now(),
+	}
+	target := pushTarget(repo, mirror)
+
+	if err := verifyHostKey(ctx, gf.hostKeys, target); err != nil {
+		result.Status = hostKeyStatus(err)
+		result.Message = fmt.Sprintf("push failed: %v", err)
+		gf.logPush(repo, result)
+		return result
+	}
+
+	env, secrets, err := gf.gitEnv(target)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Message = fmt.Sprintf("push failed: %v", err)
+		gf.logPush(repo, result)
+		return result
+	}
+
+	// An empty mirror has no HEAD commit yet, its refs are pushed all the same
+	if sha, err := gitCommand(ctx, "-C", repo.LocalPath, "rev-parse", "--verify", "--quiet", "HEAD^{commit}").Output(); err == nil {
+		result.SHA = strings.TrimSpace(string(sha))
+	}
+
+	args := append([]string{"-C", repo.LocalPath, "push", "--prune"}, progressFlag(ctx)...)
+	cmd := gitCommand(ctx, append(args, mirror.URL, "+refs/*:refs/*", "^"+ReservedNamespace+"*")...)
+	cmd.Env = env
+
+	output, err := combinedOutput(ctx, cmd, secrets)
+	if err != nil {
+		result.Status = failStatus(ctx)
+		result.Message = redact(fmt.Sprintf("push failed: %v\nOutput: %s", ctxCause(ctx, err), string(output)), secrets...)
+		gf.logPush(repo, result)
+		return result
+	}
+
+	result.Success = true
+	result.Status = StatusSuccess
+	result.Message = redact(strings.TrimSpace(string(output)), secrets...)
+	if result.Message == "" {
+		result.Message = "Everything up-to-date"
+	}
+	gf.logPush(repo, result)
+	return result
+}
+
+// pushTarget returns repo with the URL and credentials of mirror, as seen by
+// gitEnv and the host key check
+func pushTarget(repo config.RepoConfig, mirror config.PushMirror) config.RepoConfig {
+	repo.URL = mirror.URL
+	repo.Auth = mirror.Auth
+	if mirror.SSHKeyPath != "" {
+		repo.SSHKeyPath = mirror.SSHKeyPath
+	}
+	return repo
+}
+
+// logPush writes a push result to the fetch log
+func (gf *GitFetcher) logPush(repo config.RepoConfig, result *PushResult) {
+	writeLog(gf.logPath, &FetchResult{
+		RepoName:  repo.Name,
+		Success:   result.Success,
+		Message:   fmt.Sprintf("push to %s: %s", result.Mirror, result.Message),
+		Timestamp: result.Timestamp,
+	})
+}
@@ -0,0 +1,66 @@
+package fetcher
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+func TestPushMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sourceRepo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	workRepo := filepath.Join(filepath.Dir(sourceRepo), "work")
+	gitRun(t, workRepo, "branch", "doomed")
+	gitRun(t, workRepo, "tag", "v1.0")
+	gitRun(t, workRepo, "push", "origin", "doomed", "v1.0")
+
+	tmp := t.TempDir()
+	backup := filepath.Join(tmp, "backup.git")
+	if err := exec.Command("git", "init", "--bare", backup).Run(); err != nil {
+		t.Fatalf("Failed to create push mirror: %v", err)
+	}
+	repo := config.RepoConfig{
+		Name:        "test-repo",
+		URL:         sourceRepo,
+		LocalPath:   filepath.Join(tmp, "mirror.git"),
+		PushMirrors: []config.PushMirror{{Name: "nas", URL: backup}},
+	}
+
+	gf := NewGitFetcher("", "")
+	if result := gf.Fetch(context.Background(), repo); !result.Success {
+		t.Fatalf("Fetch failed: %s", result.Message)
+	}
+	result := gf.Push(context.Background(), repo, repo.PushMirrors[0])
+	if !result.Success || result.Mirror != "nas" || len(result.SHA) != 40 {
+		t.Fatalf("Expected a successful push with the HEAD commit, got %+v", result)
+	}
+	mirrorRefs, _ := listRefs(context.Background(), repo.LocalPath)
+	backupRefs, _ := listRefs(context.Background(), backup)
+	if !reflect.DeepEqual(mirrorRefs, backupRefs) {
+		t.Errorf("Expected the push mirror to have the refs of the mirror, got %v, want %v", backupRefs, mirrorRefs)
+	}
+
+	// Refs pruned from the mirror are deleted on the push mirror
+	gitRun(t, workRepo, "push", "origin", "--delete", "doomed")
+	gf.Fetch(context.Background(), repo)
+	if result := gf.Push(context.Background(), repo, repo.PushMirrors[0]); !result.Success {
+		t.Fatalf("Second push failed: %s", result.Message)
+	}
+	backupRefs, _ = listRefs(context.Background(), backup)
+	if _, ok := backupRefs["refs/heads/doomed"]; ok {
+		t.Error("Expected doomed to be deleted from the push mirror")
+	}
+
+	result = gf.Push(context.Background(), repo, config.PushMirror{Name: "gone", URL: filepath.Join(tmp, "missing.git")})
+	if result.Success || result.Status != StatusFailed || result.Message == "" {
+		t.Errorf("Expected a failed push to a missing remote, got %+v", result)
+	}
+}
@@ -0,0 +1,130 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Ref change types
+const (
+	RefCreated = "created"
+	RefUpdated = "updated"
+	RefDeleted = "deleted"
+)
+
+// RefChange describes how a single ref moved during a fetch
+type RefChange struct {
+	Ref    string
+	Type   string
+	OldSHA string
+	NewSHA string
+	Forced bool // updated to a commit that does not contain the old one
+}
+
+// diffRefs compares two ref snapshots. isAncestor reports whether oldSHA is
+// reachable from newSHA, updates that fail this check are marked as forced.
+// The refs of ReservedNamespace are gitfetcher's own and never compared.
+func diffRefs(before, after map[string]string, isAncestor func(oldSHA, newSHA string) bool) []RefChange {
+	var changes []RefChange
+
+	for ref, newSHA := range after {
+		oldSHA, existed := before[ref]
+		switch {
+		case strings.HasPrefix(ref, ReservedNamespace):
+		case !existed:
+			changes = append(changes, RefChange{Ref: ref, Type: RefCreated, NewSHA: newSHA})
+		case oldSHA != newSHA:
+			changes = append(changes, RefChange{
+				Ref:    ref,
+				Type:   RefUpdated,
+				OldSHA: oldSHA,
+				NewSHA: newSHA,
+				Forced: !isAncestor(oldSHA, newSHA),
+			})
+		}
+	}
+	for ref, oldSHA := range before {
+		if _, exists := after[ref]; !exists && !strings.HasPrefix(ref, ReservedNamespace) {
+			changes = append(changes, RefChange{Ref: ref, Type: RefDeleted, OldSHA: oldSHA})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Ref < changes[j].Ref })
+	return changes
+}
+
+// refKinds groups refs for SummarizeChanges, the empty prefix catches everything else
+var refKinds = []struct{ prefix, singular, plural string }{
+	{"refs/heads/", "branch", "branches"},
+	{"refs/tags/", "tag", "tags"},
+	{"", "ref", "refs"},
+}
+
+// SummarizeChanges renders changes as e.g. "3 branches updated, 1 force-pushed, 1 tag created".
+// It returns an empty string when nothing changed.
+func SummarizeChanges(changes []RefChange) string {
+	counts := make([]map[string]int, len(refKinds))
+	for i := range counts {
+		counts[i] = make(map[string]int)
+	}
+
+	forced := 0
+	for _, change := range changes {
+		for i, kind := range refKinds {
+			if strings.HasPrefix(change.Ref, kind.prefix) {
+				counts[i][change.Type]++
+				break
+			}
+		}
+		if change.Forced {
+			forced++
+		}
+	}
+
+	var parts []string
+	for i, kind := range refKinds {
+		for _, changeType := range []string{RefCreated, RefUpdated, RefDeleted} {
+			n := counts[i][changeType]
+			if n == 0 {
+				continue
+			}
+			noun := kind.plural
+			if n == 1 {
+				noun = kind.singular
+			}
+			parts = append(parts, fmt.Sprintf("%d %s %s", n, noun, changeType))
+		}
+		// Force pushes are called out right after the branch updates they belong to
+		if i == 0 && forced > 0 {
+			parts = append(parts, fmt.Sprintf("%d force-pushed", forced))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// listRefs maps every ref of the repository at path to the object it points at
+func listRefs(ctx context.Context, path string) (map[string]string, error) {
+	output, err := gitCommand(ctx, "-C", path, "for-each-ref", "--format=%(objectname) %(refname)").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		sha, ref, ok := strings.Cut(line, " ")
+		if ok {
+			refs[ref] = sha
+		}
+	}
+	return refs, nil
+}
+
+// cliIsAncestor checks ancestry with git merge-base in the repository at path
+func cliIsAncestor(ctx context.Context, path string) func(oldSHA, newSHA string) bool {
+	return func(oldSHA, newSHA string) bool {
+		return gitCommand(ctx, "-C", path, "merge-base", "--is-ancestor", oldSHA, newSHA).Run() == nil
+	}
+}
@@ -0,0 +1,187 @@
+package fetcher
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// gitRun runs a git command in dir and fails the test on error
+func gitRun(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+}
+
+// commitFile writes a file in the work repo and commits it
+func commitFile(t *testing.T, workRepo, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(workRepo, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	gitRun(t, workRepo, "add", name)
+	gitRun(t, workRepo, "commit", "-m", "update "+name)
+}
+
+func TestDiffRefs(t *testing.T) {
+	before := map[string]string{
+		"refs/heads/main":    "aaa",
+		"refs/heads/rewrite": "bbb",
+		"refs/heads/old":     "ccc",
+		"refs/tags/v1":       "ddd",
+	}
+	after := map[string]string{
+		"refs/heads/main":    "eee",
+		"refs/heads/rewrite": "fff",
+		"refs/heads/new":     "ggg",
+		"refs/tags/v1":       "ddd",
+	}
+	// Only main moved forward, rewrite was force-pushed
+	isAncestor := func(oldSHA, newSHA string) bool { return oldSHA == "aaa" && newSHA == "eee" }
+
+	changes := diffRefs(before, after, isAncestor)
+	want := []RefChange{
+		{Ref: "refs/heads/main", Type: RefUpdated, OldSHA: "aaa", NewSHA: "eee"},
+		{Ref: "refs/heads/new", Type: RefCreated, NewSHA: "ggg"},
+		{Ref: "refs/heads/old", Type: RefDeleted, OldSHA: "ccc"},
+		{Ref: "refs/heads/rewrite", Type: RefUpdated, OldSHA: "bbb", NewSHA: "fff", Forced: true},
+	}
+
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %d: %+v", len(want), len(changes), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("changes[%d] = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+
+	if changes := diffRefs(before, before, isAncestor); len(changes) != 0 {
+		t.Errorf("Expected no changes for identical snapshots, got %+v", changes)
+	}
+}
+
+func TestSummarizeChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []RefChange
+		want    string
+	}{
+		{"nothing", nil, ""},
+		{
+			"branches and tags",
+			[]RefChange{
+				{Ref: "refs/heads/a", Type: RefUpdated},
+				{Ref: "refs/heads/b", Type: RefUpdated},
+				{Ref: "refs/heads/c", Type: RefUpdated, Forced: true},
+				{Ref: "refs/tags/v1", Type: RefCreated},
+			},
+			"3 branches updated, 1 force-pushed, 1 tag created",
+		},
+		{
+			"other refs",
+			[]RefChange{
+				{Ref: "refs/pull/1/head", Type: RefCreated},
+				{Ref: "refs/heads/gone", Type: RefDeleted},
+			},
+			"1 branch deleted, 1 ref created",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SummarizeChanges(tt.changes); got != tt.want {
+				t.Errorf("SummarizeChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchReportsRefChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	for name, f := range map[string]Fetcher{
+		"cli":    NewGitFetcher("", ""),
+		"go-git": NewGoGitFetcher("", ""),
+	} {
+		t.Run(name, func(t *testing.T) {
+			sourceRepo, cleanup := setupTestRepo(t)
+			defer cleanup()
+			workRepo := filepath.Join(filepath.Dir(sourceRepo), "work")
+
+			gitRun(t, workRepo, "branch", "feature")
+			gitRun(t, workRepo, "branch", "doomed")
+			gitRun(t, workRepo, "push", "origin", "feature", "doomed")
+
+			repo := config.RepoConfig{
+				Name:      "test-repo",
+				URL:       sourceRepo,
+				LocalPath: filepath.Join(t.TempDir(), "mirror.git"),
+			}
+
+			result := f.Fetch(context.Background(), repo)
+			if !result.Success {
+				t.Fatalf("Clone failed: %s", result.Message)
+			}
+			if len(result.Changes) != 3 {
+				t.Errorf("Expected clone to report 3 created refs, got %+v", result.Changes)
+			}
+
+			// Fast-forward HEAD, rewrite feature, delete doomed and add a tag
+			commitFile(t, workRepo, "next.txt", "next")
+			gitRun(t, workRepo, "push", "origin", "HEAD")
+			gitRun(t, workRepo, "checkout", "-q", "feature")
+			gitRun(t, workRepo, "commit", "--amend", "-m", "rewritten")
+			gitRun(t, workRepo, "push", "--force", "origin", "feature")
+			gitRun(t, workRepo, "push", "origin", "--delete", "doomed")
+			gitRun(t, workRepo, "tag", "v1.0")
+			gitRun(t, workRepo, "push", "origin", "v1.0")
+
+			result = f.Fetch(context.Background(), repo)
+			if !result.Success {
+				t.Fatalf("Fetch failed: %s", result.Message)
+			}
+
+			got := make(map[string]RefChange)
+			for _, change := range result.Changes {
+				got[change.Ref] = change
+			}
+			if len(got) != 4 {
+				t.Fatalf("Expected 4 changed refs, got %+v", result.Changes)
+			}
+			if change := got["refs/heads/feature"]; change.Type != RefUpdated || !change.Forced {
+				t.Errorf("Expected feature to be force-updated, got %+v", change)
+			}
+			if change := got["refs/heads/doomed"]; change.Type != RefDeleted {
+				t.Errorf("Expected doomed to be deleted, got %+v", change)
+			}
+			if change := got["refs/tags/v1.0"]; change.Type != RefCreated {
+				t.Errorf("Expected v1.0 to be created, got %+v", change)
+			}
+
+			// The default branch is master or main depending on the git version
+			fastForwards := 0
+			for ref, change := range got {
+				if ref != "refs/heads/feature" && change.Type == RefUpdated && !change.Forced && change.OldSHA != "" {
+					fastForwards++
+				}
+			}
+			if fastForwards != 1 {
+				t.Errorf("Expected one fast-forward update, got %+v", result.Changes)
+			}
+
+			result = f.Fetch(context.Background(), repo)
+			if len(result.Changes) != 0 {
+				t.Errorf("Expected no changes on a second fetch, got %+v", result.Changes)
+			}
+		})
+	}
+}
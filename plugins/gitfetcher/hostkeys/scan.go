@@ -0,0 +1,92 @@
+package hostkeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errScanned aborts the handshake once the host key has been captured
+var errScanned = errors.New("host key scanned")
+
+// SSHAddress returns host:port for ssh remotes (ssh:// or scp-like git@host:path),
+// ok is false for any other transport
+func SSHAddress(remote string) (addr string, ok bool) {
+	if strings.HasPrefix(remote, "ssh://") || strings.HasPrefix(remote, "git+ssh://") {
+		u, err := url.Parse(remote)
+		if err != nil || u.Hostname() == "" {
+			return "", false
+		}
+		port := u.Port()
+		if port == "" {
+			port = "22"
+		}
+		return net.JoinHostPort(u.Hostname(), port), true
+	}
+
+	if strings.Contains(remote, "://") {
+		return "", false
+	}
+
+	// scp-like syntax: [user@]host:path
+	colon := strings.Index(remote, ":")
+	if colon <= 0 || strings.ContainsAny(remote[:colon], "/\\") {
+		return "", false
+	}
+	host := remote[:colon]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return net.JoinHostPort(host, "22"), true
+}
+
+// Scan connects to addr and returns the host key it presents, without authenticating.
+// The handshake is abandoned when ctx is done or timeout elapses.
+func Scan(ctx context.Context, addr string, timeout time.Duration) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	cfg := &ssh.ClientConfig{
+		User: "git",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errScanned
+		},
+		Timeout: timeout,
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	_, _, _, err = ssh.NewClientConn(conn, addr, cfg)
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	return nil, fmt.Errorf("failed to read host key from %s: %w", addr, err)
+}
+
+// Verify scans the host of an ssh remote and checks its key against the store.
+// Non-ssh remotes are accepted as is.
+func (s *Store) Verify(ctx context.Context, remote string, timeout time.Duration) error {
+	addr, ok := SSHAddress(remote)
+	if !ok {
+		return nil
+	}
+
+	key, err := Scan(ctx, addr, timeout)
+	if err != nil {
+		return err
+	}
+	return s.Check(addr, nil, key)
+}
@@ -0,0 +1,326 @@
+package hostkeys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrUnknownHost is returned in strict mode for a host without a trusted key
+var ErrUnknownHost = errors.New("host key is not trusted yet, approve it first")
+
+// ChangedError is returned when a host presents a key different from the trusted one
+type ChangedError struct {
+	Host        string
+	Fingerprint string
+	Pinned      bool
+}
+
+func (e *ChangedError) Error() string {
+	if e.Pinned {
+		return fmt.Sprintf("host key for %s (%s) does not match the pinned fingerprint", e.Host, e.Fingerprint)
+	}
+	return fmt.Sprintf("host key for %s changed to %s, possible man-in-the-middle attack", e.Host, e.Fingerprint)
+}
+
+// HostKey is a single known_hosts entry or a key waiting for approval
+type HostKey struct {
+	Host        string    `json:"host"`
+	Type        string    `json:"type"`
+	Fingerprint string    `json:"fingerprint"`
+	Pinned      bool      `json:"pinned,omitempty"`
+	Changed     bool      `json:"changed,omitempty"`
+	SeenAt      time.Time `json:"seen_at,omitempty"`
+
+	key ssh.PublicKey
+}
+
+// Store is a known_hosts file owned by gitfetcher. It is written in OpenSSH
+// format so the git CLI can use it through UserKnownHostsFile.
+type Store struct {
+	path    string
+	tofu    bool
+	pins    map[string][]string
+	pending map[string]HostKey
+	mu      sync.Mutex
+}
+
+// NewStore opens (or creates) the known_hosts file at path
+func NewStore(path string, tofu bool, pins map[string][]string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	f.Close()
+
+	s := &Store{
+		path:    path,
+		pending: make(map[string]HostKey),
+	}
+	s.Configure(tofu, pins)
+	return s, nil
+}
+
+// Configure updates the trust policy, used on config reload
+func (s *Store) Configure(tofu bool, pins map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tofu = tofu
+	s.pins = make(map[string][]string)
+	for host, fingerprints := range pins {
+		addr := knownhosts.Normalize(host)
+		s.pins[addr] = append(s.pins[addr], fingerprints...)
+	}
+}
+
+// Path returns the location of the known_hosts file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Check verifies key for hostname, it satisfies ssh.HostKeyCallback
+func (s *Store) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addr := knownhosts.Normalize(hostname)
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	// Pinned fingerprints win over whatever is in the file
+	if pins, ok := s.pins[addr]; ok {
+		if !contains(pins, fingerprint) {
+			return &ChangedError{Host: addr, Fingerprint: fingerprint, Pinned: true}
+		}
+		if !hasKey(entries, addr, key) {
+			return s.replace(entries, addr, key)
+		}
+		return nil
+	}
+
+	known := false
+	for _, entry := range entries {
+		if entry.Host != addr {
+			continue
+		}
+		known = true
+		if bytes.Equal(entry.key.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+
+	if known {
+		s.pending[addr] = HostKey{
+			Host:        addr,
+			Type:        key.Type(),
+			Fingerprint: fingerprint,
+			Changed:     true,
+			SeenAt:      time.Now(),
+			key:         key,
+		}
+		return &ChangedError{Host: addr, Fingerprint: fingerprint}
+	}
+
+	if s.tofu {
+		return s.append(addr, key)
+	}
+
+	s.pending[addr] = HostKey{
+		Host:        addr,
+		Type:        key.Type(),
+		Fingerprint: fingerprint,
+		SeenAt:      time.Now(),
+		key:         key,
+	}
+	return fmt.Errorf("%s (%s): %w", addr, fingerprint, ErrUnknownHost)
+}
+
+// List returns all trusted host keys
+func (s *Store) List() ([]HostKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Pinned = contains(s.pins[entries[i].Host], entries[i].Fingerprint)
+	}
+	return entries, nil
+}
+
+// Pending returns keys that were presented but not trusted
+func (s *Store) Pending() []HostKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]HostKey, 0, len(s.pending))
+	for _, key := range s.pending {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Host < result[j].Host })
+	return result
+}
+
+// Approve trusts a pending key, replacing any key previously known for the host
+func (s *Store) Approve(host, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addr := knownhosts.Normalize(host)
+	pending, ok := s.pending[addr]
+	if !ok {
+		return fmt.Errorf("no pending host key for %s", addr)
+	}
+	if pending.Fingerprint != fingerprint {
+		return fmt.Errorf("fingerprint mismatch for %s: pending key is %s", addr, pending.Fingerprint)
+	}
+	if pins, ok := s.pins[addr]; ok && !contains(pins, fingerprint) {
+		return fmt.Errorf("%s is pinned in config, update the pinned fingerprints instead", addr)
+	}
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := s.replace(entries, addr, pending.key); err != nil {
+		return err
+	}
+	delete(s.pending, addr)
+	return nil
+}
+
+// Revoke removes every trusted key for host and returns how many were removed
+func (s *Store) Revoke(host string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addr := knownhosts.Normalize(host)
+	delete(s.pending, addr)
+
+	entries, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Host != addr {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(entries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.write(kept)
+}
+
+// load parses the known_hosts file, one HostKey per host and key
+func (s *Store) load() ([]HostKey, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	var entries []HostKey
+	for len(data) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err == nil {
+			for _, host := range hosts {
+				entries = append(entries, HostKey{
+					Host:        host,
+					Type:        key.Type(),
+					Fingerprint: ssh.FingerprintSHA256(key),
+					key:         key,
+				})
+			}
+		} else if !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+		}
+		data = rest
+	}
+	return entries, nil
+}
+
+// append adds a single key to the file
+func (s *Store) append(addr string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(knownhosts.Line([]string{addr}, key) + "\n"); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	return nil
+}
+
+// replace drops existing keys for addr and trusts key instead
+func (s *Store) replace(entries []HostKey, addr string, key ssh.PublicKey) error {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Host != addr {
+			kept = append(kept, entry)
+		}
+	}
+	kept = append(kept, HostKey{Host: addr, key: key})
+	return s.write(kept)
+}
+
+// write rewrites the whole file
+func (s *Store) write(entries []HostKey) error {
+	var buf strings.Builder
+	for _, entry := range entries {
+		buf.WriteString(knownhosts.Line([]string{entry.Host}, entry.key))
+		buf.WriteString("\n")
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(buf.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	return nil
+}
+
+func hasKey(entries []HostKey, addr string, key ssh.PublicKey) bool {
+	for _, entry := range entries {
+		if entry.Host == addr && bytes.Equal(entry.key.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
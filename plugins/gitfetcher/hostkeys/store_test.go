@@ -0,0 +1,254 @@
+package hostkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newKey generates a random ed25519 host key
+func newKey(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// newSSHServer accepts ssh handshakes with the given host key and nothing more
+func newSSHServer(t *testing.T, hostKey ssh.Signer) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, cfg)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func newTestStore(t *testing.T, tofu bool, pins map[string][]string) *Store {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data", "known_hosts"), tofu, pins)
+	if err != nil {
+		t.Fatalf("NewStore() failed: %v", err)
+	}
+	return store
+}
+
+func TestNewStoreCreatesFile(t *testing.T) {
+	store := newTestStore(t, true, nil)
+
+	info, err := os.Stat(store.Path())
+	if err != nil {
+		t.Fatalf("Expected known_hosts file to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestCheckTOFU(t *testing.T) {
+	store := newTestStore(t, true, nil)
+	key := newKey(t).PublicKey()
+
+	if err := store.Check("git.example.com:22", nil, key); err != nil {
+		t.Fatalf("Expected first key to be trusted, got %v", err)
+	}
+	if err := store.Check("git.example.com:22", nil, key); err != nil {
+		t.Errorf("Expected known key to be accepted, got %v", err)
+	}
+
+	keys, _ := store.List()
+	if len(keys) != 1 || keys[0].Host != "git.example.com" {
+		t.Fatalf("Expected one entry for git.example.com, got %+v", keys)
+	}
+	if keys[0].Fingerprint != ssh.FingerprintSHA256(key) {
+		t.Errorf("Expected fingerprint %s, got %s", ssh.FingerprintSHA256(key), keys[0].Fingerprint)
+	}
+
+	// The file must stay readable by OpenSSH
+	content, _ := os.ReadFile(store.Path())
+	if !strings.HasPrefix(string(content), "git.example.com ssh-ed25519 ") {
+		t.Errorf("Unexpected known_hosts content: %s", content)
+	}
+}
+
+func TestCheckChangedKey(t *testing.T) {
+	store := newTestStore(t, true, nil)
+	oldKey := newKey(t).PublicKey()
+	newKey := newKey(t).PublicKey()
+
+	if err := store.Check("git.example.com:22", nil, oldKey); err != nil {
+		t.Fatalf("Check() failed: %v", err)
+	}
+
+	err := store.Check("git.example.com:22", nil, newKey)
+	var changed *ChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("Expected ChangedError, got %v", err)
+	}
+	if changed.Fingerprint != ssh.FingerprintSHA256(newKey) {
+		t.Errorf("Expected new fingerprint in error, got %s", changed.Fingerprint)
+	}
+
+	pending := store.Pending()
+	if len(pending) != 1 || !pending[0].Changed {
+		t.Fatalf("Expected changed key to be pending, got %+v", pending)
+	}
+
+	// The old key stays trusted until the new one is approved
+	if err := store.Check("git.example.com:22", nil, oldKey); err != nil {
+		t.Errorf("Expected old key to remain trusted, got %v", err)
+	}
+
+	if err := store.Approve("git.example.com", pending[0].Fingerprint); err != nil {
+		t.Fatalf("Approve() failed: %v", err)
+	}
+	if err := store.Check("git.example.com:22", nil, newKey); err != nil {
+		t.Errorf("Expected approved key to be trusted, got %v", err)
+	}
+	if len(store.Pending()) != 0 {
+		t.Error("Expected no pending keys after approval")
+	}
+	if err := store.Check("git.example.com:22", nil, oldKey); err == nil {
+		t.Error("Expected replaced key to be rejected")
+	}
+}
+
+func TestCheckStrict(t *testing.T) {
+	store := newTestStore(t, false, nil)
+	key := newKey(t).PublicKey()
+
+	err := store.Check("[git.example.com]:2222", nil, key)
+	if !errors.Is(err, ErrUnknownHost) {
+		t.Fatalf("Expected ErrUnknownHost, got %v", err)
+	}
+
+	if err := store.Approve("[git.example.com]:2222", "SHA256:wrong"); err == nil {
+		t.Error("Expected approval with wrong fingerprint to fail")
+	}
+	if err := store.Approve("[git.example.com]:2222", ssh.FingerprintSHA256(key)); err != nil {
+		t.Fatalf("Approve() failed: %v", err)
+	}
+	if err := store.Check("git.example.com:2222", nil, key); err != nil {
+		t.Errorf("Expected approved key to be trusted, got %v", err)
+	}
+}
+
+func TestCheckPinned(t *testing.T) {
+	key := newKey(t).PublicKey()
+	other := newKey(t).PublicKey()
+	store := newTestStore(t, false, map[string][]string{
+		"git.example.com": {ssh.FingerprintSHA256(key)},
+	})
+
+	if err := store.Check("git.example.com:22", nil, key); err != nil {
+		t.Fatalf("Expected pinned key to be trusted, got %v", err)
+	}
+
+	var changed *ChangedError
+	if err := store.Check("git.example.com:22", nil, other); !errors.As(err, &changed) || !changed.Pinned {
+		t.Fatalf("Expected pinned ChangedError, got %v", err)
+	}
+
+	keys, _ := store.List()
+	if len(keys) != 1 || !keys[0].Pinned {
+		t.Errorf("Expected one pinned entry, got %+v", keys)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	store := newTestStore(t, true, nil)
+	store.Check("a.example.com:22", nil, newKey(t).PublicKey())
+	store.Check("b.example.com:22", nil, newKey(t).PublicKey())
+
+	removed, err := store.Revoke("a.example.com")
+	if err != nil {
+		t.Fatalf("Revoke() failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 key removed, got %d", removed)
+	}
+
+	keys, _ := store.List()
+	if len(keys) != 1 || keys[0].Host != "b.example.com" {
+		t.Errorf("Expected only b.example.com to remain, got %+v", keys)
+	}
+
+	if removed, _ := store.Revoke("unknown.example.com"); removed != 0 {
+		t.Errorf("Expected 0 keys removed, got %d", removed)
+	}
+}
+
+func TestSSHAddress(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+		ok     bool
+	}{
+		{"git@github.com:user/repo.git", "github.com:22", true},
+		{"ssh://git@git.example.com:2222/repo.git", "git.example.com:2222", true},
+		{"ssh://git.example.com/repo.git", "git.example.com:22", true},
+		{"https://github.com/user/repo.git", "", false},
+		{"/repos/local.git", "", false},
+		{"file:///repos/local.git", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := SSHAddress(tt.remote)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("SSHAddress(%q) = (%q, %v), want (%q, %v)", tt.remote, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestScanAndVerify(t *testing.T) {
+	hostKey := newKey(t)
+	addr := newSSHServer(t, hostKey)
+
+	key, err := Scan(context.Background(), addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Scan() failed: %v", err)
+	}
+	if ssh.FingerprintSHA256(key) != ssh.FingerprintSHA256(hostKey.PublicKey()) {
+		t.Errorf("Expected scanned key to match server key")
+	}
+
+	store := newTestStore(t, false, nil)
+	err = store.Verify(context.Background(), "ssh://git@"+addr+"/repo.git", 5*time.Second)
+	if !errors.Is(err, ErrUnknownHost) {
+		t.Errorf("Expected ErrUnknownHost, got %v", err)
+	}
+
+	if err := store.Verify(context.Background(), "https://github.com/user/repo.git", time.Second); err != nil {
+		t.Errorf("Expected non-ssh remote to be accepted, got %v", err)
+	}
+}
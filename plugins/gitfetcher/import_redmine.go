@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/redmine"
+)
+
+// importRedmine implements `gitfetcher import-redmine`: it proposes a repo for
+// every git repository configured in Redmine that is not mirrored yet, asks
+// for the upstream URLs it cannot infer and adds the repos to the config. A
+// running gitfetcher picks the change up like any other edit of the file.
+func importRedmine(args []string) error {
+	fs := flag.NewFlagSet("import-redmine", flag.ExitOnError)
+	path := fs.String("config", "config.yaml", "Path to configuration file")
+	interval := fs.String("interval", redmine.DefaultImportInterval, "Interval of the imported repos")
+	yes := fs.Bool("yes", false, "Do not ask, skip repos without a known upstream URL")
+	dryRun := fs.Bool("dry-run", false, "Only list the proposed repos")
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*path)
+	if err != nil {
+		return err
+	}
+	db, ok := cfg.RedmineDatabase()
+	if !ok {
+		return fmt.Errorf("Redmine's database is unknown, set redmine.database or use postgres storage")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	repos, err := redmine.LoadRepositories(ctx, db)
+	if err != nil {
+		return err
+	}
+	opts := redmine.Options(cfg, *interval)
+	opts.UpstreamURL = fetcher.OriginURL
+	proposals := redmine.Propose(repos, cfg, opts)
+	printProposals(os.Stdout, proposals)
+	if *dryRun {
+		return nil
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	urls := make(map[string]string)
+	if !*yes {
+		for _, p := range proposals {
+			if p.Status == redmine.ProposalNeedsURL {
+				urls[p.Repo.Name] = ask(in, fmt.Sprintf("Upstream URL of %s (Redmine project %s), empty to skip: ", p.Repo.Name, p.Redmine.Project))
+			}
+		}
+	}
+
+	added := redmine.Apply(cfg, proposals, urls)
+	if len(added) == 0 {
+		fmt.Println("Nothing to import")
+		return nil
+	}
+	if !*yes && !strings.EqualFold(ask(in, fmt.Sprintf("Add %d repositories to %s? [y/N] ", len(added), *path)), "y") {
+		fmt.Println("Aborted")
+		return nil
+	}
+
+	data, err := config.MarshalConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.WriteConfig(*path, data); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s\n", strings.Join(added, ", "))
+	return nil
+}
+
+// printProposals lists the proposals as a table
+func printProposals(out io.Writer, proposals []redmine.Proposal) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tPROJECT\tNAME\tLOCAL PATH\tURL")
+	for _, p := range proposals {
+		detail := p.Repo.URL
+		if p.Reason != "" {
+			detail = p.Reason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Status, p.Redmine.Project, p.Repo.Name, p.Repo.LocalPath, detail)
+	}
+	w.Flush()
+}
+
+// ask prints prompt and reads a line from in
+func ask(in *bufio.Reader, prompt string) string {
+	fmt.Print(prompt)
+	line, _ := in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"colosscious.com/gitfetcher/config"
 	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/hostkeys"
 	"colosscious.com/gitfetcher/scheduler"
+	"colosscious.com/gitfetcher/storage"
 	"colosscious.com/gitfetcher/web"
 	"github.com/fsnotify/fsnotify"
 	"github.com/gin-gonic/gin"
@@ -22,6 +27,14 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import-redmine" {
+		if err := importRedmine(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "import-redmine: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	log.Printf("GitFetcher v%s starting...", version)
@@ -34,16 +47,50 @@ func main() {
 
 	log.Printf("Loaded config from %s", *configPath)
 
+	// Open the known_hosts store used to verify SSH remotes
+	hostKeys, err := hostkeys.NewStore(cfg.HostKeys.EffectiveKnownHostsPath(), cfg.HostKeys.TOFU(), cfg.HostKeys.Pinned)
+	if err != nil {
+		log.Fatalf("Failed to open host key store: %v", err)
+	}
+
+	// Open the fetch history database, its settings are fixed at startup
+	store, err := storage.Open(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer store.Close()
+	if retention, _ := cfg.Storage.ParseRetention(); retention > 0 {
+		go pruneHistory(store, retention)
+	}
+
 	// Initialize components
 	gitFetcher := fetcher.NewGitFetcher(cfg.SSHKeyPath, cfg.LogPath)
+	gitFetcher.SetHostKeyStore(hostKeys)
+	goGitFetcher := fetcher.NewGoGitFetcher(cfg.SSHKeyPath, cfg.LogPath)
+	goGitFetcher.SetHostKeyStore(hostKeys)
 	sched := scheduler.NewScheduler(gitFetcher)
+	sched.RegisterBackend(config.BackendCLI, gitFetcher)
+	sched.RegisterBackend(config.BackendGoGit, goGitFetcher)
+	if err := sched.SetStore(store); err != nil {
+		log.Fatalf("Failed to load schedule state: %v", err)
+	}
 	sched.LoadConfig(cfg)
 
 	// Setup HTTP server
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 	handler := web.NewHandler(sched, *configPath)
+	handler.SetHostKeyStore(hostKeys)
+	handler.SetStore(store)
+	// Users and tokens are read again on every config reload
+	if err := handler.ReloadAuth(cfg.WebAuth); err != nil {
+		log.Fatalf("Failed to set up API authentication: %v", err)
+	}
+	if !cfg.WebAuth.Enabled() {
+		log.Println("Warning: web_auth is not configured, anyone who can reach the API can change the configuration")
+	}
 	handler.SetupRoutes(router)
+	handler.RecordConfigFile()
 
 	// Start config file watcher for hot reload
 	watcher, err := fsnotify.NewWatcher()
@@ -52,10 +99,15 @@ func main() {
 	}
 	defer watcher.Close()
 
-	if err := watcher.Add(*configPath); err != nil {
-		log.Printf("Warning: Failed to watch config file: %v", err)
+	// Watch the directory for a config saved with a rename, which replaces the
+	// inode a file watch is attached to, and the file itself for writes to a
+	// file bind mounted on its own, whose directory is on the host
+	dirErr := watcher.Add(filepath.Dir(*configPath))
+	fileErr := watcher.Add(*configPath)
+	if dirErr != nil && fileErr != nil {
+		log.Printf("Warning: Failed to watch config file: %v", fileErr)
 	} else {
-		go watchConfigFile(watcher, sched)
+		go watchConfigFile(watcher, sched, hostKeys, handler)
 	}
 
 	// Start HTTP server in background
@@ -79,8 +131,16 @@ func main() {
 	log.Println("GitFetcher stopped")
 }
 
-// watchConfigFile monitors config file changes and reloads
-func watchConfigFile(watcher *fsnotify.Watcher, sched *scheduler.Scheduler) {
+// reloadDelay coalesces the events of a single save into one reload
+const reloadDelay = 100 * time.Millisecond
+
+// watchConfigFile monitors the config file and its directory and reloads when
+// the file is written, or created by renaming a new version over it
+func watchConfigFile(watcher *fsnotify.Watcher, sched *scheduler.Scheduler, hostKeys *hostkeys.Store, handler *web.Handler) {
+	name := filepath.Clean(*configPath)
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+
 	for {
 		select {
 		case event, ok := <-watcher.Events:
@@ -88,18 +148,37 @@ func watchConfigFile(watcher *fsnotify.Watcher, sched *scheduler.Scheduler) {
 				return
 			}
 
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				log.Printf("Config file changed, reloading...")
+			if filepath.Clean(event.Name) != name {
+				continue
+			}
+			// The file watch ends with the inode it was attached to, watch the
+			// new file. It fails while there is none, its Create event retries.
+			if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+				watcher.Add(name)
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				reload.Reset(reloadDelay)
+			}
+
+		case <-reload.C:
+			log.Printf("Config file changed, reloading...")
 
-				cfg, err := config.LoadConfig(*configPath)
-				if err != nil {
-					log.Printf("Failed to reload config: %v", err)
-					continue
-				}
+			cfg, err := config.LoadConfig(*configPath)
+			if err != nil {
+				log.Printf("Failed to reload config: %v", err)
+				continue
+			}
 
-				sched.LoadConfig(cfg)
-				log.Println("Config reloaded successfully")
+			// The known_hosts path is fixed at startup, only the policy is reloaded
+			hostKeys.Configure(cfg.HostKeys.TOFU(), cfg.HostKeys.Pinned)
+			sched.LoadConfig(cfg)
+			if err := handler.ReloadAuth(cfg.WebAuth); err != nil {
+				log.Printf("Failed to reload web_auth, keeping the previous users and tokens: %v", err)
+			} else if !cfg.WebAuth.Enabled() {
+				log.Println("Warning: web_auth is not configured, anyone who can reach the API can change the configuration")
 			}
+			handler.RecordConfigFile()
+			log.Println("Config reloaded successfully")
 
 		case err, ok := <-watcher.Errors:
 			if !ok {
@@ -109,3 +188,20 @@ func watchConfigFile(watcher *fsnotify.Watcher, sched *scheduler.Scheduler) {
 		}
 	}
 }
+
+// pruneHistory deletes fetch history older than retention once an hour
+func pruneHistory(store *storage.DB, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if n, err := store.Prune(ctx, time.Now().Add(-retention)); err != nil {
+			log.Printf("Failed to prune fetch history: %v", err)
+		} else if n > 0 {
+			log.Printf("Pruned %d fetch history records older than %s", n, retention)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
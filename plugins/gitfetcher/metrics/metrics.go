@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gitfetcher"
+
+var (
+	lastSuccessDesc = prometheus.NewDesc(
+		namespace+"_repo_last_success_timestamp_seconds",
+		"Unix time the last successful fetch finished, 0 if the repository was never fetched successfully.",
+		[]string{"repo"}, nil)
+	lastFetchDesc = prometheus.NewDesc(
+		namespace+"_repo_last_fetch_timestamp_seconds",
+		"Unix time the last fetch finished, successful or not.",
+		[]string{"repo"}, nil)
+	nextFetchDesc = prometheus.NewDesc(
+		namespace+"_repo_next_fetch_timestamp_seconds",
+		"Unix time of the next scheduled fetch.",
+		[]string{"repo"}, nil)
+	fetchesDesc = prometheus.NewDesc(
+		namespace+"_repo_fetches_total",
+		"Fetches since the repository was loaded, by result.",
+		[]string{"repo", "result"}, nil)
+	durationDesc = prometheus.NewDesc(
+		namespace+"_repo_fetch_duration_seconds",
+		"Duration of fetches including retries.",
+		[]string{"repo"}, nil)
+	runningDesc = prometheus.NewDesc(
+		namespace+"_repo_running",
+		"Whether a fetch of the repository is in flight.",
+		[]string{"repo"}, nil)
+	queuedDesc = prometheus.NewDesc(
+		namespace+"_repo_queued",
+		"Whether a fetch of the repository is waiting in the queue.",
+		[]string{"repo"}, nil)
+	consecutiveFailuresDesc = prometheus.NewDesc(
+		namespace+"_repo_consecutive_failures",
+		"Failed fetches in a row, the circuit opens at the failure threshold.",
+		[]string{"repo"}, nil)
+	circuitOpenDesc = prometheus.NewDesc(
+		namespace+"_repo_circuit_open",
+		"Whether scheduled fetches of the repository are suspended by the circuit breaker.",
+		[]string{"repo"}, nil)
+	pausedDesc = prometheus.NewDesc(
+		namespace+"_repo_paused",
+		"Whether scheduled fetches of the repository are paused or disabled.",
+		[]string{"repo"}, nil)
+	pushLastSuccessDesc = prometheus.NewDesc(
+		namespace+"_push_mirror_last_success_timestamp_seconds",
+		"Unix time of the last successful push to a push mirror, 0 if it never succeeded.",
+		[]string{"repo", "mirror"}, nil)
+	pushFailingDesc = prometheus.NewDesc(
+		namespace+"_push_mirror_failing",
+		"Whether the last push to a push mirror failed.",
+		[]string{"repo", "mirror"}, nil)
+	queuePendingDesc = prometheus.NewDesc(
+		namespace+"_queue_pending",
+		"Fetches waiting for a free slot.",
+		nil, nil)
+	queueRunningDesc = prometheus.NewDesc(
+		namespace+"_queue_running",
+		"Fetches in flight.",
+		nil, nil)
+	queueMaxDesc = prometheus.NewDesc(
+		namespace+"_queue_max_concurrent",
+		"Maximum number of fetches running at the same time.",
+		nil, nil)
+)
+
+// Collector exposes scheduler state as Prometheus metrics. Values are read
+// from the scheduler on every scrape, nothing is tracked separately.
+type Collector struct {
+	sched *scheduler.Scheduler
+}
+
+// NewCollector creates a collector for s
+func NewCollector(s *scheduler.Scheduler) *Collector {
+	return &Collector{sched: s}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		lastSuccessDesc, lastFetchDesc, nextFetchDesc, fetchesDesc, durationDesc,
+		runningDesc, queuedDesc, consecutiveFailuresDesc, circuitOpenDesc, pausedDesc,
+		pushLastSuccessDesc, pushFailingDesc, queuePendingDesc, queueRunningDesc, queueMaxDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	durations := c.sched.FetchDurations()
+	for name, status := range c.sched.GetStatus() {
+		ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, unixSeconds(status.LastSuccessAt), name)
+		ch <- prometheus.MustNewConstMetric(lastFetchDesc, prometheus.GaugeValue, unixSeconds(status.LastFetch), name)
+		ch <- prometheus.MustNewConstMetric(nextFetchDesc, prometheus.GaugeValue, unixSeconds(status.NextFetch), name)
+		ch <- prometheus.MustNewConstMetric(fetchesDesc, prometheus.CounterValue, float64(status.SuccessCount), name, "success")
+		ch <- prometheus.MustNewConstMetric(fetchesDesc, prometheus.CounterValue, float64(status.FailCount), name, "failure")
+		ch <- prometheus.MustNewConstMetric(runningDesc, prometheus.GaugeValue, boolValue(status.IsRunning), name)
+		ch <- prometheus.MustNewConstMetric(queuedDesc, prometheus.GaugeValue, boolValue(status.IsQueued), name)
+		ch <- prometheus.MustNewConstMetric(consecutiveFailuresDesc, prometheus.GaugeValue, float64(status.ConsecutiveFailures), name)
+		ch <- prometheus.MustNewConstMetric(circuitOpenDesc, prometheus.GaugeValue, boolValue(status.CircuitState == scheduler.CircuitOpen), name)
+		ch <- prometheus.MustNewConstMetric(pausedDesc, prometheus.GaugeValue, boolValue(status.Paused || status.Disabled), name)
+		for _, m := range status.PushMirrors {
+			ch <- prometheus.MustNewConstMetric(pushLastSuccessDesc, prometheus.GaugeValue, unixSeconds(m.LastSuccessAt), name, m.Name)
+			ch <- prometheus.MustNewConstMetric(pushFailingDesc, prometheus.GaugeValue, boolValue(!m.LastPush.IsZero() && !m.LastSuccess), name, m.Name)
+		}
+
+		if h, ok := durations[name]; ok {
+			ch <- prometheus.MustNewConstHistogram(durationDesc, h.Count, h.Sum, h.Buckets, name)
+		}
+	}
+
+	queue := c.sched.Queue()
+	ch <- prometheus.MustNewConstMetric(queuePendingDesc, prometheus.GaugeValue, float64(len(queue.Pending)))
+	ch <- prometheus.MustNewConstMetric(queueRunningDesc, prometheus.GaugeValue, float64(len(queue.Running)))
+	ch <- prometheus.MustNewConstMetric(queueMaxDesc, prometheus.GaugeValue, float64(queue.MaxConcurrent))
+}
+
+// Handler serves the scheduler metrics together with the Go runtime and
+// process metrics in the Prometheus text format
+func Handler(s *scheduler.Scheduler) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		NewCollector(s),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// unixSeconds returns t as Unix seconds, 0 for the zero time
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
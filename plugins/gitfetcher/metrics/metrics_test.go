@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/scheduler"
+)
+
+// stubFetcher succeeds for every repo except "broken"
+type stubFetcher struct{}
+
+func (f stubFetcher) Clone(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	return f.Fetch(ctx, repo)
+}
+
+func (stubFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	if repo.Name == "broken" {
+		return &fetcher.FetchResult{RepoName: repo.Name, Status: fetcher.StatusFailed, Message: "connection reset", Timestamp: time.Now()}
+	}
+	return &fetcher.FetchResult{RepoName: repo.Name, Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now()}
+}
+
+func TestHandler(t *testing.T) {
+	s := scheduler.NewScheduler(stubFetcher{})
+	defer s.Stop()
+
+	cfg := &config.Config{HTTPPort: 8080, Retry: &config.RetryConfig{MaxAttempts: 1}}
+	for _, name := range []string{"healthy", "broken"} {
+		cfg.Repos = append(cfg.Repos, config.RepoConfig{
+			Name:      name,
+			URL:       "git@github.com:user/" + name + ".git",
+			LocalPath: "/repos/" + name + ".git",
+			Interval:  "1h",
+		})
+	}
+	s.LoadConfig(cfg)
+	time.Sleep(100 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	Handler(s).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	text := string(body)
+
+	for _, want := range []string{
+		`gitfetcher_repo_fetches_total{repo="healthy",result="success"} 1`,
+		`gitfetcher_repo_fetches_total{repo="broken",result="failure"} 1`,
+		`gitfetcher_repo_last_success_timestamp_seconds{repo="broken"} 0`,
+		`gitfetcher_repo_fetch_duration_seconds_count{repo="healthy"} 1`,
+		`gitfetcher_repo_fetch_duration_seconds_bucket{repo="healthy",le="+Inf"} 1`,
+		`gitfetcher_repo_running{repo="healthy"} 0`,
+		`gitfetcher_queue_pending 0`,
+		`gitfetcher_queue_max_concurrent 4`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(text, `gitfetcher_repo_last_success_timestamp_seconds{repo="healthy"} 0`) {
+		t.Error("Expected last success timestamp of healthy repo to be set")
+	}
+}
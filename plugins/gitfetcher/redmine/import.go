@@ -0,0 +1,215 @@
+package redmine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"colosscious.com/gitfetcher/config"
+	_ "github.com/lib/pq"
+)
+
+// DefaultImportInterval is the interval of repos imported from Redmine
+const DefaultImportInterval = "10m"
+
+// Repository is a git repository configured in a Redmine project
+type Repository struct {
+	ID          int64  `json:"id"`
+	Project     string `json:"project"` // project identifier
+	ProjectName string `json:"project_name"`
+	Identifier  string `json:"identifier,omitempty"` // empty for the main repository of a project
+	URL         string `json:"url"`                  // path of the repository as seen by Redmine
+	RootURL     string `json:"root_url,omitempty"`
+}
+
+// Status of a proposal
+const (
+	ProposalNew      = "new"       // ready to be added
+	ProposalNeedsURL = "needs_url" // the upstream URL could not be inferred
+	ProposalExists   = "exists"    // a repo already mirrors this path
+	ProposalSkipped  = "skipped"   // not on the shared volume, see Reason
+)
+
+// Proposal is a repo suggested for the gitfetcher config
+type Proposal struct {
+	Repo    config.RepoConfig `json:"repo"`
+	Redmine Repository        `json:"redmine"`
+	Status  string            `json:"status"`
+	Reason  string            `json:"reason,omitempty"`
+}
+
+// ImportOptions tell Propose how to map Redmine's paths to mirrors
+type ImportOptions struct {
+	RepositoriesPath string // the shared volume as seen by Redmine
+	MirrorsPath      string // the shared volume as seen by gitfetcher
+	Interval         string // of proposed repos, DefaultImportInterval if empty
+
+	// UpstreamURL infers the remote of an existing mirror, empty if unknown
+	UpstreamURL func(localPath string) string
+}
+
+// Options returns the import options configured in cfg
+func Options(cfg *config.Config, interval string) ImportOptions {
+	repositories, mirrors := cfg.Redmine.Paths()
+	return ImportOptions{RepositoriesPath: repositories, MirrorsPath: mirrors, Interval: interval}
+}
+
+// LoadRepositories reads the git repositories of every Redmine project from
+// Redmine's database. Schema defaults to public.
+func LoadRepositories(ctx context.Context, db config.PostgresConfig) ([]Repository, error) {
+	dsn, err := db.DSN()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Redmine database: %w", err)
+	}
+	defer conn.Close()
+
+	schema := db.Schema
+	if schema == "" {
+		schema = "public"
+	}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT r.id, p.identifier, p.name, COALESCE(r.identifier, ''), COALESCE(r.url, ''), COALESCE(r.root_url, '')
+		FROM %[1]s.repositories r JOIN %[1]s.projects p ON p.id = r.project_id
+		WHERE r.type = 'Repository::Git'
+		ORDER BY p.identifier, r.is_default DESC, r.identifier`, schema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Redmine repositories: %w", err)
+	}
+	defer rows.Close()
+
+	var repos []Repository
+	for rows.Next() {
+		var r Repository
+		if err := rows.Scan(&r.ID, &r.Project, &r.ProjectName, &r.Identifier, &r.URL, &r.RootURL); err != nil {
+			return nil, err
+		}
+		repos = append(repos, r)
+	}
+	return repos, rows.Err()
+}
+
+// Propose maps Redmine repositories to repos for cfg. A repository is proposed
+// when its url (or root_url) lies on the shared volume and no configured repo
+// mirrors that path yet. Its name is the project identifier, followed by the
+// repository identifier for additional repositories of a project.
+func Propose(repos []Repository, cfg *config.Config, opts ImportOptions) []Proposal {
+	interval := opts.Interval
+	if interval == "" {
+		interval = DefaultImportInterval
+	}
+	paths := make(map[string]string, len(cfg.Repos))
+	names := make(map[string]bool, len(cfg.Repos))
+	for _, repo := range cfg.Repos {
+		paths[filepath.Clean(repo.LocalPath)] = repo.Name
+		names[repo.Name] = true
+	}
+
+	proposals := make([]Proposal, 0, len(repos))
+	for _, r := range repos {
+		p := Proposal{Redmine: r}
+		local, ok := mirrorPath(r, opts.RepositoriesPath, opts.MirrorsPath)
+		switch {
+		case !ok:
+			p.Status = ProposalSkipped
+			p.Reason = fmt.Sprintf("'%s' is not under %s", r.URL, opts.RepositoriesPath)
+		case paths[local] != "":
+			p.Status = ProposalExists
+			p.Reason = "mirrored by " + paths[local]
+			p.Repo = cfg.Repos[cfg.FindRepo(paths[local])]
+		default:
+			p.Repo = config.RepoConfig{
+				Name:           uniqueName(repoName(r), r.ID, names),
+				LocalPath:      local,
+				Interval:       interval,
+				RedmineProject: r.Project,
+			}
+			if opts.UpstreamURL != nil {
+				p.Repo.URL = opts.UpstreamURL(local)
+			}
+			p.Status = ProposalNew
+			if p.Repo.URL == "" {
+				p.Status = ProposalNeedsURL
+			}
+			names[p.Repo.Name] = true
+			paths[local] = p.Repo.Name
+		}
+		proposals = append(proposals, p)
+	}
+	return proposals
+}
+
+// Apply appends the proposals that are new, or need a URL given in urls by
+// repo name, to cfg. Redmine projects are only linked when cfg asks Redmine to
+// import changesets. It returns the names of the added repos.
+func Apply(cfg *config.Config, proposals []Proposal, urls map[string]string) []string {
+	var added []string
+	for _, p := range proposals {
+		repo := p.Repo
+		switch p.Status {
+		case ProposalNew:
+		case ProposalNeedsURL:
+			repo.URL = strings.TrimSpace(urls[repo.Name])
+			if repo.URL == "" {
+				continue
+			}
+		default:
+			continue
+		}
+		if !cfg.Redmine.ImportsChangesets() {
+			repo.RedmineProject = ""
+		}
+		cfg.Repos = append(cfg.Repos, repo)
+		added = append(added, repo.Name)
+	}
+	return added
+}
+
+// mirrorPath maps the path Redmine uses for r to the path gitfetcher sees
+func mirrorPath(r Repository, repositories, mirrors string) (string, bool) {
+	for _, u := range []string{r.URL, r.RootURL} {
+		u = strings.TrimPrefix(u, "file://")
+		if u == "" || !filepath.IsAbs(u) {
+			continue
+		}
+		u = filepath.Clean(u)
+		if rel, ok := under(u, repositories); ok {
+			return filepath.Join(mirrors, rel), true
+		}
+		// Mounted at the same path in both containers
+		if _, ok := under(u, mirrors); ok {
+			return u, true
+		}
+	}
+	return "", false
+}
+
+// under returns path relative to root, ok is false unless it lies below root
+func under(path, root string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Clean(root), path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// repoName names the repo after its project, and its identifier unless it is the main one
+func repoName(r Repository) string {
+	if r.Identifier == "" {
+		return r.Project
+	}
+	return r.Project + "-" + r.Identifier
+}
+
+// uniqueName returns name, or name suffixed with the Redmine id if it is taken
+func uniqueName(name string, id int64, taken map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, id)
+}
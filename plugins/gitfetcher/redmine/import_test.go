@@ -0,0 +1,84 @@
+package redmine
+
+import (
+	"reflect"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+func TestPropose(t *testing.T) {
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "mirrored", URL: "git@github.com:user/mirrored.git", LocalPath: "/repos/mirrored.git", Interval: "5m"},
+			{Name: "docs", URL: "git@github.com:user/other.git", LocalPath: "/repos/other.git", Interval: "5m"},
+		},
+		HTTPPort: 8080,
+	}
+	repos := []Repository{
+		{ID: 1, Project: "mirrored", URL: "/usr/src/redmine/repositories/mirrored.git"},
+		{ID: 2, Project: "app", URL: "/usr/src/redmine/repositories/app.git"},
+		{ID: 3, Project: "app", Identifier: "tools", URL: "/repos/app-tools.git/"},
+		{ID: 4, Project: "docs", URL: "/usr/src/redmine/repositories/docs.git"},
+		{ID: 5, Project: "legacy", URL: "/srv/git/legacy.git"},
+	}
+	upstreams := map[string]string{"/repos/app.git": "git@github.com:user/app.git"}
+	opts := ImportOptions{
+		RepositoriesPath: config.DefaultRedmineRepositoriesPath,
+		MirrorsPath:      config.DefaultMirrorsPath,
+		UpstreamURL:      func(path string) string { return upstreams[path] },
+	}
+
+	proposals := Propose(repos, cfg, opts)
+	type summary struct{ status, name, path, url string }
+	var got []summary
+	for _, p := range proposals {
+		got = append(got, summary{p.Status, p.Repo.Name, p.Repo.LocalPath, p.Repo.URL})
+	}
+	want := []summary{
+		{ProposalExists, "mirrored", "/repos/mirrored.git", "git@github.com:user/mirrored.git"},
+		{ProposalNew, "app", "/repos/app.git", "git@github.com:user/app.git"},
+		{ProposalNeedsURL, "app-tools", "/repos/app-tools.git", ""},
+		{ProposalNeedsURL, "docs-4", "/repos/docs.git", ""}, // docs is taken by another repo
+		{ProposalSkipped, "", "", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Propose() =\n%+v\nwant\n%+v", got, want)
+	}
+	if p := proposals[1]; p.Repo.Interval != DefaultImportInterval || p.Repo.RedmineProject != "app" {
+		t.Errorf("Expected the default interval and the Redmine project, got %+v", p.Repo)
+	}
+
+	// Without redmine.url the project is not linked, repos without URL are left out
+	added := Apply(cfg, proposals, map[string]string{"app-tools": "https://github.com/user/tools.git"})
+	if !reflect.DeepEqual(added, []string{"app", "app-tools"}) {
+		t.Fatalf("Apply() = %v", added)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected a valid config after Apply(), got %v", err)
+	}
+	if repo := cfg.Repos[cfg.FindRepo("app")]; repo.RedmineProject != "" {
+		t.Errorf("Expected no redmine_project without redmine.url, got %q", repo.RedmineProject)
+	}
+}
+
+func TestMirrorPath(t *testing.T) {
+	tests := []struct {
+		url, rootURL string
+		want         string
+		ok           bool
+	}{
+		{"/usr/src/redmine/repositories/a.git", "", "/repos/a.git", true},
+		{"file:///usr/src/redmine/repositories/group/b.git", "", "/repos/group/b.git", true},
+		{"", "/usr/src/redmine/repositories/c.git", "/repos/c.git", true},
+		{"/usr/src/redmine/repositories", "", "", false},
+		{"/usr/src/redmine/repositories-old/d.git", "", "", false},
+		{"https://github.com/user/e.git", "", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := mirrorPath(Repository{URL: tt.url, RootURL: tt.rootURL}, config.DefaultRedmineRepositoriesPath, config.DefaultMirrorsPath)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("mirrorPath(%q, %q) = %q %v, want %q %v", tt.url, tt.rootURL, got, ok, tt.want, tt.ok)
+		}
+	}
+}
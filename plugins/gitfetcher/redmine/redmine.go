@@ -0,0 +1,68 @@
+package redmine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// Client calls the repository management web service of Redmine, which has
+// to be enabled under Administration > Settings > Repositories
+type Client struct {
+	cfg  config.RedmineConfig
+	http *http.Client
+}
+
+// NewClient returns a client for the Redmine at cfg.URL
+func NewClient(cfg config.RedmineConfig) *Client {
+	return &Client{cfg: cfg, http: http.DefaultClient}
+}
+
+// FetchChangesets makes Redmine import new changesets of every repository of
+// project. Redmine answers once the import is done, so it is bounded by the
+// configured timeout rather than ctx alone.
+func (c *Client) FetchChangesets(ctx context.Context, project string) error {
+	key, err := c.cfg.Key()
+	if err != nil {
+		return fmt.Errorf("redmine WS key: %w", err)
+	}
+	if timeout, err := c.cfg.ParseTimeout(); err == nil && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	endpoint := strings.TrimRight(c.cfg.URL, "/") + "/sys/fetch_changesets?" +
+		url.Values{"key": {key}, "id": {project}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The URL carries the key, keep it out of logs and status
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("redmine: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusForbidden:
+		return fmt.Errorf("redmine: WS for repository management is disabled or the key is wrong")
+	case http.StatusNotFound:
+		return fmt.Errorf("redmine: project '%s' not found", project)
+	}
+	return fmt.Errorf("redmine: unexpected status %s", resp.Status)
+}
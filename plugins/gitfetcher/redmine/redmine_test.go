@@ -0,0 +1,50 @@
+package redmine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+func TestFetchChangesets(t *testing.T) {
+	var gotPath, gotProject string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotProject = r.URL.Path, r.URL.Query().Get("id")
+		switch {
+		case r.URL.Query().Get("key") != "ws-key":
+			w.WriteHeader(http.StatusForbidden)
+		case gotProject != "my-project":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("REDMINE_WS_KEY", "ws-key")
+	client := NewClient(config.RedmineConfig{URL: server.URL + "/", KeyEnv: "REDMINE_WS_KEY"})
+	if err := client.FetchChangesets(context.Background(), "my-project"); err != nil {
+		t.Fatalf("FetchChangesets() failed: %v", err)
+	}
+	if gotPath != "/sys/fetch_changesets" || gotProject != "my-project" {
+		t.Errorf("Unexpected request to %s for %s", gotPath, gotProject)
+	}
+
+	if err := client.FetchChangesets(context.Background(), "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected an unknown project error, got %v", err)
+	}
+
+	t.Setenv("REDMINE_WS_KEY", "wrong")
+	if err := client.FetchChangesets(context.Background(), "my-project"); err == nil || !strings.Contains(err.Error(), "disabled or the key is wrong") {
+		t.Errorf("Expected a forbidden error, got %v", err)
+	}
+
+	// Connection errors must not leak the key from the URL
+	t.Setenv("REDMINE_WS_KEY", "s3cret-key")
+	server.Close()
+	if err := client.FetchChangesets(context.Background(), "my-project"); err == nil || strings.Contains(err.Error(), "s3cret-key") {
+		t.Errorf("Expected a connection error without the key, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/storage"
+)
+
+// ErrFetchRunning is returned by RestoreBackup while the repo is being fetched
+var ErrFetchRunning = errors.New("a fetch of the repository is running")
+
+// backupTimeout bounds listing and restoring the backups of a mirror
+const backupTimeout = time.Minute
+
+// publishBackups reports the refs a fetch backed up. The caller must hold s.mu.
+func (s *Scheduler) publishBackups(status *RepoStatus, backups []fetcher.Backup) {
+	refs := make([]string, 0, len(backups))
+	for _, b := range backups {
+		refs = append(refs, b.Original)
+	}
+	message := fmt.Sprintf("backed up %s before the fetch rewrote or pruned them", strings.Join(refs, ", "))
+	log.Printf("Fetch %s %s", status.Name, message)
+	s.publishStatus(EventRefsBackedUp, status, message)
+}
+
+// Backups returns the ref backups in the mirror of a repo, newest first
+func (s *Scheduler) Backups(name string) ([]fetcher.Backup, error) {
+	s.mu.RLock()
+	repo, exists := s.configs[name]
+	s.mu.RUnlock()
+	if !exists {
+		return nil, ErrUnknownRepo
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, backupTimeout)
+	defer cancel()
+	return fetcher.ListBackups(ctx, repo.LocalPath)
+}
+
+// RestoreBackup points target, or the ref that was backed up when it is
+// empty, at the commit of a backup in the mirror of a repo. It holds the
+// slot of the repo so no fetch runs meanwhile, and pauses scheduled fetches
+// afterwards since the next fetch would revert target to the upstream.
+func (s *Scheduler) RestoreBackup(name, ref, target, actor string) (fetcher.Backup, storage.Pause, error) {
+	s.mu.Lock()
+	repo, exists := s.configs[name]
+	if !exists {
+		s.mu.Unlock()
+		return fetcher.Backup{}, storage.Pause{}, ErrUnknownRepo
+	}
+	if _, running := s.running[name]; running {
+		s.mu.Unlock()
+		return fetcher.Backup{}, storage.Pause{}, ErrFetchRunning
+	}
+	now := time.Now()
+	job := &Job{Repo: name, Manual: true, EnqueuedAt: now, StartedAt: now, done: make(chan struct{})}
+	s.running[name] = job
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(s.ctx, backupTimeout)
+	defer cancel()
+	backup, err := fetcher.RestoreBackup(ctx, repo.LocalPath, ref, target)
+	if target == "" {
+		target = backup.Original
+	}
+
+	var p storage.Pause
+	s.mu.Lock()
+	status, loaded := s.repos[name]
+	if err == nil && loaded {
+		message := fmt.Sprintf("%s restored to %s by %s", target, backup.SHA, actor)
+		log.Printf("Repository %s: %s", name, message)
+		s.publishStatus(EventRefRestored, status, message)
+
+		p = storage.Pause{Repo: name, PausedAt: time.Now(), Actor: actor, Reason: "restored " + target + " from " + backup.Ref}
+		s.pause(status, p)
+	}
+	if s.running[name] == job {
+		delete(s.running, name)
+	}
+	close(job.done)
+	s.dispatch()
+	store := s.store
+	s.mu.Unlock()
+
+	if err != nil {
+		return fetcher.Backup{}, storage.Pause{}, err
+	}
+	if p.Repo != "" {
+		s.savePause(store, p)
+	}
+	return backup, p, nil
+}
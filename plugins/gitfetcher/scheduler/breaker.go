@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// allowFetch reports whether a scheduled fetch may run. An open circuit moves
+// to half-open once its probe is due, the probe then decides its next state.
+func allowFetch(status *RepoStatus, now time.Time) bool {
+	if status.CircuitState != CircuitOpen {
+		return true
+	}
+	if now.Before(status.NextProbe) {
+		return false
+	}
+	status.CircuitState = CircuitHalfOpen
+	return true
+}
+
+// recordRun updates the circuit breaker with the outcome of a run
+func recordRun(status *RepoStatus, cb *config.CircuitBreakerConfig, success bool, now time.Time) {
+	if success {
+		status.ConsecutiveFailures = 0
+		status.CircuitState = CircuitClosed
+		status.NextProbe = time.Time{}
+		return
+	}
+
+	status.ConsecutiveFailures++
+	if cb == nil || cb.Disabled {
+		return
+	}
+	if status.CircuitState == CircuitHalfOpen || status.ConsecutiveFailures >= cb.FailureThreshold {
+		probe, _ := cb.ParseProbeInterval()
+		status.CircuitState = CircuitOpen
+		status.NextProbe = now.Add(probe)
+	}
+}
+
+// retryable reports whether a failed attempt may succeed when tried again.
+// Host key problems need an operator, a cancelled run must stay cancelled and
+// a timed out attempt has already used up its whole budget.
+func retryable(result *fetcher.FetchResult) bool {
+	return result.Status == fetcher.StatusFailed
+}
+
+// backoff returns the delay before retry number attempt (starting at 1). The
+// delay doubles from initial up to max, and a random half of it is jittered
+// away so that repos failing together do not retry together.
+func backoff(attempt int, initial, max time.Duration) time.Duration {
+	if initial <= 0 {
+		return 0
+	}
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the scheduler
+const (
+	EventFetchQueued      = "fetch_queued"
+	EventFetchDequeued    = "fetch_dequeued" // cancelled or skipped while waiting
+	EventFetchStarted     = "fetch_started"
+	EventFetchProgress    = "fetch_progress" // Message holds a line of git progress output
+	EventFetchFinished    = "fetch_finished"
+	EventFetchFailed      = "fetch_failed"
+	EventConfigReloaded   = "config_reloaded"
+	EventRepoPaused       = "repo_paused"
+	EventRepoResumed      = "repo_resumed"      // by Resume or once the pause expired
+	EventRedmineImport    = "redmine_import"    // Redmine was asked to import changesets
+	EventSourceDiscovered = "source_discovered" // repos of a source were added or retired
+	EventSourceFailed     = "source_failed"     // listing the repos of a source failed
+	EventPushMirrored     = "push_mirrored"     // a repo was pushed to a push mirror, Message holds the outcome
+	EventRefsBackedUp     = "refs_backed_up"    // a fetch backed up refs it rewrote or pruned
+	EventRefRestored      = "ref_restored"      // a ref was restored from a backup
+)
+
+// eventBuffer is how many events a subscriber may fall behind before it is dropped
+const eventBuffer = 256
+
+// progressInterval throttles progress events and status updates per repo
+const progressInterval = 250 * time.Millisecond
+
+// Event is a change of scheduler state. Status is a snapshot of the repo
+// taken when the event was published, it is nil for config reloads and
+// source events.
+type Event struct {
+	Type    string      `json:"type"`
+	Repo    string      `json:"repo,omitempty"`
+	Time    time.Time   `json:"time"`
+	Message string      `json:"message,omitempty"`
+	Status  *RepoStatus `json:"status,omitempty"`
+}
+
+// broker fans events out to subscribers without ever blocking the publisher
+type broker struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[chan Event]struct{})}
+}
+
+func (b *broker) subscribe() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, eventBuffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() { b.unsubscribe(ch) }
+}
+
+func (b *broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publish delivers e to every subscriber. A subscriber whose buffer is full
+// is disconnected instead of silently missing events, it resyncs on reconnect.
+func (b *broker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close disconnects every subscriber
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+	b.closed = true
+}
+
+// Subscribe returns a channel receiving every event from now on and a func
+// to unsubscribe. The channel is closed when the subscriber falls too far
+// behind or the scheduler stops.
+func (s *Scheduler) Subscribe() (<-chan Event, func()) {
+	return s.events.subscribe()
+}
+
+// publishStatus publishes an event carrying a snapshot of status.
+// The caller must hold s.mu.
+func (s *Scheduler) publishStatus(eventType string, status *RepoStatus, message string) {
+	snapshot := *status
+	s.events.publish(Event{
+		Type:    eventType,
+		Repo:    status.Name,
+		Time:    time.Now(),
+		Message: message,
+		Status:  &snapshot,
+	})
+}
+
+// reportProgress records a line of git output of a running fetch and
+// publishes it, at most once per progressInterval
+func (s *Scheduler) reportProgress(status *RepoStatus, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !status.IsRunning || time.Since(status.progressAt) < progressInterval {
+		return
+	}
+	status.Progress = line
+	status.progressAt = time.Now()
+	s.events.publish(Event{
+		Type:    EventFetchProgress,
+		Repo:    status.Name,
+		Time:    status.progressAt,
+		Message: line,
+	})
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"sort"
+	"time"
+)
+
+// DurationBuckets are the upper bounds in seconds of the fetch duration
+// histogram, from quick no-op fetches up to hour long clones
+var DurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Histogram is a snapshot of the fetch durations of a repo. Buckets maps
+// each upper bound in seconds to the cumulative number of runs within it.
+type Histogram struct {
+	Count   uint64
+	Sum     float64 // seconds
+	Buckets map[float64]uint64
+}
+
+// durationHistogram accumulates fetch durations, guarded by Scheduler.mu
+type durationHistogram struct {
+	count  uint64
+	sum    float64
+	counts []uint64 // per bucket, not cumulative
+}
+
+func newDurationHistogram() *durationHistogram {
+	return &durationHistogram{counts: make([]uint64, len(DurationBuckets))}
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	h.count++
+	h.sum += seconds
+	if i := sort.SearchFloat64s(DurationBuckets, seconds); i < len(DurationBuckets) {
+		h.counts[i]++
+	}
+}
+
+func (h *durationHistogram) snapshot() Histogram {
+	buckets := make(map[float64]uint64, len(DurationBuckets))
+	var cumulative uint64
+	for i, bound := range DurationBuckets {
+		cumulative += h.counts[i]
+		buckets[bound] = cumulative
+	}
+	return Histogram{Count: h.count, Sum: h.sum, Buckets: buckets}
+}
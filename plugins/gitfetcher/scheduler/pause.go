@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"colosscious.com/gitfetcher/storage"
+)
+
+// ErrNotPaused is returned by Resume for a repo that is not paused
+var ErrNotPaused = errors.New("repository is not paused")
+
+// ErrRepoDisabled is returned by ManualFetch for a repo with enabled: false
+var ErrRepoDisabled = errors.New("repository is disabled")
+
+// Pause stops scheduled fetches of a repo until it is resumed, or until
+// until has passed unless it is zero. A pending scheduled fetch is dropped, a
+// running one finishes and manual fetches still run. The pause survives
+// config reloads and, with a store, restarts.
+func (s *Scheduler) Pause(name string, until time.Time, actor, reason string) (storage.Pause, error) {
+	p := storage.Pause{Repo: name, PausedAt: time.Now(), Until: until, Actor: actor, Reason: reason}
+
+	s.mu.Lock()
+	status, exists := s.repos[name]
+	if !exists {
+		s.mu.Unlock()
+		return storage.Pause{}, ErrUnknownRepo
+	}
+	s.pause(status, p)
+	store := s.store
+	s.mu.Unlock()
+
+	s.savePause(store, p)
+	return p, nil
+}
+
+// pause records p, drops a pending scheduled fetch and publishes it.
+// The caller must hold s.mu.
+func (s *Scheduler) pause(status *RepoStatus, p storage.Pause) {
+	s.setPause(p)
+	if job, queued := s.queued[p.Repo]; queued && !job.Manual {
+		s.dequeue(p.Repo)
+	}
+	s.applyPause(status)
+	message := "paused by " + p.Actor
+	if !p.Until.IsZero() {
+		message += " until " + p.Until.Format(time.RFC3339)
+	}
+	s.publishStatus(EventRepoPaused, status, message)
+	log.Printf("Repository %s %s", p.Repo, message)
+}
+
+// Resume lifts the pause of a repo, its next scheduled fetch runs as usual
+func (s *Scheduler) Resume(name string) error {
+	s.mu.Lock()
+	if _, exists := s.repos[name]; !exists {
+		s.mu.Unlock()
+		return ErrUnknownRepo
+	}
+	if _, paused := s.paused[name]; !paused {
+		s.mu.Unlock()
+		return ErrNotPaused
+	}
+	s.resume(name, "resumed")
+	store := s.store
+	s.mu.Unlock()
+
+	s.deletePause(store, name)
+	return nil
+}
+
+// expirePause resumes a repo once its pause until has passed, unless it was
+// paused again in the meantime
+func (s *Scheduler) expirePause(name string, until time.Time) {
+	s.mu.Lock()
+	p, paused := s.paused[name]
+	if !paused || !p.Until.Equal(until) {
+		s.mu.Unlock()
+		return
+	}
+	s.resume(name, "pause expired")
+	store := s.store
+	s.mu.Unlock()
+
+	s.deletePause(store, name)
+}
+
+// resume drops the pause of name and publishes it. The caller must hold s.mu.
+func (s *Scheduler) resume(name, message string) {
+	s.clearPause(name)
+	if status, exists := s.repos[name]; exists {
+		s.applyPause(status)
+		s.publishStatus(EventRepoResumed, status, message)
+	}
+	log.Printf("Repository %s %s", name, message)
+}
+
+// setPause records p and arms its expiry. The caller must hold s.mu.
+func (s *Scheduler) setPause(p storage.Pause) {
+	s.clearPause(p.Repo)
+	s.paused[p.Repo] = p
+	if !p.Until.IsZero() {
+		s.pauseTimers[p.Repo] = time.AfterFunc(time.Until(p.Until), func() {
+			s.expirePause(p.Repo, p.Until)
+		})
+	}
+}
+
+// clearPause forgets the pause of name. The caller must hold s.mu.
+func (s *Scheduler) clearPause(name string) {
+	if timer, ok := s.pauseTimers[name]; ok {
+		timer.Stop()
+		delete(s.pauseTimers, name)
+	}
+	delete(s.paused, name)
+}
+
+// applyPause copies the pause of a repo into its status. The caller must hold s.mu.
+func (s *Scheduler) applyPause(status *RepoStatus) {
+	p, paused := s.paused[status.Name]
+	status.Paused = paused
+	status.PausedUntil = p.Until
+	status.PausedBy = p.Actor
+	status.PauseReason = p.Reason
+}
+
+// savePause persists p if a store is set
+func (s *Scheduler) savePause(store *storage.DB, p storage.Pause) {
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := store.SavePause(ctx, p); err != nil {
+		log.Printf("Failed to save pause of %s: %v", p.Repo, err)
+	}
+}
+
+// deletePause removes the persisted pause of name
+func (s *Scheduler) deletePause(store *storage.DB, name string) {
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := store.DeletePause(ctx, name); err != nil {
+		log.Printf("Failed to delete pause of %s: %v", name, err)
+	}
+}
+
+// forgetPause deletes the persisted pause of a repo removed from config in
+// the background. The caller must hold s.mu.
+func (s *Scheduler) forgetPause(name string) {
+	if s.store == nil {
+		return
+	}
+	store := s.store
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.deletePause(store, name)
+	}()
+}
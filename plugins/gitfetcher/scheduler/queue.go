@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Job is a fetch waiting for, or holding, one of the worker slots
+type Job struct {
+	Repo       string
+	Manual     bool // manual jobs run before scheduled ones
+	EnqueuedAt time.Time
+	StartedAt  time.Time
+
+	seq   uint64        // keeps jobs of the same priority in FIFO order
+	index int           // position in the heap, maintained by jobQueue
+	done  chan struct{} // closed once the job released its worker slot
+}
+
+// QueueStatus is a snapshot of the work queue
+type QueueStatus struct {
+	MaxConcurrent int
+	Pending       []Job // in the order they will run
+	Running       []Job
+}
+
+// jobQueue is a container/heap priority queue of pending jobs
+type jobQueue []*Job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].Manual != q[j].Manual {
+		return q[i].Manual
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	job := x.(*Job)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*q = old[:n-1]
+	return job
+}
+
+// enqueue adds a fetch of name to the queue. A repo is queued at most once, a
+// manual request for a repo that is already waiting moves it to the front.
+// Scheduled requests are dropped while the repo is paused or its circuit is
+// open, disabled repos are never queued.
+// The caller must hold s.mu.
+func (s *Scheduler) enqueue(name string, manual bool) {
+	status, exists := s.repos[name]
+	if !exists || status.Disabled {
+		return
+	}
+	if !manual && status.Paused {
+		return
+	}
+	if status.IsRunning {
+		log.Printf("Fetch %s skipped: previous fetch still running", name)
+		return
+	}
+	if job, queued := s.queued[name]; queued {
+		if manual && !job.Manual {
+			job.Manual = true
+			heap.Fix(&s.queue, job.index)
+		}
+		return
+	}
+	if !manual && !allowFetch(status, time.Now()) {
+		return
+	}
+
+	s.seq++
+	job := &Job{Repo: name, Manual: manual, EnqueuedAt: time.Now(), seq: s.seq, done: make(chan struct{})}
+	heap.Push(&s.queue, job)
+	s.queued[name] = job
+	status.IsQueued = true
+	s.publishStatus(EventFetchQueued, status, "")
+	s.dispatch()
+}
+
+// dequeue removes a pending job, it reports whether one was queued.
+// The caller must hold s.mu.
+func (s *Scheduler) dequeue(name string) bool {
+	job, queued := s.queued[name]
+	if !queued {
+		return false
+	}
+	heap.Remove(&s.queue, job.index)
+	delete(s.queued, name)
+	if status, exists := s.repos[name]; exists {
+		status.IsQueued = false
+		s.publishStatus(EventFetchDequeued, status, "")
+	}
+	return true
+}
+
+// dispatch starts queued jobs until every worker slot is taken.
+// The caller must hold s.mu.
+func (s *Scheduler) dispatch() {
+	// Jobs of a repo whose previous fetch is still winding down after a
+	// reload wait, two fetches must never share a local path
+	var blocked []*Job
+	defer func() {
+		for _, job := range blocked {
+			heap.Push(&s.queue, job)
+		}
+	}()
+
+	for s.ctx.Err() == nil && len(s.running) < s.maxConcurrent && s.queue.Len() > 0 {
+		job := heap.Pop(&s.queue).(*Job)
+		if _, busy := s.running[job.Repo]; busy {
+			blocked = append(blocked, job)
+			continue
+		}
+		delete(s.queued, job.Repo)
+
+		status, exists := s.repos[job.Repo]
+		if !exists {
+			continue
+		}
+		status.IsQueued = false
+
+		// A scheduled job that waited into a blackout window is dropped
+		if schedule, ok := s.schedules[job.Repo]; ok && !job.Manual {
+			if end, inBlackout := schedule.InBlackout(time.Now()); inBlackout {
+				message := fmt.Sprintf("skipped: blackout window until %s", end.Format(time.RFC3339))
+				log.Printf("Fetch %s %s", job.Repo, message)
+				s.publishStatus(EventFetchDequeued, status, message)
+				continue
+			}
+		}
+		status.IsRunning = true
+		job.StartedAt = time.Now()
+		s.running[job.Repo] = job
+
+		s.wg.Add(1)
+		go s.runJob(job, status)
+	}
+}
+
+// runJob executes a job and hands its slot to the next one
+func (s *Scheduler) runJob(job *Job, status *RepoStatus) {
+	defer s.wg.Done()
+
+	s.executeFetch(job.Repo, status)
+
+	s.mu.Lock()
+	if s.running[job.Repo] == job {
+		delete(s.running, job.Repo)
+	}
+	close(job.done)
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// Queue returns the pending and running jobs
+func (s *Scheduler) Queue() QueueStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	qs := QueueStatus{
+		MaxConcurrent: s.maxConcurrent,
+		Pending:       make([]Job, 0, len(s.queue)),
+		Running:       make([]Job, 0, len(s.running)),
+	}
+
+	pending := append(jobQueue(nil), s.queue...)
+	sort.Slice(pending, func(i, j int) bool { return pending.Less(i, j) })
+	for _, job := range pending {
+		qs.Pending = append(qs.Pending, *job)
+	}
+
+	for _, job := range s.running {
+		qs.Running = append(qs.Running, *job)
+	}
+	sort.Slice(qs.Running, func(i, j int) bool {
+		return qs.Running[i].StartedAt.Before(qs.Running[j].StartedAt)
+	})
+	return qs
+}
@@ -1,12 +1,19 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
 	"colosscious.com/gitfetcher/config"
 	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/redmine"
+	"colosscious.com/gitfetcher/storage"
 )
 
 type RepoStatus struct {
@@ -14,126 +21,563 @@ type RepoStatus struct {
 	URL          string
 	LocalPath    string
 	Interval     string
+	Backend      string
+	Source       string // the source that discovered the repo, empty for repos of the config file
 	LastFetch    time.Time
 	LastResult   string
 	LastSuccess  bool
+	LastStatus   string
+	LastChanges  []fetcher.RefChange
+	LastSummary  string
+	LastAttempts int
+	LastDuration time.Duration
 	NextFetch    time.Time
+	IsQueued     bool
 	IsRunning    bool
+	Progress     string // latest git progress output of the running fetch
 	FetchCount   int
 	SuccessCount int
 	FailCount    int
+
+	// LastSuccessAt is when the last successful fetch finished, it survives
+	// restarts and config changes so staleness can be alerted on
+	LastSuccessAt time.Time
+
+	// Circuit breaker, NextProbe is only set while the circuit is open
+	CircuitState        string
+	ConsecutiveFailures int
+	NextProbe           time.Time
+
+	// Disabled repos have enabled: false and are never fetched
+	Disabled bool
+	// Paused repos skip scheduled fetches, until PausedUntil unless it is zero
+	Paused      bool
+	PausedUntil time.Time
+	PausedBy    string
+	PauseReason string
+
+	// Outcome of the last Redmine changeset import, LastImport is zero until
+	// one ran
+	LastImport        time.Time
+	LastImportSuccess bool
+	LastImportError   string
+
+	// Outcome of the last push to every push mirror, in config order
+	PushMirrors []PushMirrorStatus
+
+	durations  *durationHistogram
+	progressAt time.Time
 }
 
+// PushMirrorStatus is the outcome of the last push to a push mirror
+type PushMirrorStatus struct {
+	Name          string
+	URL           string
+	LastPush      time.Time // zero until pushed
+	LastSuccess   bool
+	LastStatus    string
+	LastError     string
+	LastSHA       string // HEAD commit of the last successful push
+	LastSuccessAt time.Time
+}
+
+// ErrNotRunning is returned by Cancel when no fetch is in flight for the repo
+var ErrNotRunning = errors.New("no fetch is running")
+
+// ErrUnknownRepo is returned for a repo that is not in the loaded config
+var ErrUnknownRepo = errors.New("repository not found")
+
 type Scheduler struct {
-	fetcher    *fetcher.GitFetcher
-	repos      map[string]*RepoStatus
-	stopChans  map[string]chan bool
-	mu         sync.RWMutex
-	wg         sync.WaitGroup
+	fetcher   fetcher.Fetcher
+	backends  map[string]fetcher.Fetcher
+	repos     map[string]*RepoStatus
+	configs   map[string]config.RepoConfig
+	schedules map[string]*config.Schedule
+	stopChans map[string]chan bool
+	loops     map[chan bool]bool // stop channels of the scheduling goroutines still running
+	cancels   map[string]context.CancelCauseFunc
+	ctx       context.Context // parent of every fetch, cancelled by Stop
+	stop      context.CancelCauseFunc
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+
+	// Work queue, at most maxConcurrent jobs run at once
+	queue         jobQueue
+	queued        map[string]*Job
+	running       map[string]*Job
+	maxConcurrent int
+	seq           uint64
+
+	// Optional history store, persisted holds next fetch times loaded on startup
+	store     *storage.DB
+	persisted map[string]time.Time
+
+	// Finish time of the last successful fetch of every repo seen so far
+	lastSuccess map[string]time.Time
+
+	// Paused repos, they stay paused across reloads until resumed or expired
+	paused      map[string]storage.Pause
+	pauseTimers map[string]*time.Timer
+
+	// Last config passed to LoadConfig and the applied one, which adds the
+	// discovered repos to it. Both are nil until LoadConfig.
+	base *config.Config
+	cfg  *config.Config
+
+	// Discovery loops of the configured sources, by name
+	sources map[string]*sourceState
+
+	// Imports changesets into Redmine after a fetch, nil without redmine.url
+	redmine *redmine.Client
+
+	events *broker
 }
 
-func NewScheduler(gf *fetcher.GitFetcher) *Scheduler {
+// NewScheduler creates a scheduler that uses f for every repo whose backend
+// has not been registered with RegisterBackend
+func NewScheduler(f fetcher.Fetcher) *Scheduler {
+	ctx, stop := context.WithCancelCause(context.Background())
 	return &Scheduler{
-		fetcher:   gf,
+		fetcher:   f,
+		backends:  make(map[string]fetcher.Fetcher),
 		repos:     make(map[string]*RepoStatus),
+		configs:   make(map[string]config.RepoConfig),
+		schedules: make(map[string]*config.Schedule),
 		stopChans: make(map[string]chan bool),
+		loops:     make(map[chan bool]bool),
+		cancels:   make(map[string]context.CancelCauseFunc),
+		ctx:       ctx,
+		stop:      stop,
+
+		queued:        make(map[string]*Job),
+		running:       make(map[string]*Job),
+		maxConcurrent: config.DefaultMaxConcurrentFetches,
+
+		lastSuccess: make(map[string]time.Time),
+		paused:      make(map[string]storage.Pause),
+		pauseTimers: make(map[string]*time.Timer),
+		sources:     make(map[string]*sourceState),
+		events:      newBroker(),
 	}
 }
 
-// LoadConfig loads repositories from config and starts schedulers
-func (s *Scheduler) LoadConfig(cfg *config.Config) {
+// RegisterBackend makes f available to repos configured with the given backend name
+func (s *Scheduler) RegisterBackend(name string, f fetcher.Fetcher) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.backends[name] = f
+}
 
-	// Stop all existing schedulers
-	for name, stopChan := range s.stopChans {
-		close(stopChan)
-		delete(s.stopChans, name)
+// storeTimeout bounds every history write so a slow database cannot stall fetches
+const storeTimeout = 5 * time.Second
+
+// SetStore records every run in db and persists next fetch times and pauses.
+// Call it before the first LoadConfig so repos resume their schedule after a restart.
+func (s *Scheduler) SetStore(db *storage.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	persisted, err := db.NextFetches(ctx)
+	if err != nil {
+		return err
 	}
+	lastSuccess, err := db.LastSuccesses(ctx)
+	if err != nil {
+		return err
+	}
+	pauses, err := db.Pauses(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = db
+	s.persisted = persisted
+	for name, t := range lastSuccess {
+		if t.After(s.lastSuccess[name]) {
+			s.lastSuccess[name] = t
+		}
+	}
+	for name, p := range pauses {
+		if !p.Until.IsZero() && !p.Until.After(time.Now()) {
+			if err := db.DeletePause(ctx, name); err != nil {
+				log.Printf("Failed to delete pause of %s: %v", name, err)
+			}
+			continue
+		}
+		s.setPause(p)
+	}
+	return nil
+}
+
+// fetcherFor returns the fetcher for a backend, falling back to the default one
+func (s *Scheduler) fetcherFor(backend string) fetcher.Fetcher {
+	if f, ok := s.backends[backend]; ok {
+		return f
+	}
+	return s.fetcher
+}
+
+// LoadConfig applies cfg by diffing it against the loaded repositories. Added
+// repos are started, removed ones stopped and changed ones restarted with a
+// fresh status, unchanged repos keep running with their counters and history.
+// Repos discovered from the sources of cfg are added as they are listed.
+func (s *Scheduler) LoadConfig(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Clear old repos
-	s.repos = make(map[string]*RepoStatus)
+	s.base = cfg
+	s.loadSources(cfg.Sources)
+	summary := s.applyConfig()
+	log.Print(summary)
+	s.events.publish(Event{Type: EventConfigReloaded, Time: time.Now(), Message: summary})
+}
 
-	// Start new schedulers
+// applyConfig applies the loaded config and the discovered repos, see
+// LoadConfig, and returns a summary of the changes. The caller must hold s.mu.
+func (s *Scheduler) applyConfig() string {
+	cfg := s.withDiscovered(s.base)
+	wanted := make(map[string]config.RepoConfig, len(cfg.Repos))
 	for _, repo := range cfg.Repos {
-		interval, _ := repo.ParseInterval()
+		wanted[repo.Name] = cfg.ResolveRepo(repo)
+	}
 
-		status := &RepoStatus{
-			Name:      repo.Name,
-			URL:       repo.URL,
-			LocalPath: repo.LocalPath,
-			Interval:  repo.Interval,
-			NextFetch: time.Now(),
+	var removed, changed, unchanged int
+	for name, old := range s.configs {
+		repo, keep := wanted[name]
+		switch {
+		case !keep:
+			cause := errors.New("repository removed from config")
+			if old.Source != "" {
+				cause = fmt.Errorf("repository retired by source %s", old.Source)
+			}
+			s.stopRepo(name, cause)
+			if _, paused := s.paused[name]; paused {
+				// A repo added again later starts unpaused
+				s.clearPause(name)
+				s.forgetPause(name)
+			}
+			removed++
+		case !reflect.DeepEqual(old, repo):
+			s.stopRepo(name, errors.New("repository config changed"))
+			changed++
+		default:
+			unchanged++
 		}
+	}
 
-		s.repos[repo.Name] = status
-		stopChan := make(chan bool)
-		s.stopChans[repo.Name] = stopChan
+	s.maxConcurrent = cfg.EffectiveMaxConcurrentFetches()
+	s.cfg = cfg
+	s.redmine = nil
+	if cfg.Redmine.ImportsChangesets() {
+		s.redmine = redmine.NewClient(*cfg.Redmine)
+	}
 
-		s.wg.Add(1)
-		go s.runScheduler(repo.Name, repo.LocalPath, interval, stopChan)
+	// Start new and changed repos in config order
+	for _, repo := range cfg.Repos {
+		if _, running := s.repos[repo.Name]; !running {
+			s.startRepo(wanted[repo.Name])
+		}
 	}
+	s.dispatch()
 
-	log.Printf("Loaded %d repositories", len(cfg.Repos))
+	return fmt.Sprintf("Loaded %d repositories (%d added, %d changed, %d removed, %d unchanged)",
+		len(s.repos), len(s.repos)-changed-unchanged, changed, removed, unchanged)
 }
 
-// runScheduler is the main loop for each repository
-func (s *Scheduler) runScheduler(name, localPath string, interval time.Duration, stopChan chan bool) {
-	defer s.wg.Done()
+// startRepo registers a repo with a fresh status and starts its scheduler.
+// The caller must hold s.mu.
+func (s *Scheduler) startRepo(repo config.RepoConfig) {
+	status := &RepoStatus{
+		Name:         repo.Name,
+		URL:          repo.URL,
+		LocalPath:    repo.LocalPath,
+		Interval:     repo.Interval,
+		Backend:      repo.Backend,
+		Source:       repo.Source,
+		NextFetch:    time.Now(),
+		CircuitState: CircuitClosed,
+
+		LastSuccessAt: s.lastSuccess[repo.Name],
+		Disabled:      !repo.IsEnabled(),
+		durations:     newDurationHistogram(),
+	}
+	for _, m := range repo.PushMirrors {
+		status.PushMirrors = append(status.PushMirrors, PushMirrorStatus{Name: m.Name, URL: m.URL})
+	}
+	s.applyPause(status)
+	s.repos[repo.Name] = status
+	s.configs[repo.Name] = repo
+
+	if status.Disabled {
+		log.Printf("Not scheduling %s: disabled in config", repo.Name)
+		status.NextFetch = time.Time{}
+		return
+	}
+
+	schedule, err := repo.ParseSchedule()
+	if err != nil {
+		log.Printf("Not scheduling %s: invalid interval '%s': %v", repo.Name, repo.Interval, err)
+		status.NextFetch = time.Time{}
+		return
+	}
+	s.schedules[repo.Name] = schedule
+	stopChan := make(chan bool)
+	s.stopChans[repo.Name] = stopChan
+	s.loops[stopChan] = true
+
+	s.wg.Add(1)
+	go s.runScheduler(status, schedule, stopChan)
+}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// stopRepo stops the scheduler of a repo, drops its pending job and cancels
+// its running fetch with cause. The caller must hold s.mu.
+func (s *Scheduler) stopRepo(name string, cause error) {
+	if stopChan, ok := s.stopChans[name]; ok {
+		close(stopChan)
+		delete(s.stopChans, name)
+	}
+	s.dequeue(name)
+	if cancel, ok := s.cancels[name]; ok {
+		cancel(cause)
+		delete(s.cancels, name)
+	}
+	delete(s.repos, name)
+	delete(s.configs, name)
+	delete(s.schedules, name)
+}
 
-	// Run immediately on start
-	s.executeFetch(name, localPath)
+// runScheduler is the main loop for each repository, it queues a fetch at
+// every time the schedule yields
+func (s *Scheduler) runScheduler(status *RepoStatus, schedule *config.Schedule, stopChan chan bool) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.loops, stopChan)
+		s.mu.Unlock()
+	}()
+	name := status.Name
 
+	// Interval schedules run immediately on start, unless a previous run of
+	// gitfetcher already planned a later fetch
+	next := schedule.First(time.Now())
+	if persisted, ok := s.takePersisted(name); ok && persisted.After(time.Now()) {
+		next = persisted
+	}
 	for {
+		s.setNextFetch(status, next)
+		s.saveNextFetch(name, next)
+		timer := time.NewTimer(time.Until(next))
+
 		select {
-		case <-ticker.C:
-			s.executeFetch(name, localPath)
+		case <-timer.C:
+			s.mu.Lock()
+			if s.repos[name] == status {
+				s.enqueue(name, false)
+			}
+			s.mu.Unlock()
+			next = schedule.Next(time.Now())
 		case <-stopChan:
+			timer.Stop()
 			log.Printf("Stopping scheduler for %s", name)
 			return
 		}
 	}
 }
 
-// executeFetch runs git fetch and updates status
-func (s *Scheduler) executeFetch(name, localPath string) {
+// takePersisted returns the next fetch time saved for name, once
+func (s *Scheduler) takePersisted(name string) (time.Time, bool) {
 	s.mu.Lock()
-	status, exists := s.repos[name]
-	if !exists {
+	defer s.mu.Unlock()
+	next, ok := s.persisted[name]
+	delete(s.persisted, name)
+	return next, ok
+}
+
+// saveNextFetch persists the next fetch time of name if a store is set
+func (s *Scheduler) saveNextFetch(name string, next time.Time) {
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := store.SaveNextFetch(ctx, name, next); err != nil {
+		log.Printf("Failed to save next fetch of %s: %v", name, err)
+	}
+}
+
+// setNextFetch records the next scheduled fetch, or the next probe if the
+// circuit stays open past it
+func (s *Scheduler) setNextFetch(status *RepoStatus, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status.NextFetch = next
+	if status.CircuitState == CircuitOpen && status.NextProbe.After(next) {
+		status.NextFetch = status.NextProbe
+	}
+}
+
+// requestFetch queues a fetch of name
+func (s *Scheduler) requestFetch(name string, manual bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enqueue(name, manual)
+}
+
+// executeFetch runs git fetch for a dispatched job, retrying failed attempts
+// with backoff, and updates status
+func (s *Scheduler) executeFetch(name string, status *RepoStatus) {
+	s.mu.Lock()
+	repo := s.configs[name]
+	if s.repos[name] != status {
+		// The repo was removed or reloaded while the job was queued
+		status.IsRunning = false
 		s.mu.Unlock()
 		return
 	}
-	status.IsRunning = true
-	url := status.URL
+	f := s.fetcherFor(repo.Backend)
+
+	// A half-open circuit gets a single probe attempt
+	attempts := 1
+	if repo.Retry != nil && status.CircuitState != CircuitHalfOpen {
+		attempts = max(repo.Retry.MaxAttempts, 1)
+	}
+
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	s.cancels[name] = cancel
+	store := s.store
+	importer := s.redmine
+	status.Progress = ""
+	s.publishStatus(EventFetchStarted, status, "")
 	s.mu.Unlock()
 
-	log.Printf("Fetching %s...", name)
-	result := s.fetcher.Fetch(name, url, localPath)
+	ctx = fetcher.WithProgress(ctx, func(line string) {
+		s.reportProgress(status, line)
+	})
+
+	startedAt := time.Now()
+	var result *fetcher.FetchResult
+	attempt := 1
+	for ; ; attempt++ {
+		log.Printf("Fetching %s (attempt %d/%d)...", name, attempt, attempts)
+		result = fetchOnce(ctx, f, repo)
+		if result.Success || attempt >= attempts || !retryable(result) {
+			break
+		}
+
+		initial, maxDelay := repo.Retry.Backoff()
+		delay := backoff(attempt, initial, maxDelay)
+		log.Printf("Fetch %s attempt %d failed: %s, retrying in %s", name, attempt, result.Message, delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			result = &fetcher.FetchResult{
+				RepoName:  name,
+				Status:    fetcher.StatusCanceled,
+				Message:   fmt.Sprintf("retry aborted: %v", context.Cause(ctx)),
+				Timestamp: time.Now(),
+			}
+			break
+		}
+	}
+	duration := time.Since(startedAt)
+
+	// Push mirrors are pushed when refs changed, and until every push
+	// succeeded once. A failed import is retried after the next successful
+	// fetch, even without new changes. Both run under the context of the
+	// fetch, so they are cancelled with it and the repo shows as running
+	// until they are done.
+	s.mu.RLock()
+	pushMirrors := result.Success && len(repo.PushMirrors) > 0 &&
+		(len(result.Changes) > 0 || !allPushed(status.PushMirrors))
+	importChanges := result.Success && repo.RedmineProject != "" && importer != nil &&
+		(len(result.Changes) > 0 || (!status.LastImport.IsZero() && !status.LastImportSuccess))
+	s.mu.RUnlock()
+	if pushMirrors {
+		pusher, _ := f.(fetcher.Pusher)
+		s.pushMirrors(ctx, status, pusher, repo)
+	}
+	if importChanges {
+		s.importChangesets(ctx, status, importer, repo.RedmineProject)
+	}
+	cancel(nil)
 
 	s.mu.Lock()
+	if s.repos[name] == status {
+		delete(s.cancels, name)
+	}
 	status.IsRunning = false
+	status.Progress = ""
 	status.LastFetch = result.Timestamp
 	status.LastResult = result.Message
 	status.LastSuccess = result.Success
+	status.LastStatus = result.Status
+	status.LastChanges = result.Changes
+	status.LastSummary = fetcher.SummarizeChanges(result.Changes)
+	status.LastAttempts = attempt
+	status.LastDuration = duration
 	status.FetchCount++
+	status.durations.observe(status.LastDuration)
 
 	if result.Success {
 		status.SuccessCount++
+		status.LastSuccessAt = result.Timestamp
+		s.lastSuccess[name] = result.Timestamp
 	} else {
 		status.FailCount++
 	}
 
-	// Calculate next fetch time
-	if repoConfig, ok := s.getRepoConfig(name); ok {
-		if interval, err := repoConfig.ParseInterval(); err == nil {
-			status.NextFetch = time.Now().Add(interval)
+	// A cancelled run says nothing about the health of the remote
+	if result.Status != fetcher.StatusCanceled {
+		wasOpen := status.CircuitState == CircuitOpen
+		recordRun(status, repo.CircuitBreaker, result.Success, time.Now())
+		if status.CircuitState == CircuitOpen && !wasOpen {
+			log.Printf("Circuit for %s opened after %d consecutive failures, next probe at %s",
+				name, status.ConsecutiveFailures, status.NextProbe.Format(time.RFC3339))
 		}
 	}
+
+	if status.CircuitState == CircuitOpen && status.NextProbe.After(status.NextFetch) {
+		status.NextFetch = status.NextProbe
+	}
+	if result.Success {
+		s.publishStatus(EventFetchFinished, status, result.Message)
+	} else {
+		s.publishStatus(EventFetchFailed, status, result.Message)
+	}
+	if len(result.Backups) > 0 {
+		s.publishBackups(status, result.Backups)
+	}
+
+	record := storage.Record{
+		Repo:       name,
+		Status:     result.Status,
+		Success:    result.Success,
+		Message:    result.Message,
+		Changes:    result.Changes,
+		Attempts:   attempt,
+		StartedAt:  startedAt,
+		DurationMS: status.LastDuration.Milliseconds(),
+	}
 	s.mu.Unlock()
 
+	if store != nil {
+		storeCtx, storeCancel := context.WithTimeout(context.Background(), storeTimeout)
+		if _, err := store.RecordFetch(storeCtx, record); err != nil {
+			log.Printf("Failed to record fetch of %s: %v", name, err)
+		}
+		storeCancel()
+	}
+
 	if result.Success {
 		log.Printf("Fetch %s completed: %s", name, result.Message)
 	} else {
@@ -141,20 +585,112 @@ func (s *Scheduler) executeFetch(name, localPath string) {
 	}
 }
 
-// getRepoConfig is a helper to get interval from current config
-func (s *Scheduler) getRepoConfig(name string) (*config.RepoConfig, bool) {
-	status, exists := s.repos[name]
-	if !exists {
-		return nil, false
+// allPushed reports whether the last push to every mirror succeeded
+func allPushed(mirrors []PushMirrorStatus) bool {
+	for _, m := range mirrors {
+		if !m.LastSuccess {
+			return false
+		}
 	}
+	return true
+}
+
+// pushMirrors pushes a fetched repo to each of its push mirrors in turn and
+// records the outcomes in its status. Like imports, pushes run in the worker
+// slot and under the context of the fetch, each bounded by the repo timeout.
+func (s *Scheduler) pushMirrors(ctx context.Context, status *RepoStatus, pusher fetcher.Pusher, repo config.RepoConfig) {
+	for _, mirror := range repo.PushMirrors {
+		var result *fetcher.PushResult
+		if pusher == nil {
+			result = &fetcher.PushResult{
+				Mirror:    mirror.Name,
+				Status:    fetcher.StatusFailed,
+				Message:   fmt.Sprintf("backend %s does not support push mirrors", repo.Backend),
+				Timestamp: time.Now(),
+			}
+		} else {
+			result = pushOnce(ctx, pusher, repo, mirror)
+		}
+
+		s.mu.Lock()
+		// Replace rather than update the slice, snapshots taken by GetStatus share it
+		mirrors := append([]PushMirrorStatus(nil), status.PushMirrors...)
+		for i := range mirrors {
+			if mirrors[i].Name != mirror.Name {
+				continue
+			}
+			m := &mirrors[i]
+			m.LastPush = result.Timestamp
+			m.LastSuccess = result.Success
+			m.LastStatus = result.Status
+			m.LastError = ""
+			if result.Success {
+				m.LastSHA = result.SHA
+				m.LastSuccessAt = result.Timestamp
+			} else {
+				m.LastError = result.Message
+			}
+		}
+		status.PushMirrors = mirrors
+		if s.repos[status.Name] == status {
+			s.publishStatus(EventPushMirrored, status, mirror.Name+": "+result.Message)
+		}
+		s.mu.Unlock()
+
+		if result.Success {
+			log.Printf("Pushed %s to %s: %s", repo.Name, mirror.Name, result.Message)
+		} else {
+			log.Printf("Push of %s to %s failed: %s", repo.Name, mirror.Name, result.Message)
+		}
+	}
+}
+
+// pushOnce runs a single push bounded by the repo timeout
+func pushOnce(ctx context.Context, p fetcher.Pusher, repo config.RepoConfig, mirror config.PushMirror) *fetcher.PushResult {
+	if timeout, err := repo.ParseTimeout(); err == nil && timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("timed out after %s", timeout))
+		defer stop()
+	}
+	return p.Push(ctx, repo, mirror)
+}
+
+// importChangesets asks Redmine to import the new changesets of a fetched
+// repo and records the outcome in its status. It runs in the worker slot and
+// under the context of the fetch, so imports are bounded by
+// max_concurrent_fetches too and cancelled with the fetch.
+func (s *Scheduler) importChangesets(ctx context.Context, status *RepoStatus, client *redmine.Client, project string) {
+	err := client.FetchChangesets(ctx, project)
+
+	s.mu.Lock()
+	status.LastImport = time.Now()
+	status.LastImportSuccess = err == nil
+	status.LastImportError = ""
+	message := "changesets imported into Redmine project " + project
+	if err != nil {
+		status.LastImportError = err.Error()
+		message = err.Error()
+	}
+	if s.repos[status.Name] == status {
+		s.publishStatus(EventRedmineImport, status, message)
+	}
+	s.mu.Unlock()
 
-	// Return a temporary config object for interval parsing
-	return &config.RepoConfig{
-		Name:      status.Name,
-		Interval:  status.Interval,
-		LocalPath: status.LocalPath,
-		URL:       status.URL,
-	}, true
+	if err != nil {
+		log.Printf("Redmine import of %s failed: %v", status.Name, err)
+	} else {
+		log.Printf("Redmine import of %s: %s", status.Name, message)
+	}
+}
+
+// fetchOnce runs a single attempt bounded by the repo timeout
+func fetchOnce(ctx context.Context, f fetcher.Fetcher, repo config.RepoConfig) *fetcher.FetchResult {
+	if timeout, err := repo.ParseTimeout(); err == nil && timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("timed out after %s", timeout))
+		defer stop()
+	}
+	return f.Fetch(ctx, repo)
 }
 
 // GetStatus returns current status of all repositories
@@ -171,29 +707,153 @@ func (s *Scheduler) GetStatus() map[string]*RepoStatus {
 	return result
 }
 
-// ManualFetch triggers an immediate fetch for a specific repository
+// Config returns the last config passed to LoadConfig with the discovered
+// repos added, nil before the first one. The returned config must not be
+// modified.
+func (s *Scheduler) Config() *config.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Health is a liveness snapshot of the scheduler
+type Health struct {
+	Stopped    bool     // Stop was called
+	Responsive bool     // the scheduler lock was acquired in time
+	Scheduled  int      // repos with a valid schedule
+	Loops      int      // scheduling goroutines running
+	Missing    []string // scheduled repos whose goroutine is gone
+}
+
+// Alive reports whether the scheduler is running and every scheduled repo has its goroutine
+func (h Health) Alive() bool {
+	return !h.Stopped && h.Responsive && len(h.Missing) == 0
+}
+
+// Health checks the scheduler without blocking for longer than timeout, a
+// scheduler stuck holding its lock is reported as unresponsive
+func (s *Scheduler) Health(timeout time.Duration) Health {
+	done := make(chan Health, 1)
+	go func() {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		h := Health{Responsive: true, Scheduled: len(s.stopChans), Loops: len(s.loops)}
+		for name, stopChan := range s.stopChans {
+			if !s.loops[stopChan] {
+				h.Missing = append(h.Missing, name)
+			}
+		}
+		sort.Strings(h.Missing)
+		done <- h
+	}()
+
+	var h Health
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case h = <-done:
+	case <-timer.C:
+	}
+	h.Stopped = s.ctx.Err() != nil
+	return h
+}
+
+// FetchDurations returns the fetch duration histogram of every repository
+// since it was (re)started
+func (s *Scheduler) FetchDurations() map[string]Histogram {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]Histogram, len(s.repos))
+	for name, status := range s.repos {
+		result[name] = status.durations.snapshot()
+	}
+	return result
+}
+
+// ManualFetch triggers an immediate fetch for a specific repository, paused
+// repos are fetched too but disabled ones are not
 func (s *Scheduler) ManualFetch(name string) error {
 	s.mu.RLock()
 	status, exists := s.repos[name]
-	if !exists {
-		s.mu.RUnlock()
-		return nil
-	}
-	localPath := status.LocalPath
+	disabled := exists && status.Disabled
 	s.mu.RUnlock()
+	if disabled {
+		return ErrRepoDisabled
+	}
 
-	go s.executeFetch(name, localPath)
+	s.requestFetch(name, true)
 	return nil
 }
 
-// Stop gracefully stops all schedulers
+// Cancel aborts the in-flight fetch of a repository, or drops it from the queue
+func (s *Scheduler) Cancel(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.repos[name]; !exists {
+		return ErrUnknownRepo
+	}
+	if s.dequeue(name) {
+		return nil
+	}
+	cancel, running := s.cancels[name]
+	if !running {
+		return ErrNotRunning
+	}
+	cancel(errors.New("cancelled by user"))
+	delete(s.cancels, name)
+	return nil
+}
+
+// RemoveRepo unloads a repo that was removed from the config without waiting
+// for the reload, cancels its fetch and waits until the fetch has released the
+// repo, so nothing writes into its local path any more. It returns ctx's error
+// if ctx ends first.
+func (s *Scheduler) RemoveRepo(ctx context.Context, name string) error {
+	s.mu.Lock()
+	if s.base != nil {
+		base := *s.base
+		base.Repos = nil
+		for _, repo := range s.base.Repos {
+			if repo.Name != name {
+				base.Repos = append(base.Repos, repo)
+			}
+		}
+		s.base = &base
+		log.Print(s.applyConfig())
+	}
+	job := s.running[name]
+	s.mu.Unlock()
+
+	if job == nil {
+		return nil
+	}
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// Stop gracefully stops all schedulers, in-flight fetches are cancelled
 func (s *Scheduler) Stop() {
+	s.stop(errors.New("scheduler stopped"))
+
 	s.mu.Lock()
 	for _, stopChan := range s.stopChans {
 		close(stopChan)
 	}
+	for _, timer := range s.pauseTimers {
+		timer.Stop()
+	}
+	for name := range s.queued {
+		s.dequeue(name)
+	}
 	s.mu.Unlock()
 
 	s.wg.Wait()
+	s.events.close()
 	log.Println("All schedulers stopped")
 }
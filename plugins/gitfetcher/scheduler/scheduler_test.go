@@ -1,17 +1,29 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"colosscious.com/gitfetcher/config"
 	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/storage"
 )
 
-// mockFetcher is a mock implementation of GitFetcher for testing
+// mockFetcher is a mock implementation of fetcher.Fetcher for testing
 type mockFetcher struct {
+	mu         sync.Mutex
 	fetchCalls []string
 	results    map[string]*fetcher.FetchResult
+	hang       bool // block every fetch until its context is done
+	failFirst  int  // number of fetches that fail before results apply
 }
 
 func newMockFetcher() *mockFetcher {
@@ -21,23 +33,65 @@ func newMockFetcher() *mockFetcher {
 	}
 }
 
-func (m *mockFetcher) Fetch(name, localPath string) *fetcher.FetchResult {
-	m.fetchCalls = append(m.fetchCalls, name)
+func (m *mockFetcher) Clone(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	return m.Fetch(ctx, repo)
+}
+
+func (m *mockFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	m.mu.Lock()
+	m.fetchCalls = append(m.fetchCalls, repo.Name)
+	hang := m.hang
+	m.mu.Unlock()
+
+	if hang {
+		<-ctx.Done()
+		status := fetcher.StatusCanceled
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = fetcher.StatusTimeout
+		}
+		return &fetcher.FetchResult{
+			RepoName:  repo.Name,
+			Status:    status,
+			Message:   context.Cause(ctx).Error(),
+			Timestamp: time.Now(),
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failFirst > 0 {
+		m.failFirst--
+		return &fetcher.FetchResult{
+			RepoName:  repo.Name,
+			Status:    fetcher.StatusFailed,
+			Message:   "connection reset",
+			Timestamp: time.Now(),
+		}
+	}
 
-	if result, ok := m.results[name]; ok {
+	if result, ok := m.results[repo.Name]; ok {
 		return result
 	}
 
 	// Default success result
 	return &fetcher.FetchResult{
-		RepoName:  name,
+		RepoName:  repo.Name,
 		Success:   true,
 		Message:   "Mock fetch successful",
 		Timestamp: time.Now(),
 	}
 }
 
+func (m *mockFetcher) calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.fetchCalls...)
+}
+
 func (m *mockFetcher) setResult(name string, success bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.results[name] = &fetcher.FetchResult{
 		RepoName:  name,
 		Success:   success,
@@ -176,6 +230,122 @@ func TestLoadConfigMultipleTimes(t *testing.T) {
 	s.Stop()
 }
 
+// reloadConfig builds a config with repo1 and repo2, repo2 uses interval2
+func reloadConfig(interval2 string) *config.Config {
+	return &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "repo1",
+				URL:       "git@github.com:user/repo1.git",
+				LocalPath: "/repos/repo1.git",
+				Interval:  "1h",
+			},
+			{
+				Name:      "repo2",
+				URL:       "git@github.com:user/repo2.git",
+				LocalPath: "/repos/repo2.git",
+				Interval:  interval2,
+			},
+		},
+		HTTPPort: 8080,
+	}
+}
+
+func TestLoadConfigPreservesUnchangedRepos(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	s.LoadConfig(reloadConfig("1h"))
+	time.Sleep(50 * time.Millisecond)
+	s.ManualFetch("repo1")
+	time.Sleep(50 * time.Millisecond)
+
+	before := s.GetStatus()["repo1"]
+	if before.FetchCount != 2 {
+		t.Fatalf("Expected 2 fetches of repo1, got %d", before.FetchCount)
+	}
+
+	// Reloading the same config is a no-op
+	s.LoadConfig(reloadConfig("1h"))
+	time.Sleep(50 * time.Millisecond)
+	if len(mock.calls()) != 3 {
+		t.Errorf("Expected no fetches after an identical reload, got %v", mock.calls())
+	}
+
+	// Changing repo2 only restarts repo2
+	s.LoadConfig(reloadConfig("2h"))
+	time.Sleep(50 * time.Millisecond)
+
+	status := s.GetStatus()
+	if status["repo1"].FetchCount != 2 || !status["repo1"].LastFetch.Equal(before.LastFetch) {
+		t.Errorf("Expected repo1 to keep its counters and history, got %+v", status["repo1"])
+	}
+	if status["repo2"].Interval != "2h" || status["repo2"].FetchCount != 1 {
+		t.Errorf("Expected repo2 to restart with the new interval, got %+v", status["repo2"])
+	}
+	if calls := mock.calls(); len(calls) != 4 || calls[3] != "repo2" {
+		t.Errorf("Expected only repo2 to be fetched again, got %v", calls)
+	}
+}
+
+func TestLoadConfigGlobalChangeRestartsRepos(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	s.LoadConfig(reloadConfig("1h"))
+	time.Sleep(50 * time.Millisecond)
+
+	// Global settings are part of every resolved repo config
+	cfg := reloadConfig("1h")
+	cfg.FetchTimeout = "5m"
+	s.LoadConfig(cfg)
+	time.Sleep(50 * time.Millisecond)
+
+	if len(mock.calls()) != 4 {
+		t.Errorf("Expected both repos to restart, got %v", mock.calls())
+	}
+}
+
+func TestLoadConfigCancelsStaleFetches(t *testing.T) {
+	s, mock := hangingScheduler("1h")
+	defer s.Stop()
+
+	// Changing the repo cancels the fetch that uses the old config
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/moved.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+				Timeout:   "1h",
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	status := s.GetStatus()["test-repo"]
+	if status.URL != "git@github.com:user/moved.git" || !status.IsRunning {
+		t.Errorf("Expected the new config to be fetching, got %+v", status)
+	}
+	if len(mock.calls()) != 2 {
+		t.Errorf("Expected the old fetch to be replaced, got %v", mock.calls())
+	}
+	if queue := s.Queue(); len(queue.Running) != 1 {
+		t.Errorf("Expected 1 running job, got %+v", queue.Running)
+	}
+
+	// Removing the repo cancels its fetch
+	s.LoadConfig(&config.Config{HTTPPort: 8080})
+	time.Sleep(50 * time.Millisecond)
+	if queue := s.Queue(); len(queue.Running) != 0 || len(queue.Pending) != 0 {
+		t.Errorf("Expected an empty queue, got %+v", queue)
+	}
+}
+
 func TestGetStatus(t *testing.T) {
 	gf := fetcher.NewGitFetcher("", "")
 	s := NewScheduler(gf)
@@ -197,6 +367,8 @@ func TestGetStatus(t *testing.T) {
 			},
 		},
 		HTTPPort: 8080,
+		// The real fetcher fails here, do not wait for retries
+		Retry: &config.RetryConfig{MaxAttempts: 1},
 	}
 	s.LoadConfig(cfg)
 
@@ -239,6 +411,8 @@ func TestManualFetch(t *testing.T) {
 			},
 		},
 		HTTPPort: 8080,
+		// The real fetcher fails here, do not wait for retries
+		Retry: &config.RetryConfig{MaxAttempts: 1},
 	}
 	s.LoadConfig(cfg)
 
@@ -333,6 +507,8 @@ func TestRepoStatusStatistics(t *testing.T) {
 			},
 		},
 		HTTPPort: 8080,
+		// The real fetcher fails here, do not wait for retries
+		Retry: &config.RetryConfig{MaxAttempts: 1},
 	}
 	s.LoadConfig(cfg)
 
@@ -424,3 +600,1438 @@ func TestConcurrentGetStatus(t *testing.T) {
 	s.Stop()
 	// If we got here without race conditions, test passes
 }
+
+func TestBackendSelection(t *testing.T) {
+	cli := newMockFetcher()
+	goGit := newMockFetcher()
+
+	s := NewScheduler(cli)
+	s.RegisterBackend(config.BackendGoGit, goGit)
+
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "default-repo",
+				URL:       "git@github.com:user/default.git",
+				LocalPath: "/repos/default.git",
+				Interval:  "1h",
+			},
+			{
+				Name:      "gogit-repo",
+				URL:       "git@github.com:user/gogit.git",
+				LocalPath: "/repos/gogit.git",
+				Interval:  "1h",
+				Backend:   config.BackendGoGit,
+			},
+		},
+		HTTPPort: 8080,
+	}
+	s.LoadConfig(cfg)
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	if calls := cli.calls(); len(calls) != 1 || calls[0] != "default-repo" {
+		t.Errorf("Expected default backend to fetch only default-repo, got %v", calls)
+	}
+	if calls := goGit.calls(); len(calls) != 1 || calls[0] != "gogit-repo" {
+		t.Errorf("Expected go-git backend to fetch only gogit-repo, got %v", calls)
+	}
+
+	status := s.GetStatus()
+	if status["gogit-repo"].Backend != config.BackendGoGit {
+		t.Errorf("Expected Backend '%s', got '%s'", config.BackendGoGit, status["gogit-repo"].Backend)
+	}
+	if status["default-repo"].Backend != config.BackendCLI {
+		t.Errorf("Expected Backend '%s', got '%s'", config.BackendCLI, status["default-repo"].Backend)
+	}
+}
+
+func TestGlobalBackend(t *testing.T) {
+	cli := newMockFetcher()
+	goGit := newMockFetcher()
+
+	s := NewScheduler(cli)
+	s.RegisterBackend(config.BackendGoGit, goGit)
+
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+		Backend:  config.BackendGoGit,
+	}
+	s.LoadConfig(cfg)
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	if len(cli.calls()) != 0 {
+		t.Errorf("Expected no calls on default backend, got %v", cli.calls())
+	}
+	if len(goGit.calls()) != 1 {
+		t.Errorf("Expected 1 call on go-git backend, got %v", goGit.calls())
+	}
+}
+
+func TestLastStatus(t *testing.T) {
+	mock := newMockFetcher()
+	mock.mu.Lock()
+	mock.results["test-repo"] = &fetcher.FetchResult{
+		RepoName:  "test-repo",
+		Success:   false,
+		Status:    fetcher.StatusHostKeyChanged,
+		Message:   "host key changed",
+		Timestamp: time.Now(),
+	}
+	mock.mu.Unlock()
+
+	s := NewScheduler(mock)
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	status := s.GetStatus()["test-repo"]
+	if status.LastStatus != fetcher.StatusHostKeyChanged {
+		t.Errorf("Expected LastStatus '%s', got '%s'", fetcher.StatusHostKeyChanged, status.LastStatus)
+	}
+}
+
+func TestLastChanges(t *testing.T) {
+	mock := newMockFetcher()
+	mock.mu.Lock()
+	mock.results["test-repo"] = &fetcher.FetchResult{
+		RepoName: "test-repo",
+		Success:  true,
+		Status:   fetcher.StatusSuccess,
+		Changes: []fetcher.RefChange{
+			{Ref: "refs/heads/main", Type: fetcher.RefUpdated, OldSHA: "aaa", NewSHA: "bbb"},
+			{Ref: "refs/heads/dev", Type: fetcher.RefUpdated, OldSHA: "ccc", NewSHA: "ddd", Forced: true},
+		},
+		Timestamp: time.Now(),
+	}
+	mock.mu.Unlock()
+
+	s := NewScheduler(mock)
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	status := s.GetStatus()["test-repo"]
+	if len(status.LastChanges) != 2 {
+		t.Errorf("Expected 2 ref changes, got %d", len(status.LastChanges))
+	}
+	if status.LastSummary != "2 branches updated, 1 force-pushed" {
+		t.Errorf("Expected summary '2 branches updated, 1 force-pushed', got '%s'", status.LastSummary)
+	}
+}
+
+// hangingScheduler loads a single repo whose fetches block until cancelled
+func hangingScheduler(timeout string) (*Scheduler, *mockFetcher) {
+	mock := newMockFetcher()
+	mock.hang = true
+
+	s := NewScheduler(mock)
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+				Timeout:   timeout,
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(50 * time.Millisecond)
+	return s, mock
+}
+
+// stubbornFetcher finishes a fetch only once released, even when it was cancelled
+type stubbornFetcher struct {
+	*mockFetcher
+	release chan struct{}
+}
+
+func (f *stubbornFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	<-f.release
+	return f.mockFetcher.Fetch(ctx, repo)
+}
+
+func TestRemoveRepoWaitsForFetch(t *testing.T) {
+	f := &stubbornFetcher{mockFetcher: newMockFetcher(), release: make(chan struct{})}
+	s := NewScheduler(f)
+	defer s.Stop()
+	s.LoadConfig(pauseConfig("1h"))
+	time.Sleep(50 * time.Millisecond)
+	if !s.GetStatus()["test-repo"].IsRunning {
+		t.Fatal("Expected fetch to be running")
+	}
+
+	removed := make(chan error, 1)
+	go func() { removed <- s.RemoveRepo(context.Background(), "test-repo") }()
+	select {
+	case err := <-removed:
+		t.Fatalf("Expected RemoveRepo to wait for the fetch, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, exists := s.GetStatus()["test-repo"]; exists {
+		t.Error("Expected the repo to be unloaded right away")
+	}
+
+	close(f.release)
+	select {
+	case err := <-removed:
+		if err != nil {
+			t.Errorf("RemoveRepo() failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected RemoveRepo to return once the fetch finished")
+	}
+	if queue := s.Queue(); len(queue.Running) != 0 {
+		t.Errorf("Expected no running job, got %+v", queue.Running)
+	}
+
+	// Until the config is reloaded the applied one no longer holds the repo
+	if cfg := s.Config(); cfg.FindRepo("test-repo") >= 0 {
+		t.Errorf("Expected the repo to be removed from the applied config, got %+v", cfg.Repos)
+	}
+	if err := s.RemoveRepo(context.Background(), "test-repo"); err != nil {
+		t.Errorf("Expected removing an unloaded repo to succeed, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, _ := hangingScheduler("1h")
+	defer s.Stop()
+
+	if !s.GetStatus()["test-repo"].IsRunning {
+		t.Fatal("Expected fetch to be running")
+	}
+
+	if err := s.Cancel("test-repo"); err != nil {
+		t.Fatalf("Cancel() failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	status := s.GetStatus()["test-repo"]
+	if status.IsRunning {
+		t.Error("Expected fetch to stop after Cancel")
+	}
+	if status.LastStatus != fetcher.StatusCanceled {
+		t.Errorf("Expected LastStatus '%s', got '%s'", fetcher.StatusCanceled, status.LastStatus)
+	}
+	if status.LastResult != "cancelled by user" {
+		t.Errorf("Expected LastResult 'cancelled by user', got '%s'", status.LastResult)
+	}
+
+	if err := s.Cancel("test-repo"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+	if err := s.Cancel("nonexistent"); !errors.Is(err, ErrUnknownRepo) {
+		t.Errorf("Expected ErrUnknownRepo, got %v", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	s, _ := hangingScheduler("100ms")
+	defer s.Stop()
+
+	time.Sleep(200 * time.Millisecond)
+
+	status := s.GetStatus()["test-repo"]
+	if status.IsRunning {
+		t.Error("Expected fetch to stop after its timeout")
+	}
+	if status.LastStatus != fetcher.StatusTimeout {
+		t.Errorf("Expected LastStatus '%s', got '%s'", fetcher.StatusTimeout, status.LastStatus)
+	}
+	if status.LastResult != "timed out after 100ms" {
+		t.Errorf("Expected LastResult 'timed out after 100ms', got '%s'", status.LastResult)
+	}
+}
+
+func TestStopCancelsFetches(t *testing.T) {
+	s, mock := hangingScheduler("1h")
+
+	done := make(chan bool)
+	go func() {
+		s.Stop()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop() did not cancel the running fetch")
+	}
+
+	if len(mock.calls()) != 1 {
+		t.Errorf("Expected 1 fetch call, got %v", mock.calls())
+	}
+}
+
+func TestManualFetchSkipsRunning(t *testing.T) {
+	s, mock := hangingScheduler("1h")
+	defer s.Stop()
+
+	s.ManualFetch("test-repo")
+	time.Sleep(50 * time.Millisecond)
+
+	if len(mock.calls()) != 1 {
+		t.Errorf("Expected a running fetch to not be started twice, got %v", mock.calls())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	initial, max := 100*time.Millisecond, time.Second
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := backoff(tt.attempt, initial, max)
+			if d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d) = %s, expected between %s and %s", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+
+	if d := backoff(1, 0, max); d != 0 {
+		t.Errorf("Expected no delay without initial backoff, got %s", d)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &config.CircuitBreakerConfig{FailureThreshold: 2, ProbeInterval: "1m"}
+	status := &RepoStatus{CircuitState: CircuitClosed}
+	now := time.Now()
+
+	recordRun(status, cb, false, now)
+	if status.CircuitState != CircuitClosed {
+		t.Fatalf("Expected circuit to stay closed after 1 failure, got '%s'", status.CircuitState)
+	}
+	recordRun(status, cb, false, now)
+	if status.CircuitState != CircuitOpen {
+		t.Fatalf("Expected circuit to open after 2 failures, got '%s'", status.CircuitState)
+	}
+	if !status.NextProbe.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected next probe at %s, got %s", now.Add(time.Minute), status.NextProbe)
+	}
+
+	if allowFetch(status, now.Add(30*time.Second)) {
+		t.Error("Expected fetch to be blocked before the probe is due")
+	}
+	if !allowFetch(status, now.Add(time.Minute)) {
+		t.Fatal("Expected probe to be allowed once due")
+	}
+	if status.CircuitState != CircuitHalfOpen {
+		t.Fatalf("Expected half-open circuit, got '%s'", status.CircuitState)
+	}
+
+	// A failed probe re-opens the circuit right away
+	later := now.Add(time.Minute)
+	recordRun(status, cb, false, later)
+	if status.CircuitState != CircuitOpen || !status.NextProbe.Equal(later.Add(time.Minute)) {
+		t.Errorf("Expected re-opened circuit probing at %s, got '%s' at %s",
+			later.Add(time.Minute), status.CircuitState, status.NextProbe)
+	}
+
+	allowFetch(status, later.Add(time.Minute))
+	recordRun(status, cb, true, later.Add(time.Minute))
+	if status.CircuitState != CircuitClosed || status.ConsecutiveFailures != 0 || !status.NextProbe.IsZero() {
+		t.Errorf("Expected closed and reset circuit after success, got %+v", status)
+	}
+
+	// Disabled breakers only count
+	disabled := &RepoStatus{CircuitState: CircuitClosed}
+	for i := 0; i < 5; i++ {
+		recordRun(disabled, &config.CircuitBreakerConfig{FailureThreshold: 1, Disabled: true}, false, now)
+	}
+	if disabled.CircuitState != CircuitClosed || disabled.ConsecutiveFailures != 5 {
+		t.Errorf("Expected disabled breaker to stay closed with 5 failures, got '%s' with %d",
+			disabled.CircuitState, disabled.ConsecutiveFailures)
+	}
+}
+
+// retryConfig loads a single repo with fast retries
+func retryConfig(attempts, threshold int) *config.Config {
+	return &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+		Retry: &config.RetryConfig{
+			MaxAttempts:    attempts,
+			InitialBackoff: "10ms",
+			MaxBackoff:     "20ms",
+		},
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			FailureThreshold: threshold,
+			ProbeInterval:    "2h",
+		},
+	}
+}
+
+func TestRetry(t *testing.T) {
+	mock := newMockFetcher()
+	mock.failFirst = 2
+
+	s := NewScheduler(mock)
+	s.LoadConfig(retryConfig(3, 5))
+	time.Sleep(200 * time.Millisecond)
+	s.Stop()
+
+	status := s.GetStatus()["test-repo"]
+	if len(mock.calls()) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", len(mock.calls()))
+	}
+	if !status.LastSuccess {
+		t.Errorf("Expected the third attempt to succeed, got '%s'", status.LastResult)
+	}
+	if status.LastAttempts != 3 {
+		t.Errorf("Expected LastAttempts 3, got %d", status.LastAttempts)
+	}
+	if status.FetchCount != 1 || status.FailCount != 0 {
+		t.Errorf("Expected retries to count as one successful run, got %d runs and %d failures",
+			status.FetchCount, status.FailCount)
+	}
+}
+
+func TestRetrySkipsPermanentFailures(t *testing.T) {
+	mock := newMockFetcher()
+	mock.results["test-repo"] = &fetcher.FetchResult{
+		RepoName:  "test-repo",
+		Status:    fetcher.StatusHostKeyChanged,
+		Message:   "host key changed",
+		Timestamp: time.Now(),
+	}
+
+	s := NewScheduler(mock)
+	s.LoadConfig(retryConfig(3, 5))
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	if len(mock.calls()) != 1 {
+		t.Errorf("Expected host key failures to not be retried, got %d attempts", len(mock.calls()))
+	}
+}
+
+func TestCircuitOpensAndManualFetchCloses(t *testing.T) {
+	mock := newMockFetcher()
+	mock.failFirst = 2
+
+	s := NewScheduler(mock)
+	defer s.Stop()
+	s.LoadConfig(retryConfig(2, 1))
+	time.Sleep(100 * time.Millisecond)
+
+	status := s.GetStatus()["test-repo"]
+	if status.CircuitState != CircuitOpen {
+		t.Fatalf("Expected open circuit, got '%s'", status.CircuitState)
+	}
+	if status.ConsecutiveFailures != 1 {
+		t.Errorf("Expected 1 consecutive failure, got %d", status.ConsecutiveFailures)
+	}
+	if !status.NextFetch.Equal(status.NextProbe) {
+		t.Errorf("Expected next fetch to wait for the probe at %s, got %s", status.NextProbe, status.NextFetch)
+	}
+
+	// Scheduled runs are skipped while open
+	s.requestFetch("test-repo", false)
+	if len(mock.calls()) != 2 {
+		t.Errorf("Expected scheduled fetch to be skipped, got %d calls", len(mock.calls()))
+	}
+
+	s.ManualFetch("test-repo")
+	time.Sleep(50 * time.Millisecond)
+
+	status = s.GetStatus()["test-repo"]
+	if status.CircuitState != CircuitClosed {
+		t.Errorf("Expected successful manual fetch to close the circuit, got '%s'", status.CircuitState)
+	}
+}
+
+// queuedScheduler loads n hanging repos with at most maxConcurrent running
+func queuedScheduler(n, maxConcurrent int) (*Scheduler, *mockFetcher) {
+	mock := newMockFetcher()
+	mock.hang = true
+
+	cfg := &config.Config{HTTPPort: 8080, MaxConcurrentFetches: maxConcurrent}
+	for i := 1; i <= n; i++ {
+		cfg.Repos = append(cfg.Repos, config.RepoConfig{
+			Name:      fmt.Sprintf("repo%d", i),
+			URL:       fmt.Sprintf("git@github.com:user/repo%d.git", i),
+			LocalPath: fmt.Sprintf("/repos/repo%d.git", i),
+			Interval:  "1h",
+		})
+	}
+
+	s := NewScheduler(mock)
+	s.LoadConfig(cfg)
+	time.Sleep(50 * time.Millisecond)
+	return s, mock
+}
+
+func TestMaxConcurrentFetches(t *testing.T) {
+	s, mock := queuedScheduler(5, 2)
+	defer s.Stop()
+
+	if len(mock.calls()) != 2 {
+		t.Fatalf("Expected 2 concurrent fetches, got %d", len(mock.calls()))
+	}
+
+	queue := s.Queue()
+	if queue.MaxConcurrent != 2 {
+		t.Errorf("Expected MaxConcurrent 2, got %d", queue.MaxConcurrent)
+	}
+	if len(queue.Running) != 2 || len(queue.Pending) != 3 {
+		t.Fatalf("Expected 2 running and 3 pending jobs, got %d and %d", len(queue.Running), len(queue.Pending))
+	}
+
+	running := queue.Running[0].Repo
+	if !s.GetStatus()[running].IsRunning {
+		t.Errorf("Expected %s to be running", running)
+	}
+	pending := queue.Pending[0].Repo
+	if status := s.GetStatus()[pending]; !status.IsQueued || status.IsRunning {
+		t.Errorf("Expected %s to be queued, got %+v", pending, status)
+	}
+
+	// Finishing a fetch frees its slot for the next job
+	s.Cancel(running)
+	time.Sleep(50 * time.Millisecond)
+	if len(mock.calls()) != 3 {
+		t.Errorf("Expected the next job to start, got %d fetches", len(mock.calls()))
+	}
+	if queue := s.Queue(); len(queue.Running) != 2 || len(queue.Pending) != 2 {
+		t.Errorf("Expected 2 running and 2 pending jobs, got %d and %d", len(queue.Running), len(queue.Pending))
+	}
+}
+
+func TestManualFetchJumpsQueue(t *testing.T) {
+	s, _ := queuedScheduler(4, 1)
+	defer s.Stop()
+
+	queue := s.Queue()
+	if len(queue.Pending) != 3 {
+		t.Fatalf("Expected 3 pending jobs, got %d", len(queue.Pending))
+	}
+	last := queue.Pending[2].Repo
+
+	s.ManualFetch(last)
+	queue = s.Queue()
+	if len(queue.Pending) != 3 {
+		t.Fatalf("Expected a queued repo to not be queued twice, got %d pending jobs", len(queue.Pending))
+	}
+	if queue.Pending[0].Repo != last || !queue.Pending[0].Manual {
+		t.Errorf("Expected manual fetch of %s to be first, got %+v", last, queue.Pending[0])
+	}
+	if queue.Pending[1].Manual || queue.Pending[1].EnqueuedAt.After(queue.Pending[2].EnqueuedAt) {
+		t.Errorf("Expected scheduled jobs to stay in FIFO order, got %+v", queue.Pending[1:])
+	}
+
+	s.Cancel(queue.Running[0].Repo)
+	time.Sleep(50 * time.Millisecond)
+	if queue := s.Queue(); len(queue.Running) != 1 || queue.Running[0].Repo != last {
+		t.Errorf("Expected %s to run next, got %+v", last, queue.Running)
+	}
+}
+
+func TestCancelQueued(t *testing.T) {
+	s, mock := queuedScheduler(2, 1)
+	defer s.Stop()
+
+	pending := s.Queue().Pending[0].Repo
+	if err := s.Cancel(pending); err != nil {
+		t.Fatalf("Cancel() failed: %v", err)
+	}
+
+	if queue := s.Queue(); len(queue.Pending) != 0 {
+		t.Errorf("Expected empty queue after Cancel, got %+v", queue.Pending)
+	}
+	if s.GetStatus()[pending].IsQueued {
+		t.Error("Expected IsQueued to be cleared")
+	}
+	if len(mock.calls()) != 1 {
+		t.Errorf("Expected the cancelled job to never run, got %v", mock.calls())
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "0 0 1 1 *",
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	if len(mock.calls()) != 0 {
+		t.Errorf("Expected cron schedule to wait for its first match, got %v", mock.calls())
+	}
+	next := s.GetStatus()["test-repo"].NextFetch
+	if next.Month() != time.January || next.Day() != 1 || next.Hour() != 0 || next.Minute() != 0 {
+		t.Errorf("Expected next fetch on January 1st at midnight, got %s", next)
+	}
+}
+
+func TestBlackoutWindowSkipsScheduledFetches(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "100ms",
+			},
+		},
+		HTTPPort: 8080,
+		// A window opening every minute for an hour covers all of the test
+		BlackoutWindows: []config.BlackoutWindow{{Start: "* * * * *", Duration: "1h"}},
+	})
+	time.Sleep(250 * time.Millisecond)
+
+	if len(mock.calls()) != 0 {
+		t.Errorf("Expected no scheduled fetch during the blackout, got %v", mock.calls())
+	}
+	if next := s.GetStatus()["test-repo"].NextFetch; next.Before(time.Now().Add(time.Hour - time.Minute)) {
+		t.Errorf("Expected next fetch after the blackout, got %s", next)
+	}
+
+	// Manual fetches are not affected
+	s.ManualFetch("test-repo")
+	time.Sleep(50 * time.Millisecond)
+	if len(mock.calls()) != 1 {
+		t.Errorf("Expected manual fetch to run during the blackout, got %v", mock.calls())
+	}
+}
+
+func TestStoreRecordsRunsAndResumesSchedule(t *testing.T) {
+	db, err := storage.Open(config.StorageConfig{
+		Driver: config.StorageSQLite,
+		Path:   filepath.Join(t.TempDir(), "gitfetcher.db"),
+	})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer db.Close()
+
+	// A fetch planned before a restart is kept instead of fetching right away
+	planned := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+	if err := db.SaveNextFetch(context.Background(), "test-repo", planned); err != nil {
+		t.Fatalf("SaveNextFetch() failed: %v", err)
+	}
+
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+	if err := s.SetStore(db); err != nil {
+		t.Fatalf("SetStore() failed: %v", err)
+	}
+
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	if len(mock.calls()) != 0 {
+		t.Errorf("Expected persisted next fetch to be honored, got %v", mock.calls())
+	}
+	if next := s.GetStatus()["test-repo"].NextFetch; !next.Equal(planned) {
+		t.Errorf("Expected next fetch at %s, got %s", planned, next)
+	}
+
+	s.ManualFetch("test-repo")
+	time.Sleep(50 * time.Millisecond)
+
+	records, total, err := db.History(context.Background(), "test-repo", 10, 0)
+	if err != nil {
+		t.Fatalf("History() failed: %v", err)
+	}
+	if total != 1 || !records[0].Success || records[0].Attempts != 1 {
+		t.Errorf("Expected one successful run in the history, got %+v", records)
+	}
+}
+
+func TestFetchDurations(t *testing.T) {
+	h := newDurationHistogram()
+	h.observe(200 * time.Millisecond)
+	h.observe(3 * time.Second)
+	h.observe(2 * time.Hour)
+
+	snap := h.snapshot()
+	if snap.Count != 3 {
+		t.Errorf("Expected 3 observations, got %d", snap.Count)
+	}
+	if snap.Buckets[0.5] != 1 || snap.Buckets[5] != 2 || snap.Buckets[3600] != 2 {
+		t.Errorf("Expected cumulative buckets, got %v", snap.Buckets)
+	}
+	if snap.Sum < 7203 || snap.Sum > 7204 {
+		t.Errorf("Expected sum of 7203.2s, got %f", snap.Sum)
+	}
+}
+
+func TestLastSuccessAt(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+		Retry:    &config.RetryConfig{MaxAttempts: 1},
+	}
+	s.LoadConfig(cfg)
+	time.Sleep(50 * time.Millisecond)
+
+	last := s.GetStatus()["test-repo"].LastSuccessAt
+	if last.IsZero() {
+		t.Fatal("Expected LastSuccessAt to be set after a successful fetch")
+	}
+	if h := s.FetchDurations()["test-repo"]; h.Count != 1 {
+		t.Errorf("Expected 1 observed duration, got %d", h.Count)
+	}
+
+	// Failures keep the last success, a config change does not forget it
+	mock.setResult("test-repo", false, "connection reset")
+	s.ManualFetch("test-repo")
+	time.Sleep(50 * time.Millisecond)
+	if got := s.GetStatus()["test-repo"].LastSuccessAt; !got.Equal(last) {
+		t.Errorf("Expected LastSuccessAt to stay %s after a failure, got %s", last, got)
+	}
+
+	cfg.Repos[0].Interval = "2h"
+	s.LoadConfig(cfg)
+	if got := s.GetStatus()["test-repo"].LastSuccessAt; !got.Equal(last) {
+		t.Errorf("Expected LastSuccessAt to survive a config change, got %s", got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := NewScheduler(newMockFetcher())
+
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: "/repos/repo1.git", Interval: "1h"},
+			{Name: "broken", URL: "git@github.com:user/broken.git", LocalPath: "/repos/broken.git", Interval: "whenever"},
+		},
+		HTTPPort: 8080,
+	})
+	if s.Config() == nil {
+		t.Fatal("Expected Config() to return the loaded config")
+	}
+
+	h := s.Health(time.Second)
+	if !h.Alive() || h.Scheduled != 1 || h.Loops != 1 {
+		t.Errorf("Expected a live scheduler with 1 scheduled repo, got %+v", h)
+	}
+
+	// A scheduler stuck holding its lock is not alive
+	s.mu.Lock()
+	h = s.Health(50 * time.Millisecond)
+	s.mu.Unlock()
+	if h.Responsive || h.Alive() {
+		t.Errorf("Expected an unresponsive scheduler, got %+v", h)
+	}
+
+	s.Stop()
+	if h := s.Health(time.Second); !h.Stopped || h.Alive() || h.Loops != 0 {
+		t.Errorf("Expected a stopped scheduler, got %+v", h)
+	}
+}
+
+// nextEvent waits for the next event of one of types, skipping others
+func nextEvent(t *testing.T, events <-chan Event, types ...string) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("Event channel closed")
+			}
+			for _, typ := range types {
+				if e.Type == typ {
+					return e
+				}
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %v", types)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "test-repo", URL: "git@github.com:user/test.git", LocalPath: "/repos/test.git", Interval: "1h"},
+		},
+		HTTPPort: 8080,
+		Retry:    &config.RetryConfig{MaxAttempts: 1},
+	})
+
+	if e := nextEvent(t, events, EventConfigReloaded); !strings.Contains(e.Message, "1 added") {
+		t.Errorf("Expected reload summary, got %q", e.Message)
+	}
+	if e := nextEvent(t, events, EventFetchQueued); e.Repo != "test-repo" || !e.Status.IsQueued {
+		t.Errorf("Expected test-repo to be queued, got %+v", e)
+	}
+	if e := nextEvent(t, events, EventFetchStarted); !e.Status.IsRunning {
+		t.Errorf("Expected running status in started event, got %+v", e.Status)
+	}
+	if e := nextEvent(t, events, EventFetchFinished); e.Status.IsRunning || e.Status.SuccessCount != 1 {
+		t.Errorf("Expected finished status with 1 success, got %+v", e.Status)
+	}
+
+	mock.setResult("test-repo", false, "connection reset")
+	s.ManualFetch("test-repo")
+	if e := nextEvent(t, events, EventFetchFailed); e.Message != "connection reset" {
+		t.Errorf("Expected failure message, got %q", e.Message)
+	}
+
+	s.Stop()
+	for range events {
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	s := NewScheduler(newMockFetcher())
+	defer s.Stop()
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= eventBuffer; i++ {
+		s.events.publish(Event{Type: EventConfigReloaded})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != eventBuffer {
+		t.Errorf("Expected %d buffered events before the channel closed, got %d", eventBuffer, n)
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	mock := newMockFetcher()
+	mock.hang = true
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	s.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "test-repo", URL: "git@github.com:user/test.git", LocalPath: "/repos/test.git", Interval: "1h"},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	s.mu.RLock()
+	status := s.repos["test-repo"]
+	s.mu.RUnlock()
+	s.reportProgress(status, "Receiving objects:  10% (1/10)")
+	s.reportProgress(status, "Receiving objects:  20% (2/10)") // throttled
+
+	if e := nextEvent(t, events, EventFetchProgress); e.Message != "Receiving objects:  10% (1/10)" {
+		t.Errorf("Expected first progress line, got %q", e.Message)
+	}
+	if got := s.GetStatus()["test-repo"].Progress; got != "Receiving objects:  10% (1/10)" {
+		t.Errorf("Expected Progress to hold the reported line, got %q", got)
+	}
+
+	s.Cancel("test-repo")
+	if e := nextEvent(t, events, EventFetchFailed); e.Status.Progress != "" {
+		t.Errorf("Expected Progress to be cleared, got %q", e.Status.Progress)
+	}
+}
+
+func pauseConfig(interval string) *config.Config {
+	return &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  interval,
+			},
+		},
+		HTTPPort: 8080,
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	if _, err := s.Pause("missing", time.Time{}, "alice", ""); !errors.Is(err, ErrUnknownRepo) {
+		t.Errorf("Expected ErrUnknownRepo, got %v", err)
+	}
+
+	s.LoadConfig(pauseConfig("100ms"))
+	time.Sleep(50 * time.Millisecond)
+	if _, err := s.Pause("test-repo", time.Time{}, "alice", "upstream migration"); err != nil {
+		t.Fatalf("Pause() failed: %v", err)
+	}
+	before := len(mock.calls())
+	time.Sleep(250 * time.Millisecond)
+	if calls := len(mock.calls()); calls != before {
+		t.Errorf("Expected no scheduled fetch while paused, got %d", calls-before)
+	}
+
+	status := s.GetStatus()["test-repo"]
+	if !status.Paused || status.PausedBy != "alice" || status.PauseReason != "upstream migration" || !status.PausedUntil.IsZero() {
+		t.Errorf("Expected an indefinite pause by alice, got %+v", status)
+	}
+
+	// The pause survives a reload that changes the repo
+	s.LoadConfig(pauseConfig("150ms"))
+	if !s.GetStatus()["test-repo"].Paused {
+		t.Error("Expected the pause to survive a config change")
+	}
+
+	// Manual fetches still run
+	s.ManualFetch("test-repo")
+	time.Sleep(50 * time.Millisecond)
+	if calls := len(mock.calls()); calls != before+1 {
+		t.Errorf("Expected the manual fetch to run while paused, got %d", calls-before)
+	}
+
+	if err := s.Resume("test-repo"); err != nil {
+		t.Fatalf("Resume() failed: %v", err)
+	}
+	if err := s.Resume("test-repo"); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("Expected ErrNotPaused, got %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if calls := len(mock.calls()); calls <= before+1 {
+		t.Error("Expected scheduled fetches after resuming")
+	}
+}
+
+func TestPauseExpires(t *testing.T) {
+	s := NewScheduler(newMockFetcher())
+	defer s.Stop()
+	s.LoadConfig(pauseConfig("1h"))
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	if _, err := s.Pause("test-repo", time.Now().Add(100*time.Millisecond), "alice", ""); err != nil {
+		t.Fatalf("Pause() failed: %v", err)
+	}
+	nextEvent(t, events, EventRepoPaused)
+	event := nextEvent(t, events, EventRepoResumed)
+	if event.Status.Paused || s.GetStatus()["test-repo"].Paused {
+		t.Error("Expected the repo to be resumed once the pause expired")
+	}
+}
+
+func TestPausePersisted(t *testing.T) {
+	db, err := storage.Open(config.StorageConfig{
+		Driver: config.StorageSQLite,
+		Path:   filepath.Join(t.TempDir(), "gitfetcher.db"),
+	})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer db.Close()
+
+	s := NewScheduler(newMockFetcher())
+	if err := s.SetStore(db); err != nil {
+		t.Fatalf("SetStore() failed: %v", err)
+	}
+	s.LoadConfig(pauseConfig("1h"))
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if _, err := s.Pause("test-repo", until, "alice", "outage"); err != nil {
+		t.Fatalf("Pause() failed: %v", err)
+	}
+	s.Stop()
+
+	// A restarted scheduler picks the pause up again
+	restarted := NewScheduler(newMockFetcher())
+	defer restarted.Stop()
+	if err := restarted.SetStore(db); err != nil {
+		t.Fatalf("SetStore() failed: %v", err)
+	}
+	restarted.LoadConfig(pauseConfig("1h"))
+	status := restarted.GetStatus()["test-repo"]
+	if !status.Paused || !status.PausedUntil.Equal(until) || status.PauseReason != "outage" {
+		t.Errorf("Expected the pause to be restored, got %+v", status)
+	}
+
+	// Removing the repo from config forgets its pause
+	restarted.LoadConfig(&config.Config{Repos: []config.RepoConfig{{Name: "other", URL: "u", LocalPath: "/repos/other.git", Interval: "1h"}}, HTTPPort: 8080})
+	restarted.LoadConfig(pauseConfig("1h"))
+	if restarted.GetStatus()["test-repo"].Paused {
+		t.Error("Expected a repo added again to start unpaused")
+	}
+}
+
+func TestDisabledRepo(t *testing.T) {
+	mock := newMockFetcher()
+	s := NewScheduler(mock)
+	defer s.Stop()
+
+	cfg := pauseConfig("100ms")
+	enabled := false
+	cfg.Repos[0].Enabled = &enabled
+	s.LoadConfig(cfg)
+	time.Sleep(250 * time.Millisecond)
+
+	if len(mock.calls()) != 0 {
+		t.Errorf("Expected a disabled repo never to be fetched, got %v", mock.calls())
+	}
+	if err := s.ManualFetch("test-repo"); !errors.Is(err, ErrRepoDisabled) {
+		t.Errorf("Expected ErrRepoDisabled, got %v", err)
+	}
+	status := s.GetStatus()["test-repo"]
+	if !status.Disabled || !status.NextFetch.IsZero() {
+		t.Errorf("Expected a disabled status without next fetch, got %+v", status)
+	}
+	if h := s.Health(time.Second); !h.Alive() {
+		t.Errorf("Expected a disabled repo not to fail the health check, got %+v", h)
+	}
+}
+
+func TestRedmineImportAfterChanges(t *testing.T) {
+	var mu sync.Mutex
+	var imports []string
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		imports = append(imports, r.URL.Query().Get("id"))
+		if fail {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	importCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(imports)
+	}
+	t.Setenv("REDMINE_WS_KEY", "ws-key")
+
+	mock := newMockFetcher()
+	setChanges := func(changes []fetcher.RefChange) {
+		mock.mu.Lock()
+		mock.results["test-repo"] = &fetcher.FetchResult{
+			RepoName: "test-repo", Success: true, Status: fetcher.StatusSuccess,
+			Changes: changes, Timestamp: time.Now(),
+		}
+		mock.mu.Unlock()
+	}
+	changed := []fetcher.RefChange{{Ref: "refs/heads/main", Type: fetcher.RefUpdated, OldSHA: "aaa", NewSHA: "bbb"}}
+	setChanges(changed)
+
+	s := NewScheduler(mock)
+	defer s.Stop()
+	cfg := pauseConfig("1h")
+	cfg.Redmine = &config.RedmineConfig{URL: server.URL, KeyEnv: "REDMINE_WS_KEY"}
+	cfg.Repos[0].RedmineProject = "my-project"
+	s.LoadConfig(cfg)
+	time.Sleep(100 * time.Millisecond)
+
+	status := s.GetStatus()["test-repo"]
+	if importCount() != 1 || !status.LastImportSuccess || status.LastImport.IsZero() {
+		t.Fatalf("Expected one successful import, got %d imports, status %+v", importCount(), status)
+	}
+
+	// Fetches without changes do not import
+	setChanges(nil)
+	s.ManualFetch("test-repo")
+	time.Sleep(100 * time.Millisecond)
+	if importCount() != 1 {
+		t.Errorf("Expected no import without changes, got %d", importCount())
+	}
+
+	// A failed import is recorded and retried after the next fetch
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	setChanges(changed)
+	s.ManualFetch("test-repo")
+	time.Sleep(100 * time.Millisecond)
+	status = s.GetStatus()["test-repo"]
+	if status.LastImportSuccess || !strings.Contains(status.LastImportError, "not found") {
+		t.Errorf("Expected a failed import, got %+v", status)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	setChanges(nil)
+	s.ManualFetch("test-repo")
+	time.Sleep(100 * time.Millisecond)
+	if status := s.GetStatus()["test-repo"]; importCount() != 3 || !status.LastImportSuccess || status.LastImportError != "" {
+		t.Errorf("Expected the failed import to be retried, got %d imports, status %+v", importCount(), status)
+	}
+}
+
+func TestCancelRedmineImport(t *testing.T) {
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	t.Setenv("REDMINE_WS_KEY", "ws-key")
+
+	mock := newMockFetcher()
+	mock.results["test-repo"] = &fetcher.FetchResult{
+		RepoName: "test-repo", Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now(),
+		Changes: []fetcher.RefChange{{Ref: "refs/heads/main", Type: fetcher.RefUpdated, OldSHA: "aaa", NewSHA: "bbb"}},
+	}
+	s := NewScheduler(mock)
+	defer s.Stop()
+	cfg := pauseConfig("1h")
+	cfg.Redmine = &config.RedmineConfig{URL: server.URL, KeyEnv: "REDMINE_WS_KEY"}
+	cfg.Repos[0].RedmineProject = "my-project"
+	s.LoadConfig(cfg)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the import to start")
+	}
+	if !s.GetStatus()["test-repo"].IsRunning {
+		t.Error("Expected the repo to be running until the import is done")
+	}
+
+	// The import runs under the context of the fetch
+	if err := s.Cancel("test-repo"); err != nil {
+		t.Fatalf("Cancel() failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status := s.GetStatus()["test-repo"]; status.IsRunning || status.LastImportSuccess || status.LastImportError == "" {
+		t.Errorf("Expected the import to be cancelled, got %+v", status)
+	}
+}
+
+func TestSourceDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	listing := `[
+		{"name": "api", "full_name": "acme/api", "ssh_url": "git@github.com:acme/api.git", "owner": {"login": "acme"}},
+		{"name": "web", "full_name": "acme/web", "ssh_url": "git@github.com:acme/web.git", "owner": {"login": "acme"}},
+		{"name": "old", "full_name": "acme/old", "ssh_url": "git@github.com:acme/old.git", "archived": true, "owner": {"login": "acme"}}
+	]`
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail || r.URL.Path != "/orgs/acme/repos" {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, listing)
+	}))
+	defer server.Close()
+
+	s := NewScheduler(newMockFetcher())
+	defer s.Stop()
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "web", URL: "git@github.com:acme/web.git", LocalPath: "/srv/web.git", Interval: "0 0 1 1 *"},
+		},
+		Sources: []config.SourceConfig{
+			{Name: "acme", Provider: config.SourceGitHub, URL: server.URL, Org: "acme", Interval: "0 0 1 1 *", LocalPath: "/repos/{{.Name}}.git"},
+		},
+		HTTPPort: 8080,
+	}
+	s.LoadConfig(cfg)
+	nextEvent(t, events, EventSourceDiscovered)
+
+	statuses := s.GetStatus()
+	if len(statuses) != 3 || statuses["api"].Source != "acme" || statuses["web"].Source != "" {
+		t.Fatalf("Expected api and old to be discovered next to the configured web, got %v", statuses)
+	}
+	if !statuses["old"].Disabled || statuses["api"].Disabled {
+		t.Error("Expected only the archived repo to be disabled")
+	}
+	if len(s.Config().Repos) != 3 || len(cfg.Repos) != 1 {
+		t.Error("Expected Config() to include the discovered repos without changing the loaded config")
+	}
+	sources := s.Sources()
+	if len(sources) != 1 || !sources[0].LastSuccess || len(sources[0].Skipped) != 1 || sources[0].Skipped[0] != "web" {
+		t.Errorf("Expected web to be skipped in favour of the configured repo, got %+v", sources)
+	}
+
+	// A failed listing retires nothing
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	s.Discover("acme")
+	nextEvent(t, events, EventSourceFailed)
+	if len(s.GetStatus()) != 3 || s.Sources()[0].LastError == "" {
+		t.Error("Expected the repos to be kept and the error recorded after a failed listing")
+	}
+
+	// Deleted repos are retired
+	mu.Lock()
+	fail = false
+	listing = `[{"name": "api", "full_name": "acme/api", "ssh_url": "git@github.com:acme/api.git", "owner": {"login": "acme"}}]`
+	mu.Unlock()
+	s.Discover("acme")
+	e := nextEvent(t, events, EventSourceDiscovered)
+	if _, ok := s.GetStatus()["old"]; ok || !strings.Contains(e.Message, "2 retired") {
+		t.Errorf("Expected old to be retired, got %q", e.Message)
+	}
+
+	if err := s.Discover("other"); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("Expected ErrUnknownSource, got %v", err)
+	}
+
+	// Removing the source retires its repos
+	cfg = &config.Config{Repos: cfg.Repos, HTTPPort: 8080}
+	s.LoadConfig(cfg)
+	if len(s.GetStatus()) != 1 || len(s.Sources()) != 0 {
+		t.Errorf("Expected only the configured repo after removing the source, got %v", s.GetStatus())
+	}
+}
+
+func TestDiscoveredReposAreValidated(t *testing.T) {
+	s := NewScheduler(newMockFetcher())
+	defer s.Stop()
+
+	src := config.SourceConfig{Name: "acme", Provider: config.SourceGitHub, Org: "acme", Interval: "1h"}
+	base := &config.Config{Sources: []config.SourceConfig{src}, HTTPPort: 8080}
+	s.mu.Lock()
+	s.sources["acme"] = &sourceState{cfg: src, repos: []config.RepoConfig{
+		{Name: "api", URL: "git@github.com:acme/api.git", LocalPath: "/repos/acme/api.git", Interval: "1h", Source: "acme"},
+		{Name: "bad", URL: "git@github.com:acme/bad.git", LocalPath: "/repos/acme/bad.git", Interval: "bogus", Source: "acme"},
+	}}
+	cfg := s.withDiscovered(base)
+	skipped := s.sources["acme"].status.Skipped
+	s.mu.Unlock()
+
+	if len(cfg.Repos) != 1 || cfg.Repos[0].Name != "api" || len(skipped) != 1 || skipped[0] != "bad" {
+		t.Errorf("Expected the invalid repo to be skipped, got %+v (skipped %v)", cfg.Repos, skipped)
+	}
+}
+
+// pushFetcher is a mockFetcher that also pushes, failing pushes to the mirrors in fail
+type pushFetcher struct {
+	*mockFetcher
+	pushes   []string
+	fail     map[string]bool
+	hangPush bool // block every push until its context is done
+}
+
+func (p *pushFetcher) Push(ctx context.Context, repo config.RepoConfig, mirror config.PushMirror) *fetcher.PushResult {
+	p.mu.Lock()
+	p.pushes = append(p.pushes, mirror.Name)
+	hang := p.hangPush
+	p.mu.Unlock()
+	if hang {
+		<-ctx.Done()
+		return &fetcher.PushResult{Mirror: mirror.Name, Status: fetcher.StatusCanceled, Message: context.Cause(ctx).Error(), Timestamp: time.Now()}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail[mirror.Name] {
+		return &fetcher.PushResult{Mirror: mirror.Name, Status: fetcher.StatusFailed, Message: "push failed: remote rejected", Timestamp: time.Now()}
+	}
+	return &fetcher.PushResult{Mirror: mirror.Name, Success: true, Status: fetcher.StatusSuccess, SHA: "abc123", Message: "Everything up-to-date", Timestamp: time.Now()}
+}
+
+func (p *pushFetcher) pushCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pushes)
+}
+
+func TestPushMirrors(t *testing.T) {
+	mock := &pushFetcher{mockFetcher: newMockFetcher(), fail: map[string]bool{"nas": true}}
+	changed := &fetcher.FetchResult{
+		RepoName: "test-repo", Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now(),
+		Changes: []fetcher.RefChange{{Ref: "refs/heads/main", Type: fetcher.RefUpdated, OldSHA: "aaa", NewSHA: "abc123"}},
+	}
+	mock.results["test-repo"] = changed
+
+	s := NewScheduler(mock)
+	defer s.Stop()
+	cfg := pauseConfig("1h")
+	cfg.Repos[0].PushMirrors = []config.PushMirror{
+		{Name: "gitea", URL: "git@gitea.internal:mirrors/test.git"},
+		{Name: "nas", URL: "/mnt/nas/test.git"},
+	}
+	s.LoadConfig(cfg)
+	time.Sleep(100 * time.Millisecond)
+
+	mirrors := s.GetStatus()["test-repo"].PushMirrors
+	if mock.pushCount() != 2 || len(mirrors) != 2 {
+		t.Fatalf("Expected a push to both mirrors, got %d pushes, status %+v", mock.pushCount(), mirrors)
+	}
+	if m := mirrors[0]; !m.LastSuccess || m.LastSHA != "abc123" || m.LastSuccessAt.IsZero() {
+		t.Errorf("Expected a successful push to gitea, got %+v", m)
+	}
+	if m := mirrors[1]; m.LastSuccess || m.LastError == "" || m.LastPush.IsZero() || m.LastSHA != "" {
+		t.Errorf("Expected a failed push to nas, got %+v", m)
+	}
+
+	// A failed push is retried after the next fetch, even without new changes
+	mock.mu.Lock()
+	mock.results["test-repo"] = &fetcher.FetchResult{RepoName: "test-repo", Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now()}
+	mock.fail = nil
+	mock.mu.Unlock()
+	s.ManualFetch("test-repo")
+	time.Sleep(100 * time.Millisecond)
+	if mirrors := s.GetStatus()["test-repo"].PushMirrors; mock.pushCount() != 4 || !mirrors[1].LastSuccess || mirrors[1].LastError != "" {
+		t.Errorf("Expected the failed push to be retried, got %d pushes, status %+v", mock.pushCount(), mirrors)
+	}
+
+	// Nothing is pushed once every mirror is up to date and refs did not change
+	s.ManualFetch("test-repo")
+	time.Sleep(100 * time.Millisecond)
+	if mock.pushCount() != 4 {
+		t.Errorf("Expected no push without changes, got %d pushes", mock.pushCount())
+	}
+}
+
+func TestCancelPushMirrors(t *testing.T) {
+	mock := &pushFetcher{mockFetcher: newMockFetcher(), hangPush: true}
+	mock.results["test-repo"] = &fetcher.FetchResult{
+		RepoName: "test-repo", Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now(),
+		Changes: []fetcher.RefChange{{Ref: "refs/heads/main", Type: fetcher.RefUpdated, OldSHA: "aaa", NewSHA: "abc123"}},
+	}
+
+	s := NewScheduler(mock)
+	defer s.Stop()
+	cfg := pauseConfig("1h")
+	cfg.Repos[0].PushMirrors = []config.PushMirror{{Name: "nas", URL: "/mnt/nas/test.git"}}
+	s.LoadConfig(cfg)
+	time.Sleep(100 * time.Millisecond)
+
+	if mock.pushCount() != 1 || !s.GetStatus()["test-repo"].IsRunning {
+		t.Fatalf("Expected the repo to be running while it is pushed, got %d pushes", mock.pushCount())
+	}
+
+	// The push runs under the context of the fetch
+	if err := s.Cancel("test-repo"); err != nil {
+		t.Fatalf("Cancel() failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	status := s.GetStatus()["test-repo"]
+	if m := status.PushMirrors[0]; status.IsRunning || m.LastSuccess || m.LastStatus != fetcher.StatusCanceled {
+		t.Errorf("Expected the push to be cancelled, got %+v", status)
+	}
+}
+
+func TestRefBackupEvents(t *testing.T) {
+	mock := newMockFetcher()
+	mock.results["test-repo"] = &fetcher.FetchResult{
+		RepoName: "test-repo", Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now(),
+		Changes: []fetcher.RefChange{{Ref: "refs/heads/feature", Type: fetcher.RefDeleted, OldSHA: "aaa"}},
+		Backups: []fetcher.Backup{{Ref: "refs/gitfetcher/backup/20261016T120000Z/heads/feature", Original: "refs/heads/feature", SHA: "aaa"}},
+	}
+
+	s := NewScheduler(mock)
+	defer s.Stop()
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	s.LoadConfig(pauseConfig("1h"))
+
+	if e := nextEvent(t, events, EventRefsBackedUp); e.Repo != "test-repo" || !strings.Contains(e.Message, "refs/heads/feature") {
+		t.Errorf("Expected the backup of feature to be reported, got %+v", e)
+	}
+
+	if _, err := s.Backups("missing"); !errors.Is(err, ErrUnknownRepo) {
+		t.Errorf("Expected ErrUnknownRepo, got %v", err)
+	}
+	if _, _, err := s.RestoreBackup("missing", "refs/gitfetcher/backup/x/heads/main", "", "admin"); !errors.Is(err, ErrUnknownRepo) {
+		t.Errorf("Expected ErrUnknownRepo, got %v", err)
+	}
+}
+
+func TestRestoreBackupWhileFetching(t *testing.T) {
+	s, _ := hangingScheduler("1h")
+	defer s.Stop()
+
+	if _, _, err := s.RestoreBackup("test-repo", "refs/gitfetcher/backup/x/heads/main", "", "admin"); !errors.Is(err, ErrFetchRunning) {
+		t.Errorf("Expected ErrFetchRunning, got %v", err)
+	}
+	if status := s.GetStatus()["test-repo"]; status.Paused {
+		t.Errorf("Expected a failed restore not to pause the repo, got %+v", status)
+	}
+}
@@ -0,0 +1,242 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/discovery"
+)
+
+// ErrUnknownSource is returned by Discover for a source that is not in the loaded config
+var ErrUnknownSource = errors.New("source not found")
+
+// discoveryTimeout bounds a single listing of a source
+const discoveryTimeout = 2 * time.Minute
+
+// SourceStatus is the outcome of the discovery runs of a source
+type SourceStatus struct {
+	Name          string
+	Provider      string
+	Owner         string
+	LastDiscovery time.Time // zero until the first listing finished
+	LastSuccess   bool
+	LastError     string
+	NextDiscovery time.Time
+	Repos         []string // discovered repos, including Skipped
+	Skipped       []string // discovered repos that are invalid, or whose name or local path is taken by another repo
+}
+
+// sourceState is the discovery loop of a source. cfg never changes, a
+// changed source gets a new state.
+type sourceState struct {
+	cfg     config.SourceConfig
+	cancel  context.CancelFunc
+	trigger chan struct{}
+	repos   []config.RepoConfig // of the last successful listing
+	status  SourceStatus
+}
+
+// loadSources starts a discovery loop for every new or changed source and
+// stops those of removed ones. A changed source keeps the repos it found
+// until its new loop has listed it again. The caller must hold s.mu.
+func (s *Scheduler) loadSources(sources []config.SourceConfig) {
+	wanted := make(map[string]config.SourceConfig, len(sources))
+	for _, src := range sources {
+		wanted[src.Name] = src
+	}
+	for name, st := range s.sources {
+		if _, keep := wanted[name]; !keep {
+			st.cancel()
+			delete(s.sources, name)
+		}
+	}
+
+	for _, src := range sources {
+		old, running := s.sources[src.Name]
+		if running && reflect.DeepEqual(old.cfg, src) {
+			continue
+		}
+		owner := src.Org
+		if owner == "" {
+			owner = src.User
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		st := &sourceState{
+			cfg:     src,
+			cancel:  cancel,
+			trigger: make(chan struct{}, 1),
+			status:  SourceStatus{Name: src.Name, Provider: src.Provider, Owner: owner},
+		}
+		if running {
+			old.cancel()
+			st.repos = old.repos
+		}
+		s.sources[src.Name] = st
+		s.wg.Add(1)
+		go s.runSource(ctx, st)
+	}
+}
+
+// runSource lists a source right away, then every refresh interval or when
+// Discover is called, until ctx is cancelled
+func (s *Scheduler) runSource(ctx context.Context, st *sourceState) {
+	defer s.wg.Done()
+	refresh, err := st.cfg.ParseRefresh()
+	if err != nil || refresh <= 0 {
+		refresh, _ = time.ParseDuration(config.DefaultSourceRefresh)
+	}
+
+	for {
+		s.discover(ctx, st)
+
+		s.mu.Lock()
+		st.status.NextDiscovery = time.Now().Add(refresh)
+		s.mu.Unlock()
+		timer := time.NewTimer(refresh)
+		select {
+		case <-timer.C:
+		case <-st.trigger:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// discover lists a source and applies the repos it found. A failed listing
+// keeps the repos of the previous one, so an API outage retires nothing.
+func (s *Scheduler) discover(ctx context.Context, st *sourceState) {
+	listCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	repos, err := discovery.Discover(listCtx, st.cfg)
+	cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := st.cfg.Name
+	if ctx.Err() != nil || s.sources[name] != st {
+		// Stopped or replaced by a config reload while listing
+		return
+	}
+
+	st.status.LastDiscovery = time.Now()
+	st.status.LastSuccess = err == nil
+	if err != nil {
+		st.status.LastError = err.Error()
+		log.Printf("Discovery of source %s failed: %v", name, err)
+		s.events.publish(Event{Type: EventSourceFailed, Time: time.Now(), Message: st.status.LastError})
+		return
+	}
+	st.status.LastError = ""
+	if reflect.DeepEqual(st.repos, repos) {
+		return
+	}
+
+	added, retired := diffRepoNames(st.repos, repos)
+	st.repos = repos
+	summary := s.applyConfig()
+	message := fmt.Sprintf("Source %s: %d repositories (%d added, %d retired)", name, len(repos), added, retired)
+	log.Printf("%s. %s", message, summary)
+	s.events.publish(Event{Type: EventSourceDiscovered, Time: time.Now(), Message: message})
+}
+
+// diffRepoNames counts the repos of next missing from prev and the other way round
+func diffRepoNames(prev, next []config.RepoConfig) (added, retired int) {
+	names := make(map[string]bool, len(prev))
+	for _, repo := range prev {
+		names[repo.Name] = true
+	}
+	for _, repo := range next {
+		if !names[repo.Name] {
+			added++
+		}
+		delete(names, repo.Name)
+	}
+	return added, len(names)
+}
+
+// withDiscovered returns base with the discovered repos of its sources
+// appended. Discovered repos are validated like those of the config file,
+// which win over discovered ones with the same name or local path, earlier
+// sources over later ones. The caller must hold s.mu.
+func (s *Scheduler) withDiscovered(base *config.Config) *config.Config {
+	names := make(map[string]bool, len(base.Repos))
+	paths := make(map[string]bool, len(base.Repos))
+	for _, repo := range base.Repos {
+		names[repo.Name] = true
+		paths[filepath.Clean(repo.LocalPath)] = true
+	}
+
+	var discovered []config.RepoConfig
+	for _, src := range base.Sources {
+		st, ok := s.sources[src.Name]
+		if !ok {
+			continue
+		}
+		st.status.Repos = make([]string, 0, len(st.repos))
+		st.status.Skipped = nil
+		for _, repo := range st.repos {
+			st.status.Repos = append(st.status.Repos, repo.Name)
+			if err := base.ValidateRepo(repo); err != nil {
+				st.status.Skipped = append(st.status.Skipped, repo.Name)
+				log.Printf("Source %s: not mirroring %s: %v", src.Name, repo.Name, err)
+				continue
+			}
+			path := filepath.Clean(repo.LocalPath)
+			if names[repo.Name] || paths[path] {
+				st.status.Skipped = append(st.status.Skipped, repo.Name)
+				log.Printf("Source %s: not mirroring %s, its name or local path %s is already used", src.Name, repo.Name, repo.LocalPath)
+				continue
+			}
+			names[repo.Name] = true
+			paths[path] = true
+			discovered = append(discovered, repo)
+		}
+	}
+	if len(discovered) == 0 {
+		return base
+	}
+
+	cfg := *base
+	cfg.Repos = append(append([]config.RepoConfig(nil), base.Repos...), discovered...)
+	return &cfg
+}
+
+// Sources returns the discovery status of every configured source
+func (s *Scheduler) Sources() []SourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]SourceStatus, 0, len(s.sources))
+	for _, st := range s.sources {
+		status := st.status
+		status.Repos = append([]string(nil), st.status.Repos...)
+		status.Skipped = append([]string(nil), st.status.Skipped...)
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Discover lists a source now instead of waiting for its refresh interval
+func (s *Scheduler) Discover(name string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.sources[name]
+	if !ok {
+		return ErrUnknownSource
+	}
+	select {
+	case st.trigger <- struct{}{}:
+	default: // a run is already pending
+	}
+	return nil
+}
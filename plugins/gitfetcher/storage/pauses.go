@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Pause stops scheduled fetches of a repo until Until, or until it is
+// resumed when Until is zero
+type Pause struct {
+	Repo     string    `json:"repo"`
+	PausedAt time.Time `json:"paused_at"`
+	Until    time.Time `json:"until,omitempty"`
+	Actor    string    `json:"actor"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// SavePause stores the pause of a repo, replacing an earlier one
+func (d *DB) SavePause(ctx context.Context, p Pause) error {
+	var until sql.NullTime
+	if !p.Until.IsZero() {
+		until = sql.NullTime{Time: p.Until.UTC(), Valid: true}
+	}
+	_, err := d.db.ExecContext(ctx, d.rebind(fmt.Sprintf(`INSERT INTO %s
+		(repo, paused_at, paused_until, actor, reason) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (repo) DO UPDATE SET paused_at = excluded.paused_at,
+		paused_until = excluded.paused_until, actor = excluded.actor, reason = excluded.reason`,
+		d.table("repo_pauses"))), p.Repo, p.PausedAt.UTC(), until, p.Actor, p.Reason)
+	return err
+}
+
+// DeletePause removes the pause of a repo, if any
+func (d *DB) DeletePause(ctx context.Context, repo string) error {
+	_, err := d.db.ExecContext(ctx, d.rebind(fmt.Sprintf(
+		`DELETE FROM %s WHERE repo = ?`, d.table("repo_pauses"))), repo)
+	return err
+}
+
+// Pauses returns the pause of every paused repo
+func (d *DB) Pauses(ctx context.Context) (map[string]Pause, error) {
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT repo, paused_at, paused_until, actor, reason FROM %s`, d.table("repo_pauses")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := make(map[string]Pause)
+	for rows.Next() {
+		var p Pause
+		var until sql.NullTime
+		if err := rows.Scan(&p.Repo, &p.PausedAt, &until, &p.Actor, &p.Reason); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			p.Until = until.Time
+		}
+		pauses[p.Repo] = p
+	}
+	return pauses, rows.Err()
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPauses(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := db.SavePause(ctx, Pause{Repo: "repo1", PausedAt: now, Actor: "alice", Reason: "upstream migration"}); err != nil {
+		t.Fatalf("SavePause() failed: %v", err)
+	}
+	if err := db.SavePause(ctx, Pause{Repo: "repo2", PausedAt: now, Until: now.Add(time.Hour), Actor: "bob"}); err != nil {
+		t.Fatalf("SavePause() failed: %v", err)
+	}
+	// Pausing again replaces the earlier pause
+	if err := db.SavePause(ctx, Pause{Repo: "repo2", PausedAt: now, Until: now.Add(2 * time.Hour), Actor: "bob"}); err != nil {
+		t.Fatalf("SavePause() failed: %v", err)
+	}
+
+	pauses, err := db.Pauses(ctx)
+	if err != nil {
+		t.Fatalf("Pauses() failed: %v", err)
+	}
+	if len(pauses) != 2 {
+		t.Fatalf("Expected 2 pauses, got %+v", pauses)
+	}
+	if p := pauses["repo1"]; !p.Until.IsZero() || p.Actor != "alice" || p.Reason != "upstream migration" || !p.PausedAt.Equal(now) {
+		t.Errorf("Expected an indefinite pause by alice, got %+v", p)
+	}
+	if p := pauses["repo2"]; !p.Until.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("Expected repo2 paused for 2h, got %+v", p)
+	}
+
+	if err := db.DeletePause(ctx, "repo1"); err != nil {
+		t.Fatalf("DeletePause() failed: %v", err)
+	}
+	if pauses, _ := db.Pauses(ctx); len(pauses) != 1 {
+		t.Errorf("Expected 1 pause after deleting, got %+v", pauses)
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	_ "github.com/lib/pq"
+)
+
+// openPostgres connects to the shared Postgres and creates the gitfetcher schema
+func openPostgres(cfg config.PostgresConfig) (*DB, error) {
+	dsn, err := cfg.DSN()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(time.Hour)
+
+	schema := cfg.SchemaName()
+	d := &DB{db: db, driver: config.StoragePostgres, prefix: schema + "."}
+	err = d.migrate([]string{
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.fetch_history (
+			id BIGSERIAL PRIMARY KEY,
+			repo TEXT NOT NULL,
+			status TEXT NOT NULL,
+			success BOOLEAN NOT NULL,
+			message TEXT NOT NULL,
+			changes JSONB,
+			attempts INTEGER NOT NULL,
+			started_at TIMESTAMPTZ NOT NULL,
+			duration_ms BIGINT NOT NULL
+		)`, schema),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_fetch_history_repo
+			ON %s.fetch_history(repo, started_at DESC)`, schema),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_fetch_history_started_at
+			ON %s.fetch_history(started_at)`, schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.repo_state (
+			repo TEXT PRIMARY KEY,
+			next_fetch TIMESTAMPTZ NOT NULL
+		)`, schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.repo_pauses (
+			repo TEXT PRIMARY KEY,
+			paused_at TIMESTAMPTZ NOT NULL,
+			paused_until TIMESTAMPTZ,
+			actor TEXT NOT NULL,
+			reason TEXT NOT NULL
+		)`, schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.config_revisions (
+			rev BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			message TEXT NOT NULL,
+			diff TEXT NOT NULL,
+			content TEXT NOT NULL
+		)`, schema),
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
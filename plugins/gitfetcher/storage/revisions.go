@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+)
+
+// ErrRevisionNotFound is returned for an unknown config revision
+var ErrRevisionNotFound = errors.New("config revision not found")
+
+// How a config revision came about
+const (
+	ConfigActionUpdate    = "update"     // saved through the API or the web UI
+	ConfigActionRollback  = "rollback"   // an earlier revision was restored
+	ConfigActionDeployKey = "deploy_key" // a generated deploy key was assigned to a repo
+	ConfigActionRepo      = "repo"       // a repo was added, changed or removed through /api/repos
+	ConfigActionImport    = "import"     // repos were imported from Redmine
+	ConfigActionFile      = "file"       // the file was edited outside gitfetcher
+)
+
+// ConfigRevision is an accepted version of the config file. Diff is the
+// change from the previous revision, Content is only loaded for a single revision.
+type ConfigRevision struct {
+	Rev       int64     `json:"rev"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Message   string    `json:"message,omitempty"`
+	Diff      string    `json:"diff"`
+	Content   string    `json:"content,omitempty"`
+}
+
+// RecordConfig stores content as a new revision with its diff to the latest
+// one. Nothing is stored when content equals the latest revision, ok is then false.
+func (d *DB) RecordConfig(ctx context.Context, content []byte, actor, action, message string) (rev ConfigRevision, ok bool, err error) {
+	latest, found, err := d.LatestConfigRevision(ctx)
+	if err != nil {
+		return ConfigRevision{}, false, err
+	}
+	if found && latest.Content == string(content) {
+		return latest, false, nil
+	}
+
+	from := "a/config.yaml"
+	if !found {
+		from = "/dev/null"
+	}
+	rev = ConfigRevision{
+		CreatedAt: time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		Message:   message,
+		Diff:      config.Diff([]byte(latest.Content), content, from, "b/config.yaml"),
+		Content:   string(content),
+	}
+
+	query := d.rebind(fmt.Sprintf(`INSERT INTO %s
+		(created_at, actor, action, message, diff, content) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.table("config_revisions")))
+	args := []any{rev.CreatedAt, rev.Actor, rev.Action, rev.Message, rev.Diff, rev.Content}
+
+	// lib/pq has no LastInsertId
+	if d.driver == config.StoragePostgres {
+		err = d.db.QueryRowContext(ctx, query+" RETURNING rev", args...).Scan(&rev.Rev)
+	} else {
+		var res sql.Result
+		if res, err = d.db.ExecContext(ctx, query, args...); err == nil {
+			rev.Rev, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return ConfigRevision{}, false, err
+	}
+	return rev, true, nil
+}
+
+// ConfigRevisions returns a page of revisions without their content, newest
+// first, and the total number of revisions
+func (d *DB) ConfigRevisions(ctx context.Context, limit, offset int) ([]ConfigRevision, int, error) {
+	var total int
+	err := d.db.QueryRowContext(ctx, fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s`, d.table("config_revisions"))).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, d.rebind(fmt.Sprintf(`SELECT
+		rev, created_at, actor, action, message, diff
+		FROM %s ORDER BY rev DESC LIMIT ? OFFSET ?`, d.table("config_revisions"))), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	revisions := make([]ConfigRevision, 0, limit)
+	for rows.Next() {
+		var rev ConfigRevision
+		if err := rows.Scan(&rev.Rev, &rev.CreatedAt, &rev.Actor, &rev.Action, &rev.Message, &rev.Diff); err != nil {
+			return nil, 0, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, total, rows.Err()
+}
+
+// ConfigRevision returns a revision with its content
+func (d *DB) ConfigRevision(ctx context.Context, rev int64) (ConfigRevision, error) {
+	return d.scanConfigRevision(d.db.QueryRowContext(ctx, d.rebind(fmt.Sprintf(`SELECT
+		rev, created_at, actor, action, message, diff, content
+		FROM %s WHERE rev = ?`, d.table("config_revisions"))), rev))
+}
+
+// LatestConfigRevision returns the newest revision with its content, found
+// is false before the first revision was recorded
+func (d *DB) LatestConfigRevision(ctx context.Context) (rev ConfigRevision, found bool, err error) {
+	rev, err = d.scanConfigRevision(d.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT
+		rev, created_at, actor, action, message, diff, content
+		FROM %s ORDER BY rev DESC LIMIT 1`, d.table("config_revisions"))))
+	if errors.Is(err, ErrRevisionNotFound) {
+		return ConfigRevision{}, false, nil
+	}
+	return rev, err == nil, err
+}
+
+func (d *DB) scanConfigRevision(row *sql.Row) (ConfigRevision, error) {
+	var rev ConfigRevision
+	err := row.Scan(&rev.Rev, &rev.CreatedAt, &rev.Actor, &rev.Action, &rev.Message, &rev.Diff, &rev.Content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ConfigRevision{}, ErrRevisionNotFound
+	}
+	return rev, err
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfigRevisions(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if _, found, err := db.LatestConfigRevision(ctx); err != nil || found {
+		t.Fatalf("Expected no revision in a new database, got found=%v (%v)", found, err)
+	}
+
+	first, ok, err := db.RecordConfig(ctx, []byte("http_port: 8080\n"), "unknown", ConfigActionFile, "")
+	if err != nil || !ok {
+		t.Fatalf("RecordConfig() failed: ok=%v %v", ok, err)
+	}
+	if !strings.Contains(first.Diff, "--- /dev/null") || !strings.Contains(first.Diff, "+http_port: 8080") {
+		t.Errorf("Expected the first revision to add every line, got\n%s", first.Diff)
+	}
+
+	second, ok, err := db.RecordConfig(ctx, []byte("http_port: 9090\n"), "alice", ConfigActionUpdate, "")
+	if err != nil || !ok {
+		t.Fatalf("RecordConfig() failed: ok=%v %v", ok, err)
+	}
+	if second.Rev != first.Rev+1 {
+		t.Errorf("Expected revision %d, got %d", first.Rev+1, second.Rev)
+	}
+	wantDiff := "--- a/config.yaml\n+++ b/config.yaml\n@@ -1,1 +1,1 @@\n-http_port: 8080\n+http_port: 9090\n"
+	if second.Diff != wantDiff {
+		t.Errorf("Expected diff\n%s\ngot\n%s", wantDiff, second.Diff)
+	}
+
+	// Unchanged content is not recorded again
+	if rev, ok, err := db.RecordConfig(ctx, []byte("http_port: 9090\n"), "bob", ConfigActionUpdate, ""); err != nil || ok || rev.Rev != second.Rev {
+		t.Errorf("Expected no new revision for unchanged content, got %d ok=%v (%v)", rev.Rev, ok, err)
+	}
+
+	revisions, total, err := db.ConfigRevisions(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("ConfigRevisions() failed: %v", err)
+	}
+	if total != 2 || len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %d of %d", len(revisions), total)
+	}
+	if revisions[0].Rev != second.Rev || revisions[0].Actor != "alice" || revisions[0].Content != "" {
+		t.Errorf("Expected newest revision first without content, got %+v", revisions[0])
+	}
+
+	rev, err := db.ConfigRevision(ctx, first.Rev)
+	if err != nil {
+		t.Fatalf("ConfigRevision() failed: %v", err)
+	}
+	if rev.Content != "http_port: 8080\n" || rev.Action != ConfigActionFile {
+		t.Errorf("Expected the first revision with content, got %+v", rev)
+	}
+	if _, err := db.ConfigRevision(ctx, 42); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"colosscious.com/gitfetcher/config"
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens (or creates) the embedded database at path. The driver is
+// pure Go, so the binary still builds with CGO_ENABLED=0.
+func openSQLite(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite allows a single writer, serialize instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	d := &DB{db: db, driver: config.StorageSQLite}
+	err = d.migrate([]string{
+		`CREATE TABLE IF NOT EXISTS fetch_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			repo TEXT NOT NULL,
+			status TEXT NOT NULL,
+			success BOOLEAN NOT NULL,
+			message TEXT NOT NULL,
+			changes TEXT,
+			attempts INTEGER NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			duration_ms INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_fetch_history_repo
+			ON fetch_history(repo, started_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_fetch_history_started_at
+			ON fetch_history(started_at)`,
+		`CREATE TABLE IF NOT EXISTS repo_state (
+			repo TEXT PRIMARY KEY,
+			next_fetch TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS repo_pauses (
+			repo TEXT PRIMARY KEY,
+			paused_at TIMESTAMP NOT NULL,
+			paused_until TIMESTAMP,
+			actor TEXT NOT NULL,
+			reason TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS config_revisions (
+			rev INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at TIMESTAMP NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			message TEXT NOT NULL,
+			diff TEXT NOT NULL,
+			content TEXT NOT NULL
+		)`,
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
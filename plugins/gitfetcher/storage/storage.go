@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+)
+
+// Record is one fetch run as kept in the history
+type Record struct {
+	ID         int64               `json:"id"`
+	Repo       string              `json:"repo"`
+	Status     string              `json:"status"`
+	Success    bool                `json:"success"`
+	Message    string              `json:"message"`
+	Changes    []fetcher.RefChange `json:"changes,omitempty"`
+	Attempts   int                 `json:"attempts"`
+	StartedAt  time.Time           `json:"started_at"`
+	DurationMS int64               `json:"duration_ms"`
+}
+
+// Stats aggregates the runs of a repo
+type Stats struct {
+	Repo          string  `json:"repo"`
+	Total         int     `json:"total"`
+	Successes     int     `json:"successes"`
+	Failures      int     `json:"failures"`
+	SuccessRate   float64 `json:"success_rate"` // 0..1, zero without runs
+	AvgDurationMS float64 `json:"avg_duration_ms"`
+}
+
+// DB stores fetch history and schedule state in Postgres or SQLite
+type DB struct {
+	db     *sql.DB
+	driver string
+	prefix string // schema qualifier for table names, empty for SQLite
+}
+
+// Open connects to the database selected by cfg and creates missing tables
+func Open(cfg config.StorageConfig) (*DB, error) {
+	switch cfg.Driver {
+	case config.StoragePostgres:
+		return openPostgres(cfg.Postgres)
+	case "", config.StorageSQLite:
+		path := cfg.Path
+		if path == "" {
+			path = config.DefaultStoragePath
+		}
+		return openSQLite(path)
+	default:
+		return nil, fmt.Errorf("unknown storage driver '%s'", cfg.Driver)
+	}
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Driver returns the storage driver in use
+func (d *DB) Driver() string {
+	return d.driver
+}
+
+// table returns the qualified name of a gitfetcher table
+func (d *DB) table(name string) string {
+	return d.prefix + name
+}
+
+// rebind rewrites ? placeholders to $n for Postgres
+func (d *DB) rebind(query string) string {
+	if d.driver != config.StoragePostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// RecordFetch appends a run to the history and returns its id
+func (d *DB) RecordFetch(ctx context.Context, rec Record) (int64, error) {
+	changes, err := json.Marshal(rec.Changes)
+	if err != nil {
+		return 0, err
+	}
+
+	query := d.rebind(fmt.Sprintf(`INSERT INTO %s
+		(repo, status, success, message, changes, attempts, started_at, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, d.table("fetch_history")))
+	args := []any{rec.Repo, rec.Status, rec.Success, rec.Message, string(changes),
+		rec.Attempts, rec.StartedAt.UTC(), rec.DurationMS}
+
+	// lib/pq has no LastInsertId
+	if d.driver == config.StoragePostgres {
+		var id int64
+		err := d.db.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// History returns a page of runs of repo, newest first, and the total number of runs
+func (d *DB) History(ctx context.Context, repo string, limit, offset int) ([]Record, int, error) {
+	var total int
+	err := d.db.QueryRowContext(ctx, d.rebind(fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s WHERE repo = ?`, d.table("fetch_history"))), repo).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, d.rebind(fmt.Sprintf(`SELECT
+		id, repo, status, success, message, changes, attempts, started_at, duration_ms
+		FROM %s WHERE repo = ? ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?`,
+		d.table("fetch_history"))), repo, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := make([]Record, 0, limit)
+	for rows.Next() {
+		var rec Record
+		var changes []byte
+		if err := rows.Scan(&rec.ID, &rec.Repo, &rec.Status, &rec.Success, &rec.Message,
+			&changes, &rec.Attempts, &rec.StartedAt, &rec.DurationMS); err != nil {
+			return nil, 0, err
+		}
+		if len(changes) > 0 {
+			if err := json.Unmarshal(changes, &rec.Changes); err != nil {
+				return nil, 0, fmt.Errorf("record %d: invalid changes: %w", rec.ID, err)
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, total, rows.Err()
+}
+
+// Stats aggregates the runs of every repo started at or after since
+func (d *DB) Stats(ctx context.Context, since time.Time) (map[string]Stats, error) {
+	rows, err := d.db.QueryContext(ctx, d.rebind(fmt.Sprintf(`SELECT
+		repo, COUNT(*), SUM(CASE WHEN success THEN 1 ELSE 0 END), AVG(duration_ms)
+		FROM %s WHERE started_at >= ? GROUP BY repo`, d.table("fetch_history"))), since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]Stats)
+	for rows.Next() {
+		var st Stats
+		var avg sql.NullFloat64
+		if err := rows.Scan(&st.Repo, &st.Total, &st.Successes, &avg); err != nil {
+			return nil, err
+		}
+		st.Failures = st.Total - st.Successes
+		if st.Total > 0 {
+			st.SuccessRate = float64(st.Successes) / float64(st.Total)
+		}
+		st.AvgDurationMS = avg.Float64
+		stats[st.Repo] = st
+	}
+	return stats, rows.Err()
+}
+
+// RepoStats aggregates the runs of a single repo started at or after since
+func (d *DB) RepoStats(ctx context.Context, repo string, since time.Time) (Stats, error) {
+	stats, err := d.Stats(ctx, since)
+	if err != nil {
+		return Stats{}, err
+	}
+	if st, ok := stats[repo]; ok {
+		return st, nil
+	}
+	return Stats{Repo: repo}, nil
+}
+
+// LastSuccesses returns when the latest successful run of every repo finished
+func (d *DB) LastSuccesses(ctx context.Context) (map[string]time.Time, error) {
+	// Select the column itself rather than MAX() so SQLite keeps its timestamp type
+	table := d.table("fetch_history")
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(`SELECT h.repo, h.started_at, h.duration_ms FROM %s h
+		WHERE h.success AND h.started_at = (SELECT MAX(started_at) FROM %s WHERE repo = h.repo AND success)`,
+		table, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := make(map[string]time.Time)
+	for rows.Next() {
+		var repo string
+		var t time.Time
+		var ms int64
+		if err := rows.Scan(&repo, &t, &ms); err != nil {
+			return nil, err
+		}
+		last[repo] = t.Add(time.Duration(ms) * time.Millisecond)
+	}
+	return last, rows.Err()
+}
+
+// Prune deletes runs started before before and returns how many were removed
+func (d *DB) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res, err := d.db.ExecContext(ctx, d.rebind(fmt.Sprintf(
+		`DELETE FROM %s WHERE started_at < ?`, d.table("fetch_history"))), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SaveNextFetch persists the next scheduled fetch of repo
+func (d *DB) SaveNextFetch(ctx context.Context, repo string, next time.Time) error {
+	_, err := d.db.ExecContext(ctx, d.rebind(fmt.Sprintf(`INSERT INTO %s (repo, next_fetch)
+		VALUES (?, ?) ON CONFLICT (repo) DO UPDATE SET next_fetch = excluded.next_fetch`,
+		d.table("repo_state"))), repo, next.UTC())
+	return err
+}
+
+// NextFetches returns the persisted next fetch time of every repo
+func (d *DB) NextFetches(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT repo, next_fetch FROM %s`, d.table("repo_state")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	next := make(map[string]time.Time)
+	for rows.Next() {
+		var repo string
+		var t time.Time
+		if err := rows.Scan(&repo, &t); err != nil {
+			return nil, err
+		}
+		next[repo] = t
+	}
+	return next, rows.Err()
+}
+
+// migrate runs statements in order, each must be idempotent
+func (d *DB) migrate(statements []string) error {
+	for _, stmt := range statements {
+		if _, err := d.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to migrate: %w", err)
+		}
+	}
+	return nil
+}
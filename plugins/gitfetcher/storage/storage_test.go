@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(config.StorageConfig{
+		Driver: config.StorageSQLite,
+		Path:   filepath.Join(t.TempDir(), "gitfetcher.db"),
+	})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRecordFetchAndHistory(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		rec := Record{
+			Repo:       "repo1",
+			Status:     "success",
+			Success:    true,
+			Message:    "Fetch completed",
+			Attempts:   1,
+			StartedAt:  start.Add(time.Duration(i) * time.Minute),
+			DurationMS: int64(100 * (i + 1)),
+		}
+		if i == 4 {
+			rec.Changes = []fetcher.RefChange{{Ref: "refs/heads/main", Type: "updated", OldSHA: "aaa", NewSHA: "bbb"}}
+		}
+		if _, err := db.RecordFetch(ctx, rec); err != nil {
+			t.Fatalf("RecordFetch() failed: %v", err)
+		}
+	}
+	if _, err := db.RecordFetch(ctx, Record{Repo: "repo2", Status: "failed", StartedAt: start}); err != nil {
+		t.Fatalf("RecordFetch() failed: %v", err)
+	}
+
+	records, total, err := db.History(ctx, "repo1", 2, 0)
+	if err != nil {
+		t.Fatalf("History() failed: %v", err)
+	}
+	if total != 5 {
+		t.Errorf("Expected 5 runs in total, got %d", total)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if !records[0].StartedAt.Equal(start.Add(4 * time.Minute)) {
+		t.Errorf("Expected newest run first, got %s", records[0].StartedAt)
+	}
+	if len(records[0].Changes) != 1 || records[0].Changes[0].NewSHA != "bbb" {
+		t.Errorf("Expected ref changes to round-trip, got %+v", records[0].Changes)
+	}
+
+	records, _, err = db.History(ctx, "repo1", 2, 4)
+	if err != nil {
+		t.Fatalf("History() failed: %v", err)
+	}
+	if len(records) != 1 || !records[0].StartedAt.Equal(start) {
+		t.Errorf("Expected the oldest run on the last page, got %+v", records)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	runs := []Record{
+		{Repo: "repo1", Status: "success", Success: true, StartedAt: now.Add(-time.Hour), DurationMS: 100},
+		{Repo: "repo1", Status: "success", Success: true, StartedAt: now.Add(-time.Hour), DurationMS: 200},
+		{Repo: "repo1", Status: "failed", StartedAt: now.Add(-time.Hour), DurationMS: 300},
+		{Repo: "repo1", Status: "failed", StartedAt: now.Add(-48 * time.Hour), DurationMS: 300},
+	}
+	for _, rec := range runs {
+		if _, err := db.RecordFetch(ctx, rec); err != nil {
+			t.Fatalf("RecordFetch() failed: %v", err)
+		}
+	}
+
+	st, err := db.RepoStats(ctx, "repo1", now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("RepoStats() failed: %v", err)
+	}
+	if st.Total != 3 || st.Successes != 2 || st.Failures != 1 {
+		t.Errorf("Expected 3 runs with 2 successes, got %+v", st)
+	}
+	if st.SuccessRate < 0.66 || st.SuccessRate > 0.67 {
+		t.Errorf("Expected success rate 2/3, got %f", st.SuccessRate)
+	}
+	if st.AvgDurationMS != 200 {
+		t.Errorf("Expected average duration 200ms, got %f", st.AvgDurationMS)
+	}
+
+	st, err = db.RepoStats(ctx, "unknown", time.Time{})
+	if err != nil {
+		t.Fatalf("RepoStats() failed: %v", err)
+	}
+	if st.Total != 0 || st.Repo != "unknown" {
+		t.Errorf("Expected empty stats, got %+v", st)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, age := range []time.Duration{time.Hour, 48 * time.Hour, 72 * time.Hour} {
+		if _, err := db.RecordFetch(ctx, Record{Repo: "repo1", StartedAt: now.Add(-age)}); err != nil {
+			t.Fatalf("RecordFetch() failed: %v", err)
+		}
+	}
+
+	removed, err := db.Prune(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Prune() failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 runs to be pruned, got %d", removed)
+	}
+	if _, total, _ := db.History(ctx, "repo1", 10, 0); total != 1 {
+		t.Errorf("Expected 1 run left, got %d", total)
+	}
+}
+
+func TestNextFetches(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	next := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := db.SaveNextFetch(ctx, "repo1", next); err != nil {
+		t.Fatalf("SaveNextFetch() failed: %v", err)
+	}
+	if err := db.SaveNextFetch(ctx, "repo1", next.Add(time.Hour)); err != nil {
+		t.Fatalf("SaveNextFetch() failed: %v", err)
+	}
+
+	saved, err := db.NextFetches(ctx)
+	if err != nil {
+		t.Fatalf("NextFetches() failed: %v", err)
+	}
+	if len(saved) != 1 || !saved["repo1"].Equal(next.Add(time.Hour)) {
+		t.Errorf("Expected repo1 at %s, got %v", next.Add(time.Hour), saved)
+	}
+}
+
+func TestLastSuccesses(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	runs := []Record{
+		{Repo: "repo1", Success: true, StartedAt: start},
+		{Repo: "repo1", Success: true, StartedAt: start.Add(time.Hour), DurationMS: 1500},
+		{Repo: "repo1", StartedAt: start.Add(2 * time.Hour)},
+		{Repo: "repo2", StartedAt: start},
+	}
+	for _, rec := range runs {
+		if _, err := db.RecordFetch(ctx, rec); err != nil {
+			t.Fatalf("RecordFetch() failed: %v", err)
+		}
+	}
+
+	last, err := db.LastSuccesses(ctx)
+	if err != nil {
+		t.Fatalf("LastSuccesses() failed: %v", err)
+	}
+	want := start.Add(time.Hour + 1500*time.Millisecond)
+	if len(last) != 1 || !last["repo1"].Equal(want) {
+		t.Errorf("Expected only repo1 at %s, got %v", want, last)
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+// handleListBackups returns the ref backups in the mirror of a repository, newest first
+func (h *Handler) handleListBackups(c *gin.Context) {
+	backups, err := h.scheduler.Backups(c.Param("name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrUnknownRepo) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if backups == nil {
+		backups = []fetcher.Backup{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"backups": backups,
+	})
+}
+
+// handleRestoreBackup points a ref of the mirror at the commit of a backup.
+// The body names the backup ref and optionally the target ref, which defaults
+// to the ref that was backed up. Scheduled fetches of the repository are
+// paused afterwards.
+func (h *Handler) handleRestoreBackup(c *gin.Context) {
+	var req struct {
+		Ref    string `json:"ref" binding:"required"`
+		Target string `json:"target"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid JSON: " + err.Error(),
+		})
+		return
+	}
+
+	backup, pause, err := h.scheduler.RestoreBackup(c.Param("name"), req.Ref, req.Target, principalName(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, scheduler.ErrUnknownRepo), errors.Is(err, fetcher.ErrUnknownBackup):
+			status = http.StatusNotFound
+		case errors.Is(err, fetcher.ErrInvalidTarget):
+			status = http.StatusBadRequest
+		case errors.Is(err, scheduler.ErrFetchRunning):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	target := req.Target
+	if target == "" {
+		target = backup.Original
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": target + " restored to " + backup.SHA + ". Scheduled fetches are paused so they do not revert it, " +
+			"resume the repository once the upstream has it again. A manual fetch still overwrites it.",
+		"backup": backup,
+		"target": target,
+		"pause":  pause,
+	})
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+// git runs git in dir and returns its trimmed output
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %v failed: %v", args, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestBackups(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+	gin.SetMode(gin.TestMode)
+
+	mirror := filepath.Join(t.TempDir(), "mirror.git")
+	if err := exec.Command("git", "init", "--bare", mirror).Run(); err != nil {
+		t.Fatalf("Failed to create mirror: %v", err)
+	}
+	tree := git(t, mirror, "mktree")
+	sha := git(t, mirror, "commit-tree", tree, "-m", "lost")
+	backupRef := fetcher.BackupPrefix + "20261016T120000Z/heads/gone"
+	git(t, mirror, "update-ref", backupRef, sha)
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	defer sched.Stop()
+	sched.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "test-repo", URL: "git@github.com:user/test.git", LocalPath: mirror, Interval: "1h", RefBackup: &config.RefBackupConfig{}},
+		},
+		HTTPPort: 8080,
+	})
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/repos/test-repo/backups", nil)
+	router.ServeHTTP(w, req)
+	var resp struct {
+		Backups []fetcher.Backup `json:"backups"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Backups) != 1 || resp.Backups[0].Original != "refs/heads/gone" {
+		t.Fatalf("Expected the backup of gone, got %s", w.Body.String())
+	}
+
+	restore := func(repo, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/repos/"+repo+"/backups/restore", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	tests := []struct {
+		name string
+		repo string
+		body string
+		want int
+	}{
+		{"missing ref", "test-repo", `{}`, http.StatusBadRequest},
+		{"unknown repo", "other", `{"ref":"` + backupRef + `"}`, http.StatusNotFound},
+		{"unknown backup", "test-repo", `{"ref":"refs/heads/gone"}`, http.StatusNotFound},
+		{"reserved target", "test-repo", `{"ref":"` + backupRef + `","target":"refs/gitfetcher/x"}`, http.StatusBadRequest},
+		{"restore", "test-repo", `{"ref":"` + backupRef + `"}`, http.StatusOK},
+		{"restore elsewhere", "test-repo", `{"ref":"` + backupRef + `","target":"refs/heads/recovered"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := restore(tt.repo, tt.body); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+	for _, ref := range []string{"refs/heads/gone", "refs/heads/recovered"} {
+		if got := git(t, mirror, "rev-parse", ref); got != sha {
+			t.Errorf("Expected %s to be restored at %s, got %s", ref, sha, got)
+		}
+	}
+	if status := sched.GetStatus()["test-repo"]; !status.Paused || status.PausedBy != "anonymous" {
+		t.Errorf("Expected scheduled fetches to be paused after a restore, got %+v", status)
+	}
+}
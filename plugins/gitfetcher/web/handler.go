@@ -1,11 +1,28 @@
 package web
 
 import (
+	"context"
 	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"colosscious.com/gitfetcher/access"
 	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/deploykey"
+	"colosscious.com/gitfetcher/hostkeys"
+	"colosscious.com/gitfetcher/metrics"
+	"colosscious.com/gitfetcher/redmine"
 	"colosscious.com/gitfetcher/scheduler"
+	"colosscious.com/gitfetcher/storage"
+	"colosscious.com/gitfetcher/webhook"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,22 +32,263 @@ var indexHTML string
 type Handler struct {
 	scheduler  *scheduler.Scheduler
 	configPath string
+	hostKeys   *hostkeys.Store
+	store      *storage.DB
+	auth       *access.Authenticator // replaced on config reloads, guarded by authMu
+	authMu     sync.RWMutex
+	configMu   sync.Mutex // serializes config writes with their revisions
+	pushes     *webhook.Debouncer
+
+	// redmineRepos reads Redmine's repositories table, replaced in tests
+	redmineRepos func(ctx context.Context, db config.PostgresConfig) ([]redmine.Repository, error)
 }
 
+// principalKey holds the access.Principal of an authenticated request
+const principalKey = "principal"
+
 func NewHandler(s *scheduler.Scheduler, configPath string) *Handler {
 	return &Handler{
 		scheduler:  s,
 		configPath: configPath,
+		pushes:     webhook.NewDebouncer(),
+
+		redmineRepos: redmine.LoadRepositories,
 	}
 }
 
+// SetHostKeyStore enables the /api/hostkeys endpoints
+func (h *Handler) SetHostKeyStore(store *hostkeys.Store) {
+	h.hostKeys = store
+}
+
+// SetAuthenticator requires authentication for the API, it is open without one
+func (h *Handler) SetAuthenticator(a *access.Authenticator) {
+	h.authMu.Lock()
+	defer h.authMu.Unlock()
+	h.auth = a
+}
+
+// ReloadAuth replaces the users and tokens with those of cfg, main calls it
+// after every config reload. Sessions of users and tokens whose access did not
+// change stay valid. On error the previous users and tokens are kept.
+func (h *Handler) ReloadAuth(cfg config.WebAuthConfig) error {
+	var a *access.Authenticator
+	if cfg.Enabled() {
+		var err error
+		if a, err = access.New(cfg); err != nil {
+			return err
+		}
+	}
+
+	h.authMu.Lock()
+	defer h.authMu.Unlock()
+	if a != nil && h.auth != nil {
+		a.KeepSessions(h.auth)
+	}
+	h.auth = a
+	return nil
+}
+
+// authenticator returns the current authenticator, nil when the API is open
+func (h *Handler) authenticator() *access.Authenticator {
+	h.authMu.RLock()
+	defer h.authMu.RUnlock()
+	return h.auth
+}
+
 // SetupRoutes configures all HTTP routes
 func (h *Handler) SetupRoutes(r *gin.Engine) {
+	// The UI shell, login and probes are public, webhooks carry their own signature
 	r.GET("/", h.handleIndex)
-	r.GET("/api/status", h.handleStatus)
-	r.GET("/api/config", h.handleGetConfig)
-	r.POST("/api/config", h.handleUpdateConfig)
-	r.POST("/api/fetch/:name", h.handleManualFetch)
+	r.GET("/healthz", h.handleHealthz)
+	r.GET("/readyz", h.handleReadyz)
+	r.POST("/api/login", h.handleLogin)
+	r.POST("/api/logout", h.handleLogout)
+	r.POST("/hooks/:provider", h.handleHook)
+
+	viewer := r.Group("", h.require(config.RoleViewer))
+	viewer.GET("/metrics", gin.WrapH(metrics.Handler(h.scheduler)))
+	viewer.GET("/api/me", h.handleMe)
+	viewer.GET("/api/status", h.handleStatus)
+	viewer.GET("/api/events", h.handleEvents)
+	viewer.GET("/api/config", h.handleGetConfig)
+	viewer.GET("/api/config/history", h.handleConfigHistory)
+	viewer.GET("/api/config/diff", h.handleConfigDiff)
+	viewer.GET("/api/queue", h.handleQueue)
+	viewer.GET("/api/repos", h.handleListRepos)
+	viewer.GET("/api/repos/:name", h.handleGetRepo)
+	viewer.GET("/api/repos/:name/history", h.handleHistory)
+	viewer.GET("/api/repos/:name/stats", h.handleRepoStats)
+	viewer.GET("/api/stats", h.handleStats)
+	viewer.GET("/api/repos/:name/deploy-key", h.handleGetDeployKey)
+	viewer.GET("/api/hostkeys", h.handleListHostKeys)
+	viewer.GET("/api/sources", h.handleListSources)
+	viewer.GET("/api/repos/:name/backups", h.handleListBackups)
+
+	operator := r.Group("", h.require(config.RoleOperator))
+	operator.POST("/api/fetch/:name", h.handleManualFetch)
+	operator.POST("/api/fetch/:name/cancel", h.handleCancelFetch)
+	operator.POST("/api/repos/:name/pause", h.handlePauseRepo)
+	operator.POST("/api/repos/:name/resume", h.handleResumeRepo)
+	operator.POST("/api/sources/:name/discover", h.handleDiscoverSource)
+
+	admin := r.Group("", h.require(config.RoleAdmin))
+	admin.POST("/api/config", h.handleUpdateConfig)
+	admin.POST("/api/config/rollback/:rev", h.handleConfigRollback)
+	admin.POST("/api/repos", h.handleCreateRepo)
+	admin.PATCH("/api/repos/:name", h.handleUpdateRepo)
+	admin.DELETE("/api/repos/:name", h.handleDeleteRepo)
+	admin.POST("/api/repos/:name/deploy-key", h.handleGenerateDeployKey)
+	admin.POST("/api/hostkeys/approve", h.handleApproveHostKey)
+	admin.DELETE("/api/hostkeys/:host", h.handleRevokeHostKey)
+	admin.GET("/api/import/redmine", h.handleProposeRedmineImport)
+	admin.POST("/api/import/redmine", h.handleRedmineImport)
+	admin.POST("/api/repos/:name/backups/restore", h.handleRestoreBackup)
+}
+
+// require rejects requests that are not authenticated as role or above.
+// Every request passes when no authenticator is set.
+func (h *Handler) require(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := h.authenticator()
+		if auth == nil {
+			c.Next()
+			return
+		}
+
+		p, ok := auth.Authenticate(c.Request)
+		if !ok {
+			// Bearer rather than Basic, so browsers do not pop up their own login dialog
+			c.Header("WWW-Authenticate", `Bearer realm="gitfetcher"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "authentication required",
+			})
+			return
+		}
+		if !access.Allows(p.Role, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   role + " role required",
+			})
+			return
+		}
+		c.Set(principalKey, p)
+		c.Next()
+	}
+}
+
+// principalName names who made the request, for the log
+func principalName(c *gin.Context) string {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(access.Principal).Name
+	}
+	return "anonymous"
+}
+
+// handleLogin checks a username and password, or an API token, and starts a
+// web UI session in an HttpOnly cookie
+func (h *Handler) handleLogin(c *gin.Context) {
+	auth := h.authenticator()
+	if auth == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "authentication is not configured",
+		})
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid JSON: " + err.Error(),
+		})
+		return
+	}
+
+	var p access.Principal
+	var ok bool
+	if req.Token != "" {
+		p, ok = auth.Token(req.Token)
+	} else {
+		p, ok = auth.Password(req.Username, req.Password)
+	}
+	if !ok {
+		log.Printf("Failed login for '%s' from %s", req.Username, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   "invalid credentials",
+		})
+		return
+	}
+
+	id, expires, err := auth.Login(p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	// SameSite=Strict keeps other sites from riding on the session
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     access.SessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"user":    p,
+	})
+}
+
+// handleLogout ends the web UI session
+func (h *Handler) handleLogout(c *gin.Context) {
+	if cookie, err := c.Cookie(access.SessionCookie); err == nil {
+		if auth := h.authenticator(); auth != nil {
+			auth.Logout(cookie)
+		}
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     access.SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// handleMe returns who the request is authenticated as, the web UI uses it
+// to decide between the login form and the dashboard
+func (h *Handler) handleMe(c *gin.Context) {
+	p, ok := c.Get(principalKey)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"auth_enabled": false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"auth_enabled": true,
+		"user":         p,
+	})
 }
 
 // handleIndex serves the main HTML page
@@ -47,6 +305,42 @@ func (h *Handler) handleStatus(c *gin.Context) {
 	})
 }
 
+// eventKeepAlive is how often an idle event stream sends a comment so
+// proxies do not close it
+const eventKeepAlive = 30 * time.Second
+
+// handleEvents streams scheduler events as Server-Sent Events until the client
+// disconnects. Clients should reload /api/status whenever the stream (re)opens.
+func (h *Handler) handleEvents(c *gin.Context) {
+	events, unsubscribe := h.scheduler.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // disable nginx response buffering
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
 // handleManualFetch triggers a manual fetch for a specific repository
 func (h *Handler) handleManualFetch(c *gin.Context) {
 	name := c.Param("name")
@@ -59,7 +353,11 @@ func (h *Handler) handleManualFetch(c *gin.Context) {
 	}
 
 	if err := h.scheduler.ManualFetch(name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrRepoDisabled) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
@@ -72,9 +370,73 @@ func (h *Handler) handleManualFetch(c *gin.Context) {
 	})
 }
 
-// handleGetConfig returns the current configuration
+// handleCancelFetch aborts the running fetch of a specific repository
+func (h *Handler) handleCancelFetch(c *gin.Context) {
+	name := c.Param("name")
+
+	if err := h.scheduler.Cancel(name); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, scheduler.ErrUnknownRepo):
+			status = http.StatusNotFound
+		case errors.Is(err, scheduler.ErrNotRunning):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "fetch cancelled for " + name,
+	})
+}
+
+// handleQueue returns the pending and running fetch jobs
+func (h *Handler) handleQueue(c *gin.Context) {
+	queue := h.scheduler.Queue()
+	c.JSON(http.StatusOK, gin.H{
+		"max_concurrent": queue.MaxConcurrent,
+		"pending":        queue.Pending,
+		"running":        queue.Running,
+	})
+}
+
+// errConfigConflict is returned by writeConfig when the file changed since
+// the client read it
+var errConfigConflict = errors.New("configuration was changed by someone else, reload it and try again")
+
+// loadConfig reads and parses the config file, returning its ETag
+func (h *Handler) loadConfig() (*config.Config, string, error) {
+	data, err := os.ReadFile(h.configPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	cfg, err := config.ParseConfig(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return cfg, config.ETag(data), nil
+}
+
+// etagMatches reports whether an If-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetConfig returns the current configuration. Its ETag must be sent
+// back as If-Match to update it.
 func (h *Handler) handleGetConfig(c *gin.Context) {
-	cfg, err := config.LoadConfig(h.configPath)
+	cfg, etag, err := h.loadConfig()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -83,13 +445,18 @@ func (h *Handler) handleGetConfig(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"config":  cfg,
+		"etag":    etag,
 	})
 }
 
-// handleUpdateConfig updates the configuration file
+// handleUpdateConfig updates the configuration file. The If-Match header must
+// hold the ETag of the config the change is based on, a stale one is answered
+// with 409. With ?dry_run=true the config is only validated and the diff to
+// the current file returned.
 func (h *Handler) handleUpdateConfig(c *gin.Context) {
 	var cfg config.Config
 	if err := c.ShouldBindJSON(&cfg); err != nil {
@@ -109,17 +476,803 @@ func (h *Handler) handleUpdateConfig(c *gin.Context) {
 		return
 	}
 
+	// The users and tokens are reloaded with the config, they must be readable
+	if cfg.WebAuth.Enabled() {
+		if _, err := access.New(cfg.WebAuth); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid configuration: web_auth: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	data, err := config.MarshalConfig(&cfg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid configuration: " + err.Error(),
+		})
+		return
+	}
+
+	if c.Query("dry_run") == "true" {
+		current, err := os.ReadFile(h.configPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"dry_run": true,
+			"diff":    config.Diff(current, data, "a/config.yaml", "b/config.yaml"),
+		})
+		return
+	}
+
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
 	// Save config to file (fsnotify will trigger automatic reload)
-	if err := config.SaveConfig(h.configPath, &cfg); err != nil {
+	rev, err := h.writeConfig(c, data, ifMatch, storage.ConfigActionUpdate, "")
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+
+	etag := config.ETag(data)
+	log.Printf("Configuration updated by %s", principalName(c))
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Configuration updated successfully. It will be reloaded automatically.",
+		"revision": rev,
+		"etag":     etag,
+	})
+}
+
+// requireIfMatch returns the If-Match header of a request that changes the
+// config, answering 428 when it is missing. Every config change must name the
+// version it is based on, so no client overwrites an edit it has not seen.
+func requireIfMatch(c *gin.Context) (string, bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"success": false,
+			"error":   "If-Match header with the ETag of GET /api/config is required",
+		})
+		return "", false
+	}
+	return ifMatch, true
+}
+
+// writeConfigError answers 409 for a stale If-Match and 500 otherwise
+func writeConfigError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "Failed to save config: " + err.Error()
+	if errors.Is(err, errConfigConflict) {
+		status = http.StatusConflict
+		message = err.Error()
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// writeConfig replaces the config file with data and records it as a
+// revision, returning its number or 0 when nothing was recorded. Unless
+// ifMatch is empty, the file must still have one of its ETags or
+// errConfigConflict is returned. Edits made to the file outside gitfetcher
+// are recorded first, so the new revision only holds this change.
+func (h *Handler) writeConfig(c *gin.Context, data []byte, ifMatch, action, message string) (int64, error) {
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+
+	if ifMatch != "" {
+		current, err := os.ReadFile(h.configPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		if !etagMatches(ifMatch, config.ETag(current)) {
+			return 0, errConfigConflict
+		}
+	}
+
+	if h.store != nil {
+		h.recordConfigFile(c.Request.Context())
+	}
+	if err := config.WriteConfig(h.configPath, data); err != nil {
+		return 0, err
+	}
+	if h.store == nil {
+		return 0, nil
+	}
+
+	// The file is already written, a lost revision must not fail the request
+	rev, ok, err := h.store.RecordConfig(c.Request.Context(), data, principalName(c), action, message)
+	if err != nil {
+		log.Printf("Failed to record config revision: %v", err)
+		return 0, nil
+	}
+	if !ok {
+		return 0, nil
+	}
+	return rev.Rev, nil
+}
+
+// RecordConfigFile records the config file as a revision if it differs from
+// the latest one, that is when it was edited outside gitfetcher. main calls
+// it at startup and after every reload.
+func (h *Handler) RecordConfigFile() {
+	if h.store == nil {
+		return
+	}
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+	h.recordConfigFile(context.Background())
+}
+
+// recordConfigFile implements RecordConfigFile, the caller holds h.configMu
+func (h *Handler) recordConfigFile(ctx context.Context) {
+	data, err := os.ReadFile(h.configPath)
+	if err != nil {
+		return
+	}
+	rev, ok, err := h.store.RecordConfig(ctx, data, "unknown", storage.ConfigActionFile, "edited outside gitfetcher")
+	if err != nil {
+		log.Printf("Failed to record config revision: %v", err)
+	} else if ok {
+		log.Printf("Recorded config file as revision %d", rev.Rev)
+	}
+}
+
+// handleConfigHistory returns a page of config revisions, newest first
+func (h *Handler) handleConfigHistory(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	page, perPage, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	revisions, total, err := h.store.ConfigRevisions(c.Request.Context(), perPage, (page-1)*perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"revisions": revisions,
+		"page":      page,
+		"per_page":  perPage,
+		"total":     total,
+	})
+}
+
+// handleConfigDiff compares two config revisions. to defaults to the latest
+// revision, from to the one before to.
+func (h *Handler) handleConfigDiff(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	ctx := c.Request.Context()
+
+	var to storage.ConfigRevision
+	var err error
+	if c.Query("to") == "" {
+		var found bool
+		to, found, err = h.store.LatestConfigRevision(ctx)
+		if err == nil && !found {
+			err = storage.ErrRevisionNotFound
+		}
+	} else if rev, ok := parseRevision(c, c.Query("to")); !ok {
+		return
+	} else {
+		to, err = h.store.ConfigRevision(ctx, rev)
+	}
+	if err != nil {
+		revisionError(c, err)
+		return
+	}
+
+	fromRev := to.Rev - 1
+	if c.Query("from") != "" {
+		var ok bool
+		if fromRev, ok = parseRevision(c, c.Query("from")); !ok {
+			return
+		}
+	}
+	var from storage.ConfigRevision
+	if fromRev > 0 {
+		if from, err = h.store.ConfigRevision(ctx, fromRev); err != nil {
+			revisionError(c, err)
+			return
+		}
+	}
+
+	fromLabel := "/dev/null"
+	if from.Rev > 0 {
+		fromLabel = "rev " + strconv.FormatInt(from.Rev, 10)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"from":    from.Rev,
+		"to":      to.Rev,
+		"diff":    config.Diff([]byte(from.Content), []byte(to.Content), fromLabel, "rev "+strconv.FormatInt(to.Rev, 10)),
+	})
+}
+
+// handleConfigRollback restores the config file of an earlier revision,
+// recorded as a new revision. If-Match is required like for updates.
+func (h *Handler) handleConfigRollback(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	target, ok := parseRevision(c, c.Param("rev"))
+	if !ok {
+		return
+	}
+
+	old, err := h.store.ConfigRevision(c.Request.Context(), target)
+	if err != nil {
+		revisionError(c, err)
+		return
+	}
+	// Revisions edited outside gitfetcher were never validated
+	if _, err := config.ParseConfig([]byte(old.Content)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("revision %d is not a valid configuration: %v", target, err),
+		})
+		return
+	}
+
+	rev, err := h.writeConfig(c, []byte(old.Content), ifMatch, storage.ConfigActionRollback,
+		fmt.Sprintf("rolled back to revision %d", target))
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+
+	log.Printf("Configuration rolled back to revision %d by %s", target, principalName(c))
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  fmt.Sprintf("Configuration rolled back to revision %d. It will be reloaded automatically.", target),
+		"revision": rev,
+		"etag":     config.ETag([]byte(old.Content)),
+	})
+}
+
+// parseRevision reads a revision number, answering 400 when it is invalid
+func parseRevision(c *gin.Context, s string) (int64, bool) {
+	rev, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || rev < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "revision must be a positive number",
+		})
+		return 0, false
+	}
+	return rev, true
+}
+
+// revisionError answers 404 for unknown revisions and 500 otherwise
+func revisionError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, storage.ErrRevisionNotFound) {
+		status = http.StatusNotFound
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+// handleGetDeployKey returns the public deploy key generated for a repository
+func (h *Handler) handleGetDeployKey(c *gin.Context) {
+	cfg, err := config.LoadConfig(h.configPath)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"error":   "Failed to save config: " + err.Error(),
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	name := c.Param("name")
+	key, err := deploykey.Load(cfg.EffectiveSecretsPath(), name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   "no deploy key for " + name,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"public_key":  key.PublicKey,
+		"fingerprint": key.Fingerprint,
+		"path":        key.Path,
+	})
+}
+
+// handleGenerateDeployKey creates an ed25519 keypair for a repository under
+// secrets_path and points the repo's ssh_key_path at it. An existing key is
+// only replaced with ?overwrite=true. If-Match is required like for config
+// updates and checked before a key is generated.
+func (h *Handler) handleGenerateDeployKey(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !etagMatches(ifMatch, etag) {
+		writeConfigError(c, errConfigConflict)
+		return
+	}
+
+	name := c.Param("name")
+	i := cfg.FindRepo(name)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "repository not found: " + name,
+		})
+		return
+	}
+	if auth := cfg.Repos[i].Auth; auth != nil && auth.Type != config.AuthSSH {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "repository uses " + auth.Type + " auth, deploy keys require ssh",
+		})
+		return
+	}
+
+	key, err := deploykey.Generate(cfg.EffectiveSecretsPath(), name, "gitfetcher@"+name, c.Query("overwrite") == "true")
+	if err != nil {
+		status := http.StatusInternalServerError
+		exists := errors.Is(err, deploykey.ErrExists)
+		if exists {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+			"exists":  exists,
+		})
+		return
+	}
+
+	// Save config to file (fsnotify will trigger automatic reload). The key
+	// only replaces the previous one once the config points at it.
+	cfg.Repos[i].SSHKeyPath = key.Path
+	data, err := config.MarshalConfig(cfg)
+	if err == nil {
+		_, err = h.writeConfig(c, data, etag, storage.ConfigActionDeployKey, "deploy key for "+name)
+	}
+	if err != nil {
+		key.Discard()
+		writeConfigError(c, err)
+		return
+	}
+	if err := key.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "Add the public key to the repository as a read-only deploy key",
+		"public_key":  key.PublicKey,
+		"fingerprint": key.Fingerprint,
+		"path":        key.Path,
+	})
+}
+
+// healthTimeout bounds how long /healthz waits for the scheduler
+const healthTimeout = 2 * time.Second
+
+// handleHealthz reports whether the process and its scheduler goroutines are alive
+func (h *Handler) handleHealthz(c *gin.Context) {
+	health := h.scheduler.Health(healthTimeout)
+
+	code, status := http.StatusOK, "ok"
+	if !health.Alive() {
+		code, status = http.StatusServiceUnavailable, "fail"
+	}
+	c.JSON(code, gin.H{
+		"status": status,
+		"scheduler": gin.H{
+			"stopped":    health.Stopped,
+			"responsive": health.Responsive,
+			"scheduled":  health.Scheduled,
+			"loops":      health.Loops,
+			"missing":    health.Missing,
+		},
+	})
+}
+
+// check is the outcome of one readiness check
+type check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func checkResult(err error) check {
+	if err != nil {
+		return check{Error: err.Error()}
+	}
+	return check{OK: true}
+}
+
+// staleRepo is a repo without a successful fetch in the staleness window
+type staleRepo struct {
+	Name        string     `json:"name"`
+	LastSuccess *time.Time `json:"last_success"` // null if never fetched successfully
+}
+
+// handleReadyz reports whether gitfetcher can serve up to date mirrors: the
+// config is loaded, SSH keys are readable, the log directory is writable and
+// every repo was fetched successfully within health.stale_after
+func (h *Handler) handleReadyz(c *gin.Context) {
+	cfg := h.scheduler.Config()
+	if cfg == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "fail",
+			"checks": map[string]check{"config": {Error: "config not loaded"}},
+		})
+		return
+	}
+
+	checks := map[string]check{
+		"config":   {OK: true},
+		"ssh_keys": checkResult(checkSSHKeys(cfg)),
+		"log_dir":  checkResult(checkLogDir(cfg.LogPath)),
+	}
+	stale, err := h.staleRepos(cfg)
+	checks["repos"] = checkResult(err)
+
+	code, status := http.StatusOK, "ok"
+	for _, result := range checks {
+		if !result.OK {
+			code, status = http.StatusServiceUnavailable, "fail"
+		}
+	}
+	c.JSON(code, gin.H{
+		"status":      status,
+		"checks":      checks,
+		"stale_repos": stale,
+	})
+}
+
+// checkSSHKeys verifies that every SSH key used by a repo can be read
+func checkSSHKeys(cfg *config.Config) error {
+	seen := make(map[string]bool)
+	for _, repo := range cfg.Repos {
+		repo = cfg.ResolveRepo(repo)
+		if !repo.UsesSSHKey() || seen[repo.SSHKeyPath] {
+			continue
+		}
+		seen[repo.SSHKeyPath] = true
+
+		f, err := os.Open(repo.SSHKeyPath)
+		if err != nil {
+			return fmt.Errorf("repo %s: %w", repo.Name, err)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// checkLogDir verifies that fetch logs can be written, an empty path disables logging
+func checkLogDir(logPath string) error {
+	if logPath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(logPath, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(logPath, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// staleRepos lists the repos, in config order, without a successful fetch
+// within the staleness window. Paused and disabled repos are stale on purpose.
+func (h *Handler) staleRepos(cfg *config.Config) ([]staleRepo, error) {
+	staleAfter, err := cfg.Health.ParseStaleAfter()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := h.scheduler.GetStatus()
+	cutoff := time.Now().Add(-staleAfter)
+	stale := []staleRepo{}
+	for _, repo := range cfg.Repos {
+		status, ok := statuses[repo.Name]
+		if !ok || status.Paused || status.Disabled || !status.LastSuccessAt.Before(cutoff) {
+			continue
+		}
+		entry := staleRepo{Name: repo.Name}
+		if !status.LastSuccessAt.IsZero() {
+			last := status.LastSuccessAt
+			entry.LastSuccess = &last
+		}
+		stale = append(stale, entry)
+	}
+	if len(stale) > 0 {
+		return stale, fmt.Errorf("%d of %d repositories not fetched successfully within %s", len(stale), len(cfg.Repos), staleAfter)
+	}
+	return stale, nil
+}
+
+// SetStore enables the history and stats endpoints
+func (h *Handler) SetStore(db *storage.DB) {
+	h.store = db
+}
+
+// Pagination limits of the history endpoint
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// requireStore answers 503 when no history store is configured
+func (h *Handler) requireStore(c *gin.Context) bool {
+	if h.store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "history storage is not configured",
+		})
+		return false
+	}
+	return true
+}
+
+// handleHistory returns a page of fetch runs of a repository, newest first
+func (h *Handler) handleHistory(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+
+	page, perPage, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	records, total, err := h.store.History(c.Request.Context(), c.Param("name"), perPage, (page-1)*perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"history":  records,
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
+
+// parsePage reads the ?page=&per_page= parameters of paginated endpoints
+func parsePage(c *gin.Context) (page, perPage int, ok bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "page must be a positive number",
+		})
+		return 0, 0, false
+	}
+	perPage, err = strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+	if err != nil || perPage < 1 || perPage > maxPerPage {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "per_page must be between 1 and " + strconv.Itoa(maxPerPage),
+		})
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// parseSince reads the ?since=<duration> window of the stats endpoints, 24h by default
+func parseSince(c *gin.Context) (time.Time, bool) {
+	window, err := time.ParseDuration(c.DefaultQuery("since", "24h"))
+	if err != nil || window <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "since must be a positive duration such as 24h",
+		})
+		return time.Time{}, false
+	}
+	return time.Now().Add(-window), true
+}
+
+// handleRepoStats returns the success rate of a repository
+func (h *Handler) handleRepoStats(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	since, ok := parseSince(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.store.RepoStats(c.Request.Context(), c.Param("name"), since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"since":   since,
+		"stats":   stats,
+	})
+}
+
+// handleStats returns the success rate of every repository with runs in the window
+func (h *Handler) handleStats(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	since, ok := parseSince(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.store.Stats(c.Request.Context(), since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"since":   since,
+		"stats":   stats,
+	})
+}
+
+// requireHostKeys aborts with 503 when no host key store is configured
+func (h *Handler) requireHostKeys(c *gin.Context) bool {
+	if h.hostKeys == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "host key store is not configured",
+		})
+		return false
+	}
+	return true
+}
+
+// handleListHostKeys returns trusted host keys and keys waiting for approval
+func (h *Handler) handleListHostKeys(c *gin.Context) {
+	if !h.requireHostKeys(c) {
+		return
+	}
+
+	known, err := h.hostKeys.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if known == nil {
+		known = []hostkeys.HostKey{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"known":   known,
+		"pending": h.hostKeys.Pending(),
+	})
+}
+
+// handleApproveHostKey trusts a pending host key. The fingerprint must be
+// sent back so a key that changed again in the meantime is not approved blindly.
+func (h *Handler) handleApproveHostKey(c *gin.Context) {
+	if !h.requireHostKeys(c) {
+		return
+	}
+
+	var req struct {
+		Host        string `json:"host" binding:"required"`
+		Fingerprint string `json:"fingerprint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid JSON: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.hostKeys.Approve(req.Host, req.Fingerprint); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "host key approved for " + req.Host,
+	})
+}
+
+// handleRevokeHostKey removes every trusted key of a host
+func (h *Handler) handleRevokeHostKey(c *gin.Context) {
+	if !h.requireHostKeys(c) {
+		return
+	}
+
+	host := c.Param("host")
+	removed, err := h.hostKeys.Revoke(host)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "no host key found for " + host,
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Configuration updated successfully. It will be reloaded automatically.",
+		"message": "host key revoked for " + host,
+		"removed": removed,
 	})
 }
@@ -1,19 +1,32 @@
 package web
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"colosscious.com/gitfetcher/access"
 	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/deploykey"
 	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/hostkeys"
 	"colosscious.com/gitfetcher/scheduler"
+	"colosscious.com/gitfetcher/storage"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 )
 
 func setupTestRouter() (*gin.Engine, *scheduler.Scheduler, string) {
@@ -252,6 +265,7 @@ func TestSetupRoutes(t *testing.T) {
 		{"GET", "/"},
 		{"GET", "/api/status"},
 		{"POST", "/api/fetch/:name"},
+		{"GET", "/metrics"},
 	}
 
 	for _, route := range routes {
@@ -496,6 +510,7 @@ func TestHandleUpdateConfig(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/config", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", "*")
 	router.ServeHTTP(w, req)
 
 	if w.Code != http.StatusOK {
@@ -589,3 +604,953 @@ func TestHandleUpdateConfigInvalidConfig(t *testing.T) {
 		t.Error("Expected error message in response")
 	}
 }
+
+func TestHandleUpdateConfigUnreadableWebAuth(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	// Users and tokens are loaded on reload, one that cannot be read is refused
+	cfg := &config.Config{
+		Repos:    []config.RepoConfig{{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: "/repos/repo1.git", Interval: "5m"}},
+		HTTPPort: 8080,
+		WebAuth:  config.WebAuthConfig{UsersFile: filepath.Join(t.TempDir(), "missing")},
+	}
+	jsonData, _ := json.Marshal(cfg)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/config", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", "*")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "web_auth") {
+		t.Errorf("Expected 400 for an unreadable users file, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHostKeysNotConfigured(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/hostkeys", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a host key store, got %d", w.Code)
+	}
+}
+
+func TestHostKeysApproveAndRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := hostkeys.NewStore(filepath.Join(t.TempDir(), "known_hosts"), false, nil)
+	if err != nil {
+		t.Fatalf("NewStore() failed: %v", err)
+	}
+
+	router := gin.New()
+	handler := NewHandler(scheduler.NewScheduler(fetcher.NewGitFetcher("", "")), "/tmp/test.yaml")
+	handler.SetHostKeyStore(store)
+	handler.SetupRoutes(router)
+
+	// A strict store records unknown keys as pending
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+	store.Check("git.example.com:22", nil, signer.PublicKey())
+
+	var list struct {
+		Known   []hostkeys.HostKey `json:"known"`
+		Pending []hostkeys.HostKey `json:"pending"`
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/hostkeys", nil)
+	router.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if len(list.Known) != 0 || len(list.Pending) != 1 || list.Pending[0].Fingerprint != fingerprint {
+		t.Fatalf("Expected one pending key, got %+v", list)
+	}
+
+	// Wrong fingerprint is refused
+	body, _ := json.Marshal(map[string]string{"host": "git.example.com", "fingerprint": "SHA256:wrong"})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/hostkeys/approve", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for wrong fingerprint, got %d", w.Code)
+	}
+
+	body, _ = json.Marshal(map[string]string{"host": "git.example.com", "fingerprint": fingerprint})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/hostkeys/approve", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := store.Check("git.example.com:22", nil, signer.PublicKey()); err != nil {
+		t.Errorf("Expected approved key to be trusted, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/hostkeys/git.example.com", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/hostkeys/git.example.com", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown host, got %d", w.Code)
+	}
+}
+
+func TestHandleDeployKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	testConfig := &config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "5m",
+			},
+			{
+				Name:      "https-repo",
+				URL:       "https://github.com/user/test.git",
+				LocalPath: "/repos/https.git",
+				Interval:  "5m",
+				Auth:      &config.AuthConfig{Type: config.AuthNone},
+			},
+		},
+		HTTPPort:    8080,
+		SecretsPath: filepath.Join(tmpDir, "secrets"),
+	}
+	if err := config.SaveConfig(configPath, testConfig); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	router := gin.New()
+	NewHandler(scheduler.NewScheduler(fetcher.NewGitFetcher("", "")), configPath).SetupRoutes(router)
+
+	post := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("If-Match", "*")
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := post("/api/repos/test-repo/deploy-key")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", code, response)
+	}
+	publicKey, _ := response["public_key"].(string)
+	if !contains(publicKey, "ssh-ed25519 ") {
+		t.Errorf("Expected ed25519 public key, got %q", publicKey)
+	}
+	if contains(fmt.Sprint(response), "PRIVATE KEY") {
+		t.Error("Private key must never be returned")
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if cfg.Repos[0].SSHKeyPath != response["path"] {
+		t.Errorf("Expected ssh_key_path %v, got %s", response["path"], cfg.Repos[0].SSHKeyPath)
+	}
+
+	if code, response := post("/api/repos/test-repo/deploy-key"); code != http.StatusConflict || response["exists"] != true {
+		t.Errorf("Expected status 409 for existing key, got %d %v", code, response)
+	}
+	code, response = post("/api/repos/test-repo/deploy-key?overwrite=true")
+	if code != http.StatusOK {
+		t.Errorf("Expected status 200 with overwrite, got %d", code)
+	}
+
+	// A key whose config change is rejected does not replace the current one
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/repos/test-repo/deploy-key?overwrite=true", nil)
+	req.Header.Set("If-Match", `"stale"`)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a stale If-Match, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/repos/test-repo/deploy-key?overwrite=true", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected status 428 without If-Match, got %d", w.Code)
+	}
+	if key, err := deploykey.Load(testConfig.SecretsPath, "test-repo"); err != nil || key.Fingerprint != response["fingerprint"] {
+		t.Errorf("Expected the key to be kept after a rejected config change, got %+v (%v)", key, err)
+	}
+	if code, _ := post("/api/repos/https-repo/deploy-key"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for non-ssh repo, got %d", code)
+	}
+	if code, _ := post("/api/repos/missing/deploy-key"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown repo, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/repos/test-repo/deploy-key", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/repos/https-repo/deploy-key", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a key, got %d", w.Code)
+	}
+}
+
+// hangingFetcher blocks every fetch until its context is done
+type hangingFetcher struct{}
+
+func (hangingFetcher) Clone(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	<-ctx.Done()
+	return &fetcher.FetchResult{RepoName: repo.Name, Status: fetcher.StatusCanceled, Timestamp: time.Now()}
+}
+
+func (f hangingFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	return f.Clone(ctx, repo)
+}
+
+func TestHandleCancelFetch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sched := scheduler.NewScheduler(hangingFetcher{})
+	defer sched.Stop()
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	sched.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{
+				Name:      "test-repo",
+				URL:       "git@github.com:user/test.git",
+				LocalPath: "/repos/test.git",
+				Interval:  "1h",
+			},
+		},
+		HTTPPort: 8080,
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	cancel := func(name string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/fetch/"+name+"/cancel", nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := cancel("test-repo"); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if status := sched.GetStatus()["test-repo"]; status.IsRunning || status.LastStatus != fetcher.StatusCanceled {
+		t.Errorf("Expected cancelled fetch, got IsRunning=%v LastStatus=%s", status.IsRunning, status.LastStatus)
+	}
+	if code := cancel("test-repo"); code != http.StatusConflict {
+		t.Errorf("Expected status 409 when nothing is running, got %d", code)
+	}
+	if code := cancel("nonexistent"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown repo, got %d", code)
+	}
+}
+
+func TestHandleQueue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sched := scheduler.NewScheduler(hangingFetcher{})
+	defer sched.Stop()
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	cfg := &config.Config{HTTPPort: 8080, MaxConcurrentFetches: 1}
+	for _, name := range []string{"repo1", "repo2"} {
+		cfg.Repos = append(cfg.Repos, config.RepoConfig{
+			Name:      name,
+			URL:       "git@github.com:user/" + name + ".git",
+			LocalPath: "/repos/" + name + ".git",
+			Interval:  "1h",
+		})
+	}
+	sched.LoadConfig(cfg)
+	time.Sleep(50 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/queue", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response struct {
+		MaxConcurrent int             `json:"max_concurrent"`
+		Pending       []scheduler.Job `json:"pending"`
+		Running       []scheduler.Job `json:"running"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.MaxConcurrent != 1 {
+		t.Errorf("Expected max_concurrent 1, got %d", response.MaxConcurrent)
+	}
+	if len(response.Running) != 1 || len(response.Pending) != 1 {
+		t.Errorf("Expected 1 running and 1 pending job, got %+v and %+v", response.Running, response.Pending)
+	}
+}
+
+func TestHistoryNotConfigured(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	for _, path := range []string{"/api/repos/repo1/history", "/api/repos/repo1/stats", "/api/stats"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503 for %s without a store, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := storage.Open(config.StorageConfig{
+		Driver: config.StorageSQLite,
+		Path:   filepath.Join(t.TempDir(), "gitfetcher.db"),
+	})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		store.RecordFetch(context.Background(), storage.Record{
+			Repo:       "repo1",
+			Status:     "success",
+			Success:    i > 0,
+			StartedAt:  now.Add(-time.Duration(i) * time.Minute),
+			DurationMS: 100,
+		})
+	}
+
+	router := gin.New()
+	handler := NewHandler(scheduler.NewScheduler(fetcher.NewGitFetcher("", "")), "/tmp/test.yaml")
+	handler.SetStore(store)
+	handler.SetupRoutes(router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/repos/repo1/history?page=2&per_page=2", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var history struct {
+		History []storage.Record `json:"history"`
+		Page    int              `json:"page"`
+		Total   int              `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if history.Total != 3 || history.Page != 2 || len(history.History) != 1 {
+		t.Errorf("Expected the last of 3 runs on page 2, got %+v", history)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/repos/repo1/history?per_page=1000", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for oversized page, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/repos/repo1/stats?since=1h", nil)
+	router.ServeHTTP(w, req)
+	var stats struct {
+		Stats storage.Stats `json:"stats"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if stats.Stats.Total != 3 || stats.Stats.Successes != 2 {
+		t.Errorf("Expected 3 runs with 2 successes, got %+v", stats.Stats)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/stats?since=forever", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid since, got %d", w.Code)
+	}
+}
+
+// okFetcher succeeds for every repo
+type okFetcher struct{}
+
+func (f okFetcher) Clone(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	return f.Fetch(ctx, repo)
+}
+
+func (okFetcher) Fetch(ctx context.Context, repo config.RepoConfig) *fetcher.FetchResult {
+	return &fetcher.FetchResult{RepoName: repo.Name, Success: true, Status: fetcher.StatusSuccess, Timestamp: time.Now()}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	probe := func() (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		router.ServeHTTP(w, req)
+		var response struct {
+			Status string `json:"status"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Status
+	}
+
+	if code, status := probe(); code != http.StatusOK || status != "ok" {
+		t.Errorf("Expected 200 ok, got %d %s", code, status)
+	}
+	sched.Stop()
+	if code, status := probe(); code != http.StatusServiceUnavailable || status != "fail" {
+		t.Errorf("Expected 503 fail after Stop, got %d %s", code, status)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	defer sched.Stop()
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	type readiness struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		} `json:"checks"`
+		StaleRepos []struct {
+			Name string `json:"name"`
+		} `json:"stale_repos"`
+	}
+	probe := func() (int, readiness) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		router.ServeHTTP(w, req)
+		var response readiness
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return w.Code, response
+	}
+
+	if code, response := probe(); code != http.StatusServiceUnavailable || response.Checks["config"].OK {
+		t.Errorf("Expected 503 before the config is loaded, got %d %+v", code, response)
+	}
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	os.WriteFile(keyPath, []byte("key"), 0600)
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: "/repos/repo1.git", Interval: "1h"},
+			{Name: "nightly", URL: "git@github.com:user/nightly.git", LocalPath: "/repos/nightly.git", Interval: "0 0 1 1 *"},
+		},
+		SSHKeyPath: keyPath,
+		HTTPPort:   8080,
+		LogPath:    filepath.Join(dir, "logs"),
+	}
+	sched.LoadConfig(cfg)
+	time.Sleep(50 * time.Millisecond)
+
+	// The cron repo has not been fetched yet
+	code, response := probe()
+	if code != http.StatusServiceUnavailable || response.Checks["repos"].OK {
+		t.Errorf("Expected 503 with a never fetched repo, got %d %+v", code, response)
+	}
+	if len(response.StaleRepos) != 1 || response.StaleRepos[0].Name != "nightly" {
+		t.Errorf("Expected nightly to be stale, got %+v", response.StaleRepos)
+	}
+	if !response.Checks["ssh_keys"].OK || !response.Checks["log_dir"].OK {
+		t.Errorf("Expected ssh_keys and log_dir checks to pass, got %+v", response.Checks)
+	}
+
+	sched.ManualFetch("nightly")
+	time.Sleep(50 * time.Millisecond)
+	if code, response := probe(); code != http.StatusOK || response.Status != "ok" {
+		t.Errorf("Expected 200 once every repo was fetched, got %d %+v", code, response)
+	}
+
+	os.Remove(keyPath)
+	if code, response := probe(); code != http.StatusServiceUnavailable || response.Checks["ssh_keys"].OK {
+		t.Errorf("Expected 503 with an unreadable SSH key, got %d %+v", code, response)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("GET /api/events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", ct)
+	}
+
+	sched.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: "/repos/repo1.git", Interval: "1h"},
+		},
+		HTTPPort: 8080,
+	})
+
+	// Read until the first fetch finished
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var seen []string
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("Stream closed early, got %v", seen)
+			}
+			if strings.HasPrefix(line, "event:") {
+				seen = append(seen, strings.TrimPrefix(line, "event:"))
+			}
+			if strings.HasPrefix(line, "data:") && strings.Contains(line, `"type":"fetch_finished"`) {
+				if !strings.Contains(line, `"repo":"repo1"`) || !strings.Contains(line, `"SuccessCount":1`) {
+					t.Errorf("Expected a status snapshot in the event, got %s", line)
+				}
+				if seen[0] != "config_reloaded" {
+					t.Errorf("Expected config_reloaded first, got %v", seen)
+				}
+				// Stop closes the stream
+				sched.Stop()
+				for range lines {
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for fetch_finished, got %v", seen)
+		}
+	}
+}
+
+// setupAuthRouter returns a router requiring authentication with one user per
+// role (password "<name>-pw") and an operator token "ci-secret"
+func setupAuthRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	var users strings.Builder
+	for _, role := range []string{config.RoleViewer, config.RoleOperator, config.RoleAdmin} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(role+"-pw"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&users, "%s:%s:%s\n", role, hash, role)
+	}
+	usersFile := filepath.Join(dir, "users")
+	if err := os.WriteFile(usersFile, []byte(users.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_GITFETCHER_CI_TOKEN", "ci-secret")
+
+	auth, err := access.New(config.WebAuthConfig{
+		UsersFile: usersFile,
+		Tokens:    []config.APIToken{{Name: "ci", Role: config.RoleOperator, TokenEnv: "TEST_GITFETCHER_CI_TOKEN"}},
+	})
+	if err != nil {
+		t.Fatalf("access.New() failed: %v", err)
+	}
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	t.Cleanup(sched.Stop)
+	handler := NewHandler(sched, filepath.Join(dir, "config.yaml"))
+	handler.SetAuthenticator(auth)
+	router := gin.New()
+	handler.SetupRoutes(router)
+	return router
+}
+
+func TestAuthRoles(t *testing.T) {
+	router := setupAuthRouter(t)
+
+	tests := []struct {
+		method, path string
+		user         string // basic auth user, "ci" for the token, empty for none
+		want         int
+	}{
+		{"GET", "/", "", http.StatusOK},
+		{"GET", "/healthz", "", http.StatusOK},
+		{"GET", "/api/status", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "", http.StatusUnauthorized},
+		{"GET", "/api/status", "viewer", http.StatusOK},
+		{"GET", "/metrics", "viewer", http.StatusOK},
+		{"POST", "/api/fetch/repo1", "viewer", http.StatusForbidden},
+		{"POST", "/api/fetch/repo1", "operator", http.StatusOK},
+		{"POST", "/api/fetch/repo1", "ci", http.StatusOK},
+		{"POST", "/api/config", "operator", http.StatusForbidden},
+		{"POST", "/api/config", "ci", http.StatusForbidden},
+		{"POST", "/api/config", "admin", http.StatusBadRequest}, // allowed, empty body
+		{"DELETE", "/api/hostkeys/github.com", "operator", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		switch tt.user {
+		case "":
+		case "ci":
+			req.Header.Set("Authorization", "Bearer ci-secret")
+		default:
+			req.SetBasicAuth(tt.user, tt.user+"-pw")
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s %s as %q: expected %d, got %d: %s", tt.method, tt.path, tt.user, tt.want, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: expected a WWW-Authenticate header", tt.method, tt.path)
+		}
+	}
+}
+
+func TestLoginSession(t *testing.T) {
+	router := setupAuthRouter(t)
+
+	post := func(path, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	me := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/me", nil)
+		req.AddCookie(cookie)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/api/login", `{"username": "operator", "password": "wrong"}`, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong password, got %d", w.Code)
+	}
+
+	w := post("/api/login", `{"username": "operator", "password": "operator-pw"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == access.SessionCookie {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly || session.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected an HttpOnly SameSite=Strict session cookie, got %+v", session)
+	}
+
+	w = me(session)
+	var response struct {
+		AuthEnabled bool             `json:"auth_enabled"`
+		User        access.Principal `json:"user"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || !response.AuthEnabled || response.User.Name != "operator" || response.User.Role != config.RoleOperator {
+		t.Errorf("Expected operator session, got %d %s", w.Code, w.Body.String())
+	}
+	if w := post("/api/config", `{}`, session); w.Code != http.StatusForbidden {
+		t.Errorf("Expected operator session to be refused config edits, got %d", w.Code)
+	}
+
+	if w := post("/api/logout", "", session); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 on logout, got %d", w.Code)
+	}
+	if w := me(session); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after logout, got %d", w.Code)
+	}
+}
+
+func TestHandleMeWithoutAuth(t *testing.T) {
+	router, sched, _ := setupTestRouter()
+	defer sched.Stop()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/me", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"auth_enabled":false`) {
+		t.Errorf("Expected auth_enabled false, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConfigRevisions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+
+	store, err := storage.Open(config.StorageConfig{
+		Driver: config.StorageSQLite,
+		Path:   filepath.Join(dir, "gitfetcher.db"),
+	})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer store.Close()
+
+	configPath := filepath.Join(dir, "config.yaml")
+	original := "repos:\n  - name: repo1\n    url: git@github.com:user/repo1.git\n    local_path: /repos/repo1.git\n    interval: 5m\nhttp_port: 8080\n"
+	if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	handler := NewHandler(scheduler.NewScheduler(fetcher.NewGitFetcher("", "")), configPath)
+	handler.SetStore(store)
+	handler.SetupRoutes(router)
+
+	do := func(method, path string, body any) (int, map[string]any) {
+		var reader io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
+		router.ServeHTTP(w, req)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	updated, _ := config.LoadConfig(configPath)
+	updated.Repos[0].Interval = "1h"
+
+	// A dry run returns the diff and leaves the file alone
+	code, response := do("POST", "/api/config?dry_run=true", updated)
+	if code != http.StatusOK || !strings.Contains(response["diff"].(string), "interval: 1h") {
+		t.Fatalf("Expected a diff preview, got %d %v", code, response)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != original {
+		t.Fatal("Expected dry run not to write the config file")
+	}
+
+	// The hand-written file becomes revision 1, the update revision 2
+	code, response = do("POST", "/api/config", updated)
+	if code != http.StatusOK || response["revision"] != float64(2) {
+		t.Fatalf("Expected revision 2, got %d %v", code, response)
+	}
+
+	code, response = do("GET", "/api/config/history", nil)
+	revisions, _ := response["revisions"].([]any)
+	if code != http.StatusOK || len(revisions) != 2 || response["total"] != float64(2) {
+		t.Fatalf("Expected 2 revisions, got %d %v", code, response)
+	}
+	latest := revisions[0].(map[string]any)
+	if latest["actor"] != "anonymous" || latest["action"] != storage.ConfigActionUpdate || !strings.Contains(latest["diff"].(string), "-    interval: 5m\n") {
+		t.Errorf("Expected the update as newest revision, got %v", latest)
+	}
+	if first := revisions[1].(map[string]any); first["action"] != storage.ConfigActionFile {
+		t.Errorf("Expected the original file as first revision, got %v", first)
+	}
+
+	code, response = do("GET", "/api/config/diff?from=1&to=2", nil)
+	if code != http.StatusOK || !strings.HasPrefix(response["diff"].(string), "--- rev 1\n+++ rev 2\n") {
+		t.Errorf("Expected diff between revisions 1 and 2, got %d %v", code, response)
+	}
+	if code, _ := do("GET", "/api/config/diff?from=7", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown revision, got %d", code)
+	}
+	if code, _ := do("GET", "/api/config/diff?to=latest", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid revision, got %d", code)
+	}
+
+	code, response = do("POST", "/api/config/rollback/1", nil)
+	if code != http.StatusOK || response["revision"] != float64(3) {
+		t.Fatalf("Expected rollback to be revision 3, got %d %v", code, response)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != original {
+		t.Errorf("Expected the original file after rollback, got\n%s", data)
+	}
+	if code, _ := do("POST", "/api/config/rollback/9", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 rolling back to an unknown revision, got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/config/rollback/2", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected 428 rolling back without If-Match, got %d", w.Code)
+	}
+}
+
+func TestUpdateConfigIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	original := "repos:\n  - name: repo1\n    url: git@github.com:user/repo1.git\n    local_path: /repos/repo1.git\n    interval: 5m\nhttp_port: 8080\n"
+	if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	NewHandler(scheduler.NewScheduler(fetcher.NewGitFetcher("", "")), configPath).SetupRoutes(router)
+
+	post := func(cfg *config.Config, ifMatch string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(cfg)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/config", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/config", nil)
+	router.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag != config.ETag([]byte(original)) {
+		t.Fatalf("Expected the ETag of the file, got '%s'", etag)
+	}
+
+	cfg, _ := config.LoadConfig(configPath)
+	cfg.Repos[0].Interval = "1h"
+	if w := post(cfg, ""); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected 428 without If-Match, got %d", w.Code)
+	}
+
+	// The first of two editors of the same version wins
+	w = post(cfg, etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	saved, _ := os.ReadFile(configPath)
+	if w.Header().Get("ETag") != config.ETag(saved) {
+		t.Errorf("Expected the ETag of the saved file, got '%s'", w.Header().Get("ETag"))
+	}
+
+	cfg.Repos[0].Interval = "2h"
+	if w := post(cfg, etag); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a stale ETag, got %d", w.Code)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != string(saved) {
+		t.Error("Expected a conflicting update not to write the config file")
+	}
+}
+
+func TestReloadAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	sched := scheduler.NewScheduler(okFetcher{})
+	defer sched.Stop()
+	handler := NewHandler(sched, filepath.Join(dir, "config.yaml"))
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	status := func(token string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/status", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	tokens := func(env ...string) config.WebAuthConfig {
+		var cfg config.WebAuthConfig
+		for _, name := range env {
+			cfg.Tokens = append(cfg.Tokens, config.APIToken{Name: name, Role: config.RoleViewer, TokenEnv: name})
+		}
+		return cfg
+	}
+	t.Setenv("TEST_GITFETCHER_OLD_TOKEN", "old-secret")
+	t.Setenv("TEST_GITFETCHER_NEW_TOKEN", "new-secret")
+
+	if got := status(""); got != http.StatusOK {
+		t.Fatalf("Expected the API to be open without web_auth, got %d", got)
+	}
+
+	// Tokens added to the config take effect without a restart
+	if err := handler.ReloadAuth(tokens("TEST_GITFETCHER_OLD_TOKEN")); err != nil {
+		t.Fatalf("ReloadAuth() failed: %v", err)
+	}
+	if got := status(""); got != http.StatusUnauthorized {
+		t.Errorf("Expected 401 once web_auth is added, got %d", got)
+	}
+	if got := status("old-secret"); got != http.StatusOK {
+		t.Errorf("Expected the added token to be accepted, got %d", got)
+	}
+
+	// A revoked token stops working
+	if err := handler.ReloadAuth(tokens("TEST_GITFETCHER_NEW_TOKEN")); err != nil {
+		t.Fatalf("ReloadAuth() failed: %v", err)
+	}
+	if got := status("old-secret"); got != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked token to be refused, got %d", got)
+	}
+
+	// Users and tokens that cannot be read keep the previous ones
+	if err := handler.ReloadAuth(tokens("TEST_GITFETCHER_UNSET_TOKEN")); err == nil {
+		t.Error("Expected an error for a token that cannot be read")
+	}
+	if got := status("new-secret"); got != http.StatusOK {
+		t.Errorf("Expected the previous token to be kept, got %d", got)
+	}
+
+	if err := handler.ReloadAuth(config.WebAuthConfig{}); err != nil || status("") != http.StatusOK {
+		t.Errorf("Expected the API to be open again without web_auth, got %v", err)
+	}
+}
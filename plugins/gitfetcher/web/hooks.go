@@ -0,0 +1,126 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"colosscious.com/gitfetcher/webhook"
+	"github.com/gin-gonic/gin"
+)
+
+// maxHookBody is the largest webhook payload accepted, GitHub caps theirs at 25 MB
+const maxHookBody = 25 << 20
+
+// handleHook accepts a push webhook of the :provider, verifies it against the
+// shared secret and fetches every configured repo with the pushed URL once
+// the debounce delay has passed
+func (h *Handler) handleHook(c *gin.Context) {
+	provider := c.Param("provider")
+	cfg := h.scheduler.Config()
+	if cfg == nil || !cfg.Webhooks.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "webhooks are not configured",
+		})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxHookBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"success": false,
+			"error":   "failed to read payload: " + err.Error(),
+		})
+		return
+	}
+	secret, err := cfg.Webhooks.Secret()
+	if err != nil {
+		log.Printf("Webhook secret unavailable: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "webhook secret unavailable",
+		})
+		return
+	}
+	if err := webhook.Verify(provider, c.Request.Header, body, secret); err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, webhook.ErrUnknownProvider) {
+			status = http.StatusNotFound
+		} else {
+			log.Printf("Rejected %s webhook from %s: %v", provider, c.ClientIP(), err)
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	event, err := webhook.Parse(provider, c.Request.Header, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	switch event.Kind {
+	case webhook.KindPing:
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "pong",
+		})
+		return
+	case webhook.KindIgnore:
+		c.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"message": "event '" + event.Name + "' ignored",
+		})
+		return
+	}
+
+	delay, _ := cfg.Webhooks.ParseDebounce()
+	statuses := h.scheduler.GetStatus()
+	fetching, skipped := []string{}, []string{}
+	for _, repo := range cfg.Repos {
+		if !event.Matches(repo.URL) {
+			continue
+		}
+		if status, ok := statuses[repo.Name]; !ok || status.Disabled || status.Paused {
+			skipped = append(skipped, repo.Name)
+			continue
+		}
+		fetching = append(fetching, repo.Name)
+		if h.pushes.Trigger(repo.Name, delay, func() { h.fetchPushed(repo.Name, delay) }) {
+			log.Printf("Push webhook from %s for %s, fetching in %s", provider, repo.Name, delay)
+		}
+	}
+
+	message := "no configured repository matches"
+	if len(fetching) > 0 {
+		message = "fetch scheduled"
+	} else if len(skipped) > 0 {
+		message = "matching repositories are paused or disabled"
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": message,
+		"repos":   fetching,
+		"skipped": skipped,
+	})
+}
+
+// fetchPushed fetches a pushed repo. A push that arrived while the repo was
+// being fetched may not be included, so it is retried once that fetch is done.
+func (h *Handler) fetchPushed(name string, delay time.Duration) {
+	if status, ok := h.scheduler.GetStatus()[name]; ok && status.IsRunning {
+		h.pushes.Trigger(name, delay, func() { h.fetchPushed(name, delay) })
+		return
+	}
+	if err := h.scheduler.ManualFetch(name); err != nil {
+		log.Printf("Push webhook fetch of %s failed: %v", name, err)
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleHook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("GITFETCHER_HOOK_SECRET", "s3cret")
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	defer sched.Stop()
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	hook := func(provider string, header map[string]string, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/hooks/"+provider, bytes.NewBufferString(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	github := func(event, body, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return hook("github", map[string]string{
+			"X-GitHub-Event":      event,
+			"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+		}, body)
+	}
+	push := `{"ref": "refs/heads/main", "repository": {"clone_url": "https://github.com/user/repo1.git", "ssh_url": "git@github.com:user/repo1.git"}}`
+
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			// Cron schedules that do not fire during the test
+			{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: "/repos/repo1.git", Interval: "0 0 1 1 *"},
+			{Name: "repo2", URL: "git@gitlab.com:group/repo2.git", LocalPath: "/repos/repo2.git", Interval: "0 0 1 1 *"},
+		},
+		HTTPPort: 8080,
+	}
+	sched.LoadConfig(cfg)
+	if code := github("push", push, "s3cret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 while webhooks are not configured, got %d", code)
+	}
+
+	cfg.Webhooks = config.WebhookConfig{SecretEnv: "GITFETCHER_HOOK_SECRET", Debounce: "50ms"}
+	sched.LoadConfig(cfg)
+
+	if code := github("push", push, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a bad signature, got %d", code)
+	}
+	if code := github("ping", `{"zen": "hi"}`, "s3cret"); code != http.StatusOK {
+		t.Errorf("Expected 200 for a ping, got %d", code)
+	}
+	if code := github("issues", `{}`, "s3cret"); code != http.StatusAccepted {
+		t.Errorf("Expected 202 for an ignored event, got %d", code)
+	}
+	if code := hook("bitbucket", nil, push); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown provider, got %d", code)
+	}
+
+	// A burst of pushes results in one fetch
+	for i := 0; i < 3; i++ {
+		if code := github("push", push, "s3cret"); code != http.StatusAccepted {
+			t.Fatalf("Expected 202 for a push, got %d", code)
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	statuses := sched.GetStatus()
+	if statuses["repo1"].FetchCount != 1 || statuses["repo2"].FetchCount != 0 {
+		t.Errorf("Expected a single fetch of repo1, got repo1=%d repo2=%d", statuses["repo1"].FetchCount, statuses["repo2"].FetchCount)
+	}
+
+	gitlab := `{"object_kind": "push", "project": {"git_http_url": "https://gitlab.com/group/repo2.git"}}`
+	if code := hook("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, gitlab); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a bad GitLab token, got %d", code)
+	}
+	if code := hook("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "s3cret"}, gitlab); code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a GitLab push, got %d", code)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if count := sched.GetStatus()["repo2"].FetchCount; count != 1 {
+		t.Errorf("Expected the GitLab push to fetch repo2, got %d fetches", count)
+	}
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/redmine"
+	"colosscious.com/gitfetcher/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// redmineQueryTimeout bounds reading Redmine's repositories table
+const redmineQueryTimeout = 30 * time.Second
+
+// handleProposeRedmineImport lists the repos that importing from Redmine
+// would add, without changing the config
+func (h *Handler) handleProposeRedmineImport(c *gin.Context) {
+	_, etag, proposals, ok := h.proposeRedmineImport(c, c.Query("interval"))
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"proposals": proposals,
+	})
+}
+
+// handleRedmineImport adds the proposed repos to the config. The body may
+// set the interval of the new repos and, by repo name, the upstream URLs
+// that could not be inferred; proposals still without a URL are left out.
+func (h *Handler) handleRedmineImport(c *gin.Context) {
+	var req struct {
+		Interval string            `json:"interval"`
+		URLs     map[string]string `json:"urls"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid JSON: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	cfg, etag, proposals, ok := h.proposeRedmineImport(c, req.Interval)
+	if !ok {
+		return
+	}
+	added := redmine.Apply(cfg, proposals, req.URLs)
+	if len(added) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"message":   "nothing to import",
+			"added":     added,
+			"proposals": proposals,
+		})
+		return
+	}
+
+	message := fmt.Sprintf("imported %d repositories from Redmine: %s", len(added), strings.Join(added, ", "))
+	if !h.saveConfig(c, cfg, etag, storage.ConfigActionImport, message) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   message,
+		"added":     added,
+		"proposals": proposals,
+	})
+}
+
+// proposeRedmineImport reads the config and Redmine's repositories and
+// proposes repos, answering errors itself
+func (h *Handler) proposeRedmineImport(c *gin.Context, interval string) (*config.Config, string, []redmine.Proposal, bool) {
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, "", nil, false
+	}
+	if interval != "" {
+		if _, err := (&config.RepoConfig{Interval: interval}).ParseSchedule(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("invalid interval '%s': %v", interval, err),
+			})
+			return nil, "", nil, false
+		}
+	}
+	db, ok := cfg.RedmineDatabase()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Redmine's database is unknown, set redmine.database or use postgres storage",
+		})
+		return nil, "", nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), redmineQueryTimeout)
+	defer cancel()
+	repos, err := h.redmineRepos(ctx, db)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, "", nil, false
+	}
+
+	opts := redmine.Options(cfg, interval)
+	opts.UpstreamURL = fetcher.OriginURL
+	return cfg, etag, redmine.Propose(repos, cfg, opts), true
+}
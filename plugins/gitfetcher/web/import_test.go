@@ -0,0 +1,78 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/redmine"
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedmineImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "mirrored", URL: "git@github.com:user/mirrored.git", LocalPath: "/repos/mirrored.git", Interval: "5m"},
+		},
+		HTTPPort: 8080,
+	}
+	if err := config.SaveConfig(configPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	sched := scheduler.NewScheduler(fetcher.NewGitFetcher("", ""))
+	defer sched.Stop()
+	handler := NewHandler(sched, configPath)
+	handler.redmineRepos = func(ctx context.Context, db config.PostgresConfig) ([]redmine.Repository, error) {
+		return []redmine.Repository{
+			{ID: 1, Project: "mirrored", URL: "/usr/src/redmine/repositories/mirrored.git"},
+			{ID: 2, Project: "app", URL: "/usr/src/redmine/repositories/app.git"},
+		}, nil
+	}
+	router := gin.New()
+	handler.SetupRoutes(router)
+
+	if code, _ := doJSON(router, "GET", "/api/import/redmine", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a Redmine database, got %d", code)
+	}
+
+	cfg.Redmine = &config.RedmineConfig{Database: &config.PostgresConfig{Host: "postgres", Name: "redmine", User: "redmine"}}
+	if err := config.SaveConfig(configPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	code, response := doJSON(router, "GET", "/api/import/redmine", "")
+	proposals, _ := response["proposals"].([]any)
+	if code != http.StatusOK || len(proposals) != 2 {
+		t.Fatalf("Expected 2 proposals, got %d %v", code, response)
+	}
+	if status := proposals[1].(map[string]any)["status"]; status != redmine.ProposalNeedsURL {
+		t.Errorf("Expected app to need an upstream URL, got %v", status)
+	}
+	if code, _ := doJSON(router, "GET", "/api/import/redmine?interval=soon", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid interval, got %d", code)
+	}
+
+	if code, response := doJSON(router, "POST", "/api/import/redmine", ""); code != http.StatusOK || response["message"] != "nothing to import" {
+		t.Errorf("Expected nothing to import without URLs, got %d %v", code, response)
+	}
+
+	code, response = doJSON(router, "POST", "/api/import/redmine", `{"interval": "1h", "urls": {"app": "git@github.com:user/app.git"}}`)
+	if added, _ := response["added"].([]any); code != http.StatusOK || len(added) != 1 {
+		t.Fatalf("Expected app to be added, got %d %v", code, response)
+	}
+	saved, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := saved.FindRepo("app")
+	if i < 0 || saved.Repos[i].LocalPath != "/repos/app.git" || saved.Repos[i].Interval != "1h" {
+		t.Errorf("Expected app to be saved, got %+v", saved.Repos)
+	}
+}
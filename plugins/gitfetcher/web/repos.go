@@ -0,0 +1,451 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/scheduler"
+	"colosscious.com/gitfetcher/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// handleListRepos returns the configured repositories as written in the config file
+func (h *Handler) handleListRepos(c *gin.Context) {
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"repos":   cfg.Repos,
+	})
+}
+
+// handleGetRepo returns the config of a single repository
+func (h *Handler) handleGetRepo(c *gin.Context) {
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	i, ok := findRepo(c, cfg)
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"repo":    cfg.Repos[i],
+	})
+}
+
+// handleCreateRepo appends a repository to the config
+func (h *Handler) handleCreateRepo(c *gin.Context) {
+	var repo config.RepoConfig
+	if !bindRepo(c, &repo) {
+		return
+	}
+
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if cfg.FindRepo(repo.Name) >= 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "repository already exists: " + repo.Name,
+		})
+		return
+	}
+
+	cfg.Repos = append(cfg.Repos, repo)
+	if !h.saveConfig(c, cfg, etag, storage.ConfigActionRepo, "added repository "+repo.Name) {
+		return
+	}
+
+	log.Printf("Repository %s added by %s", repo.Name, principalName(c))
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Repository " + repo.Name + " added. It will be fetched once the config is reloaded.",
+		"repo":    repo,
+	})
+}
+
+// handleUpdateRepo applies a JSON merge patch (RFC 7386) to a repository:
+// fields in the body replace the current ones, null removes a field
+func (h *Handler) handleUpdateRepo(c *gin.Context) {
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil || !json.Valid(patch) || !bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid JSON: the body must be an object",
+		})
+		return
+	}
+
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	i, ok := findRepo(c, cfg)
+	if !ok {
+		return
+	}
+
+	repo, err := mergeRepo(cfg.Repos[i], patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid patch: " + err.Error(),
+		})
+		return
+	}
+	name := cfg.Repos[i].Name
+	if repo.Name != name && cfg.FindRepo(repo.Name) >= 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "repository already exists: " + repo.Name,
+		})
+		return
+	}
+
+	cfg.Repos[i] = repo
+	if !h.saveConfig(c, cfg, etag, storage.ConfigActionRepo, "updated repository "+name) {
+		return
+	}
+
+	log.Printf("Repository %s updated by %s", name, principalName(c))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Repository " + name + " updated. It will be reloaded automatically.",
+		"repo":    repo,
+	})
+}
+
+// handleDeleteRepo removes a repository from the config. With
+// ?remove_mirror=true&confirm=<name> its local_path is deleted from disk too.
+func (h *Handler) handleDeleteRepo(c *gin.Context) {
+	cfg, etag, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	i, ok := findRepo(c, cfg)
+	if !ok {
+		return
+	}
+	repo := cfg.Repos[i]
+	cfg.Repos = append(cfg.Repos[:i:i], cfg.Repos[i+1:]...)
+
+	removeMirror := c.Query("remove_mirror") == "true"
+	if removeMirror {
+		if c.Query("confirm") != repo.Name {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "removing the mirror requires confirm=" + repo.Name,
+			})
+			return
+		}
+		// Discovered repos are only known to the scheduler
+		repos := cfg.Repos
+		if running := h.scheduler.Config(); running != nil {
+			repos = append(repos[:len(repos):len(repos)], running.Repos...)
+		}
+		_, root := cfg.Redmine.Paths()
+		if err := checkMirrorRemovable(root, repos, repo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if !h.saveConfig(c, cfg, etag, storage.ConfigActionRepo, "removed repository "+repo.Name) {
+		return
+	}
+	log.Printf("Repository %s removed by %s", repo.Name, principalName(c))
+
+	if removeMirror {
+		// Stop the repo now rather than on the next reload and wait for its
+		// fetch to finish, so nothing writes into the mirror while it is deleted
+		if err := h.scheduler.RemoveRepo(c.Request.Context(), repo.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   "Repository removed from config, but its fetch did not finish: " + err.Error(),
+			})
+			return
+		}
+		if err := os.RemoveAll(repo.LocalPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   "Repository removed from config, but failed to remove mirror: " + err.Error(),
+			})
+			return
+		}
+		log.Printf("Mirror %s of %s removed by %s", repo.LocalPath, repo.Name, principalName(c))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"message":        "Repository " + repo.Name + " removed.",
+		"mirror_removed": removeMirror,
+	})
+}
+
+// handlePauseRepo stops scheduled fetches of a repository. The optional body
+// sets when the pause ends, as an RFC 3339 time or a duration, and why.
+func (h *Handler) handlePauseRepo(c *gin.Context) {
+	var req struct {
+		Until    time.Time `json:"until"`
+		Duration string    `json:"duration"`
+		Reason   string    `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid JSON: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	until := req.Until
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || !until.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "duration must be a positive duration such as 2h, and not be combined with until",
+			})
+			return
+		}
+		until = time.Now().Add(d)
+	}
+	if !until.IsZero() && !until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "until must be in the future",
+		})
+		return
+	}
+
+	name := c.Param("name")
+	pause, err := h.scheduler.Pause(name, until, principalName(c), req.Reason)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrUnknownRepo) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "scheduled fetches paused for " + name,
+		"pause":   pause,
+	})
+}
+
+// handleResumeRepo lifts the pause of a repository
+func (h *Handler) handleResumeRepo(c *gin.Context) {
+	name := c.Param("name")
+	if err := h.scheduler.Resume(name); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, scheduler.ErrUnknownRepo):
+			status = http.StatusNotFound
+		case errors.Is(err, scheduler.ErrNotPaused):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "scheduled fetches resumed for " + name,
+	})
+}
+
+// bindRepo decodes a repository from the request body, rejecting unknown
+// fields so typos are not silently dropped
+func bindRepo(c *gin.Context, repo *config.RepoConfig) bool {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(repo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid JSON: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// findRepo returns the index of the :name repository, answering 404 when it is unknown
+func findRepo(c *gin.Context, cfg *config.Config) (int, bool) {
+	name := c.Param("name")
+	i := cfg.FindRepo(name)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "repository not found: " + name,
+		})
+		return 0, false
+	}
+	return i, true
+}
+
+// saveConfig validates and writes cfg, answering the error itself. etag is the
+// version cfg was read from. A missing If-Match header is answered with 428,
+// a concurrent write or an If-Match header that names another version with 409.
+func (h *Handler) saveConfig(c *gin.Context, cfg *config.Config, etag, action, message string) bool {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return false
+	}
+	if !etagMatches(ifMatch, etag) {
+		writeConfigError(c, errConfigConflict)
+		return false
+	}
+
+	data, err := config.MarshalConfig(cfg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid configuration: " + err.Error(),
+		})
+		return false
+	}
+
+	// Save config to file (fsnotify will trigger automatic reload)
+	if _, err := h.writeConfig(c, data, etag, action, message); err != nil {
+		writeConfigError(c, err)
+		return false
+	}
+	c.Header("ETag", config.ETag(data))
+	return true
+}
+
+// mergeRepo applies a JSON merge patch to repo
+func mergeRepo(repo config.RepoConfig, patch []byte) (config.RepoConfig, error) {
+	current, err := json.Marshal(repo)
+	if err != nil {
+		return repo, err
+	}
+	var target, changes map[string]any
+	if err := json.Unmarshal(current, &target); err != nil {
+		return repo, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return repo, err
+	}
+
+	merged, err := json.Marshal(mergePatch(target, changes))
+	if err != nil {
+		return repo, err
+	}
+	var result config.RepoConfig
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return repo, err
+	}
+	return result, nil
+}
+
+// mergePatch implements the RFC 7386 merge of patch into target
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = make(map[string]any)
+	}
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]any:
+			sub, _ := target[key].(map[string]any)
+			target[key] = mergePatch(sub, value)
+		default:
+			target[key] = value
+		}
+	}
+	return target
+}
+
+// checkMirrorRemovable refuses to delete a local_path that is not a
+// dedicated directory of repo below root, the mirrors volume. repos are the
+// other repositories, entries named like repo are skipped.
+func checkMirrorRemovable(root string, repos []config.RepoConfig, repo config.RepoConfig) error {
+	path := filepath.Clean(repo.LocalPath)
+	if !filepath.IsAbs(path) || !isWithin(path, filepath.Clean(root)) {
+		return fmt.Errorf("refusing to remove local_path '%s', it must be an absolute path below %s", repo.LocalPath, root)
+	}
+	for _, other := range repos {
+		if other.Name == repo.Name {
+			continue
+		}
+		otherPath := filepath.Clean(other.LocalPath)
+		if otherPath == path || isWithin(otherPath, path) || isWithin(path, otherPath) {
+			return fmt.Errorf("refusing to remove local_path '%s', it overlaps with repository %s", repo.LocalPath, other.Name)
+		}
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("refusing to remove local_path '%s', it is not a directory", repo.LocalPath)
+	}
+	return nil
+}
+
+// isWithin reports whether path lies inside dir
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,232 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/fetcher"
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+// setupReposRouter serves a config with repo1 and repo2, mirrored below dir,
+// which is the mirrors root
+func setupReposRouter(t *testing.T) (router *gin.Engine, configPath, dir string) {
+	gin.SetMode(gin.TestMode)
+	dir = t.TempDir()
+	configPath = filepath.Join(dir, "config.yaml")
+	cfg := &config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: filepath.Join(dir, "repo1.git"), Interval: "5m"},
+			{Name: "repo2", URL: "git@github.com:user/repo2.git", LocalPath: filepath.Join(dir, "repo2.git"), Interval: "1h", Timeout: "10m"},
+		},
+		HTTPPort: 8080,
+		Redmine:  &config.RedmineConfig{MirrorsPath: dir},
+	}
+	if err := config.SaveConfig(configPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	sched := scheduler.NewScheduler(fetcher.NewGitFetcher("", ""))
+	t.Cleanup(sched.Stop)
+	router = gin.New()
+	NewHandler(sched, configPath).SetupRoutes(router)
+	return router, configPath, dir
+}
+
+// doJSON sends body to path. Changes carry If-Match: *, which every config
+// change requires.
+func doJSON(router *gin.Engine, method, path, body string) (int, map[string]any) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if method != "GET" {
+		req.Header.Set("If-Match", "*")
+	}
+	router.ServeHTTP(w, req)
+	var response map[string]any
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestReposCRUD(t *testing.T) {
+	router, configPath, _ := setupReposRouter(t)
+
+	code, response := doJSON(router, "GET", "/api/repos", "")
+	if repos, _ := response["repos"].([]any); code != http.StatusOK || len(repos) != 2 {
+		t.Fatalf("Expected 2 repos, got %d %v", code, response)
+	}
+	if code, _ := doJSON(router, "GET", "/api/repos/missing", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown repo, got %d", code)
+	}
+
+	created := `{"name": "repo3", "url": "https://github.com/user/repo3.git", "local_path": "/repos/repo3.git", "interval": "10m"}`
+	if code, response := doJSON(router, "POST", "/api/repos", created); code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %v", code, response)
+	}
+	if code, _ := doJSON(router, "POST", "/api/repos", created); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate name, got %d", code)
+	}
+	if code, _ := doJSON(router, "POST", "/api/repos", `{"name": "bad", "url": "x", "local_path": "/x", "interval": "soon"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid interval, got %d", code)
+	}
+	if code, _ := doJSON(router, "POST", "/api/repos", `{"name": "typo", "intervall": "5m"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field, got %d", code)
+	}
+
+	// Only the patched fields change, null removes one
+	code, response = doJSON(router, "PATCH", "/api/repos/repo2", `{"interval": "30m", "timeout": null}`)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %v", code, response)
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo2 := cfg.Repos[cfg.FindRepo("repo2")]
+	if repo2.Interval != "30m" || repo2.Timeout != "" || repo2.URL != "git@github.com:user/repo2.git" {
+		t.Errorf("Expected interval changed and timeout removed, got %+v", repo2)
+	}
+	if len(cfg.Repos) != 3 {
+		t.Errorf("Expected 3 repos, got %d", len(cfg.Repos))
+	}
+
+	if code, _ := doJSON(router, "PATCH", "/api/repos/repo2", `{"name": "repo1"}`); code != http.StatusConflict {
+		t.Errorf("Expected 409 renaming onto an existing repo, got %d", code)
+	}
+	if code, _ := doJSON(router, "PATCH", "/api/repos/repo2", `{"interval": ""}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid patch result, got %d", code)
+	}
+	if code, _ := doJSON(router, "PATCH", "/api/repos/repo2", `["interval"]`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-object patch, got %d", code)
+	}
+
+	// A stale If-Match is rejected
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/api/repos/repo1", bytes.NewBufferString(`{"interval": "2h"}`))
+	req.Header.Set("If-Match", `"stale"`)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a stale If-Match, got %d", w.Code)
+	}
+	for _, method := range []string{"PATCH", "DELETE"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(method, "/api/repos/repo1", bytes.NewBufferString(`{"interval": "2h"}`))
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusPreconditionRequired {
+			t.Errorf("Expected 428 for %s without If-Match, got %d", method, w.Code)
+		}
+	}
+
+	if code, _ := doJSON(router, "DELETE", "/api/repos/repo3", ""); code != http.StatusOK {
+		t.Errorf("Expected 200 deleting a repo, got %d", code)
+	}
+	if code, _ := doJSON(router, "GET", "/api/repos/repo3", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 after deleting, got %d", code)
+	}
+}
+
+func TestDeleteRepoRemovesMirror(t *testing.T) {
+	router, _, dir := setupReposRouter(t)
+	mirror := filepath.Join(dir, "repo1.git")
+	if err := os.MkdirAll(filepath.Join(mirror, "refs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the confirmation nothing is removed, not even the config entry
+	if code, _ := doJSON(router, "DELETE", "/api/repos/repo1?remove_mirror=true", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without confirm, got %d", code)
+	}
+	if code, _ := doJSON(router, "GET", "/api/repos/repo1", ""); code != http.StatusOK {
+		t.Fatalf("Expected repo1 to be kept, got %d", code)
+	}
+
+	code, response := doJSON(router, "DELETE", "/api/repos/repo1?remove_mirror=true&confirm=repo1", "")
+	if code != http.StatusOK || response["mirror_removed"] != true {
+		t.Fatalf("Expected the mirror to be removed, got %d %v", code, response)
+	}
+	if _, err := os.Stat(mirror); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", mirror, err)
+	}
+}
+
+func TestCheckMirrorRemovable(t *testing.T) {
+	repos := []config.RepoConfig{
+		{Name: "other", LocalPath: "/repos/group"},
+		{Name: "discovered", LocalPath: "/repos/acme/app.git", Source: "acme"},
+		{Name: "mine", LocalPath: "/repos/mine.git"},
+	}
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/repos/mine.git", true},
+		{"/repos/../repos/mine.git", true},
+		{"relative/mine.git", false},
+		{"/", false},
+		{"/app", false},
+		{"/repos", false},
+		{"/repos/../app", false},
+		{"/repos/group", false},
+		{"/repos/group/nested.git", false},
+		{"/repos/acme", false},
+	}
+	for _, tt := range tests {
+		err := checkMirrorRemovable("/repos", repos, config.RepoConfig{Name: "mine", LocalPath: tt.path})
+		if (err == nil) != tt.ok {
+			t.Errorf("checkMirrorRemovable(%s) = %v, want ok=%v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestPauseResumeRepo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	defer sched.Stop()
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+	sched.LoadConfig(&config.Config{
+		Repos: []config.RepoConfig{
+			{Name: "repo1", URL: "git@github.com:user/repo1.git", LocalPath: "/repos/repo1.git", Interval: "1h"},
+		},
+		HTTPPort: 8080,
+	})
+
+	if code, _ := doJSON(router, "POST", "/api/repos/missing/pause", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown repo, got %d", code)
+	}
+	if code, _ := doJSON(router, "POST", "/api/repos/repo1/pause", `{"duration": "2h", "until": "2030-01-01T00:00:00Z"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for both duration and until, got %d", code)
+	}
+	if code, _ := doJSON(router, "POST", "/api/repos/repo1/pause", `{"until": "2001-01-01T00:00:00Z"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an until in the past, got %d", code)
+	}
+
+	code, response := doJSON(router, "POST", "/api/repos/repo1/pause", `{"duration": "2h", "reason": "upstream migration"}`)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %v", code, response)
+	}
+	status := sched.GetStatus()["repo1"]
+	if !status.Paused || status.PauseReason != "upstream migration" || time.Until(status.PausedUntil) < time.Hour {
+		t.Errorf("Expected a 2h pause, got %+v", status)
+	}
+
+	if code, _ := doJSON(router, "POST", "/api/repos/repo1/resume", ""); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if code, _ := doJSON(router, "POST", "/api/repos/repo1/resume", ""); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a repo that is not paused, got %d", code)
+	}
+	if sched.GetStatus()["repo1"].Paused {
+		t.Error("Expected repo1 to be resumed")
+	}
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+// handleListSources returns the discovery status of every configured source
+func (h *Handler) handleListSources(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"sources": h.scheduler.Sources(),
+	})
+}
+
+// handleDiscoverSource lists a source now, repos it adds or retires are
+// announced on /api/events
+func (h *Handler) handleDiscoverSource(c *gin.Context) {
+	name := c.Param("name")
+	if err := h.scheduler.Discover(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrUnknownSource) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "discovery triggered for " + name,
+	})
+}
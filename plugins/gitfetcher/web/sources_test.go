@@ -0,0 +1,50 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"colosscious.com/gitfetcher/config"
+	"colosscious.com/gitfetcher/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer api.Close()
+
+	sched := scheduler.NewScheduler(okFetcher{})
+	defer sched.Stop()
+	sched.LoadConfig(&config.Config{
+		Sources: []config.SourceConfig{
+			{Name: "acme", Provider: config.SourceGitHub, URL: api.URL, Org: "acme", Interval: "1h"},
+		},
+		HTTPPort: 8080,
+	})
+	router := gin.New()
+	NewHandler(sched, "/tmp/test.yaml").SetupRoutes(router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/sources", nil)
+	router.ServeHTTP(w, req)
+	var resp struct {
+		Sources []scheduler.SourceStatus `json:"sources"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Sources) != 1 || resp.Sources[0].Owner != "acme" {
+		t.Errorf("Expected the acme source, got %s", w.Body.String())
+	}
+
+	for name, want := range map[string]int{"acme": http.StatusAccepted, "other": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/sources/"+name+"/discover", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("Discover %s: expected %d, got %d", name, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported webhook providers, each is served on /hooks/<provider>
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+)
+
+// Kinds of deliveries
+const (
+	KindPush   = "push"   // refs changed, the repo should be fetched
+	KindPing   = "ping"   // sent by GitHub and Gitea when a hook is created
+	KindIgnore = "ignore" // any other event
+)
+
+// ErrUnknownProvider is returned for a provider that is not supported
+var ErrUnknownProvider = errors.New("unknown webhook provider")
+
+// ErrSignature is returned when a delivery is not signed with the shared secret
+var ErrSignature = errors.New("invalid webhook signature")
+
+// Event is a verified webhook delivery
+type Event struct {
+	Provider string
+	Kind     string
+	Name     string   // the provider's event name, e.g. "push" or "Tag Push Hook"
+	URLs     []string // clone, ssh and web URLs of the repository
+}
+
+// Verify checks that body was sent by a provider knowing secret: an
+// HMAC-SHA256 signature for GitHub and Gitea, the X-Gitlab-Token for GitLab
+func Verify(provider string, header http.Header, body []byte, secret string) error {
+	switch provider {
+	case ProviderGitHub:
+		return verifyHMAC(strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="), body, secret)
+	case ProviderGitea:
+		signature := header.Get("X-Gitea-Signature")
+		if signature == "" {
+			signature = strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		}
+		return verifyHMAC(signature, body, secret)
+	case ProviderGitLab:
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return ErrSignature
+		}
+		return nil
+	}
+	return ErrUnknownProvider
+}
+
+// verifyHMAC compares a hex encoded HMAC-SHA256 of body in constant time
+func verifyHMAC(signature string, body []byte, secret string) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return ErrSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Parse decodes a delivery that has passed Verify
+func Parse(provider string, header http.Header, body []byte) (*Event, error) {
+	e := &Event{Provider: provider, Kind: KindIgnore}
+	switch provider {
+	case ProviderGitHub:
+		e.Name = header.Get("X-GitHub-Event")
+		switch e.Name {
+		case "push":
+			e.Kind = KindPush
+		case "ping":
+			e.Kind = KindPing
+		}
+	case ProviderGitea:
+		e.Name = header.Get("X-Gitea-Event")
+		switch e.Name {
+		case "push", "create", "delete":
+			e.Kind = KindPush
+		case "ping":
+			e.Kind = KindPing
+		}
+	case ProviderGitLab:
+		e.Name = header.Get("X-Gitlab-Event")
+		if e.Name == "Push Hook" || e.Name == "Tag Push Hook" {
+			e.Kind = KindPush
+		}
+	default:
+		return nil, ErrUnknownProvider
+	}
+	if e.Kind != KindPush {
+		return e, nil
+	}
+
+	// GitHub and Gitea describe the repository alike, GitLab has project
+	// (current) and repository (deprecated)
+	var payload struct {
+		Repository struct {
+			CloneURL   string `json:"clone_url"`
+			SSHURL     string `json:"ssh_url"`
+			HTMLURL    string `json:"html_url"`
+			GitSSHURL  string `json:"git_ssh_url"`
+			GitHTTPURL string `json:"git_http_url"`
+			URL        string `json:"url"`
+			Homepage   string `json:"homepage"`
+		} `json:"repository"`
+		Project struct {
+			GitSSHURL  string `json:"git_ssh_url"`
+			GitHTTPURL string `json:"git_http_url"`
+			WebURL     string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %w", provider, err)
+	}
+	r, p := payload.Repository, payload.Project
+	for _, u := range []string{
+		r.CloneURL, r.SSHURL, r.HTMLURL, r.GitSSHURL, r.GitHTTPURL, r.URL, r.Homepage,
+		p.GitSSHURL, p.GitHTTPURL, p.WebURL,
+	} {
+		if u != "" {
+			e.URLs = append(e.URLs, u)
+		}
+	}
+	if len(e.URLs) == 0 {
+		return nil, fmt.Errorf("invalid %s payload: no repository URL", provider)
+	}
+	return e, nil
+}
+
+// Matches reports whether remote points at the repository of the event
+func (e *Event) Matches(remote string) bool {
+	key := RemoteKey(remote)
+	if key == "" {
+		return false
+	}
+	for _, u := range e.URLs {
+		if RemoteKey(u) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteKey reduces a git remote to host/path, so the https, ssh:// and
+// scp-like (git@host:path) URLs of a repository compare equal. The user,
+// port, a trailing .git and case are ignored. It is empty for local paths.
+func RemoteKey(remote string) string {
+	remote = strings.TrimSpace(remote)
+	var host, path string
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return ""
+		}
+		host, path = u.Hostname(), u.Path
+	} else {
+		colon := strings.Index(remote, ":")
+		if colon <= 0 || strings.ContainsAny(remote[:colon], "/\\") {
+			return ""
+		}
+		host, path = remote[:colon], remote[colon+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return ""
+	}
+	return strings.ToLower(host + "/" + path)
+}
+
+// Debouncer coalesces a burst of triggers per key into one call
+type Debouncer struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// NewDebouncer returns an idle Debouncer
+func NewDebouncer() *Debouncer {
+	return &Debouncer{timers: make(map[string]*time.Timer)}
+}
+
+// Trigger calls fn delay after the first trigger of key. Triggers of the same
+// key until then are dropped and Trigger returns false for them.
+func (d *Debouncer) Trigger(key string, delay time.Duration, fn func()) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, pending := d.timers[key]; pending {
+		return false
+	}
+	d.timers[key] = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		delete(d.timers, key)
+		d.mu.Unlock()
+		fn()
+	})
+	return true
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestRemoteKey(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"git@github.com:User/Repo.git", "github.com/user/repo"},
+		{"https://github.com/user/repo", "github.com/user/repo"},
+		{"https://x-access-token@github.com/user/repo.git/", "github.com/user/repo"},
+		{"ssh://git@gitlab.example.com:2222/group/sub/repo.git", "gitlab.example.com/group/sub/repo"},
+		{"/srv/git/repo.git", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := RemoteKey(tt.remote); got != tt.want {
+			t.Errorf("RemoteKey(%q) = %q, want %q", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/main"}`)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		header   http.Header
+		wantErr  error
+	}{
+		{"github", ProviderGitHub, header("X-Hub-Signature-256", "sha256="+sign(body, "s3cret")), nil},
+		{"github wrong secret", ProviderGitHub, header("X-Hub-Signature-256", "sha256="+sign(body, "other")), ErrSignature},
+		{"github unsigned", ProviderGitHub, header(), ErrSignature},
+		{"gitea", ProviderGitea, header("X-Gitea-Signature", sign(body, "s3cret")), nil},
+		{"gitea hub header", ProviderGitea, header("X-Hub-Signature-256", "sha256="+sign(body, "s3cret")), nil},
+		{"gitlab", ProviderGitLab, header("X-Gitlab-Token", "s3cret"), nil},
+		{"gitlab wrong token", ProviderGitLab, header("X-Gitlab-Token", "guess"), ErrSignature},
+		{"unknown provider", "bitbucket", header(), ErrUnknownProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.provider, tt.header, body, "s3cret"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	github := []byte(`{"ref": "refs/heads/main", "repository": {"clone_url": "https://github.com/user/repo.git", "ssh_url": "git@github.com:user/repo.git"}}`)
+	e, err := Parse(ProviderGitHub, http.Header{"X-Github-Event": {"push"}}, github)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if e.Kind != KindPush || !e.Matches("ssh://git@github.com/user/repo") || e.Matches("git@github.com:user/other.git") {
+		t.Errorf("Unexpected GitHub event %+v", e)
+	}
+
+	gitlab := []byte(`{"object_kind": "tag_push", "project": {"git_ssh_url": "git@gitlab.com:group/repo.git", "git_http_url": "https://gitlab.com/group/repo.git"}}`)
+	e, err = Parse(ProviderGitLab, http.Header{"X-Gitlab-Event": {"Tag Push Hook"}}, gitlab)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if e.Kind != KindPush || !e.Matches("https://gitlab.com/group/repo") {
+		t.Errorf("Unexpected GitLab event %+v", e)
+	}
+
+	e, err = Parse(ProviderGitea, http.Header{"X-Gitea-Event": {"issues"}}, []byte(`{}`))
+	if err != nil || e.Kind != KindIgnore {
+		t.Errorf("Expected other Gitea events to be ignored, got %+v %v", e, err)
+	}
+	if e, _ := Parse(ProviderGitHub, http.Header{"X-Github-Event": {"ping"}}, []byte(`{}`)); e.Kind != KindPing {
+		t.Errorf("Expected a ping, got %+v", e)
+	}
+	if _, err := Parse(ProviderGitHub, http.Header{"X-Github-Event": {"push"}}, []byte(`{"repository": {}}`)); err == nil {
+		t.Error("Expected an error for a push without repository URL")
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	d := NewDebouncer()
+	var calls atomic.Int32
+	fn := func() { calls.Add(1) }
+
+	if !d.Trigger("repo", 50*time.Millisecond, fn) {
+		t.Error("Expected the first trigger to be scheduled")
+	}
+	for i := 0; i < 5; i++ {
+		if d.Trigger("repo", 50*time.Millisecond, fn) {
+			t.Error("Expected further triggers to be coalesced")
+		}
+	}
+	d.Trigger("other", 50*time.Millisecond, fn)
+
+	time.Sleep(100 * time.Millisecond)
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected one call per key, got %d", got)
+	}
+	if !d.Trigger("repo", 10*time.Millisecond, fn) {
+		t.Error("Expected a trigger after the call to be scheduled again")
+	}
+}